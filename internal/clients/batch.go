@@ -0,0 +1,200 @@
+package clients
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grafana/dskit/backoff"
+)
+
+// BatchResult describes the outcome of sending a batch of logs
+type BatchResult struct {
+	// Entries is the number of logs in the batch
+	Entries int
+	// Workers is the number of logs in the batch by the worker which sent them
+	Workers map[string]int
+	// Bytes is the size of the request body
+	Bytes int
+	// Rejected is the number of logs dropped by a receiver which accepted the batch
+	Rejected int
+	// Duration is the time spent sending the batch including retries
+	Duration time.Duration
+	// Err is the error which caused the batch to be dropped, if any
+	Err error
+}
+
+// BatchObserver is called with the outcome of every batch sent
+type BatchObserver func(BatchResult)
+
+// BatchConfig describes when batches are flushed and how failed batches are retried
+type BatchConfig struct {
+	// Size is the number of bytes after which a batch is flushed
+	Size int
+	// Wait is the maximum time a log waits in a batch before it is flushed
+	Wait time.Duration
+	// Backoff configures the retries of failed batches
+	Backoff backoff.Config
+}
+
+// minBatchTick is the shortest interval at which the age of a batch is checked
+const minBatchTick = time.Millisecond
+
+// batcher collects entries in batches and flushes them from a single goroutine. The
+// context passed to flush is cancelled once the batcher stops, failed batches are not
+// retried anymore then.
+type batcher[T any] struct {
+	cfg     BatchConfig
+	entries chan batchEntry[T]
+	size    func(T) int
+	flush   func(context.Context, []T, map[string]int)
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// batchEntry is an entry along with the worker which sent it
+type batchEntry[T any] struct {
+	worker string
+	entry  T
+}
+
+// retryableError marks errors of requests which can be retried
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+func newBatcher[T any](cfg BatchConfig, size func(T) int, flush func(context.Context, []T, map[string]int)) *batcher[T] {
+	b := &batcher[T]{
+		cfg:     cfg,
+		entries: make(chan batchEntry[T], 1024),
+		size:    size,
+		flush:   flush,
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+
+	b.wg.Add(1)
+	go b.run()
+	return b
+}
+
+// Add adds an entry sent by the given worker to the current batch. It blocks while
+// the batches are flushed.
+func (b *batcher[T]) Add(worker string, entry T) {
+	b.entries <- batchEntry[T]{worker: worker, entry: entry}
+}
+
+// Stop stops retrying failed batches, flushes the last batch and waits for it to be sent
+func (b *batcher[T]) Stop() {
+	b.cancel()
+	close(b.entries)
+	b.wg.Wait()
+}
+
+func (b *batcher[T]) run() {
+	defer b.wg.Done()
+
+	tick := b.cfg.Wait / 10
+	if tick < minBatchTick {
+		tick = minBatchTick
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	var (
+		batch     []T
+		workers   = map[string]int{}
+		batchSize int
+		batchAge  time.Time
+	)
+	send := func() {
+		if len(batch) > 0 {
+			b.flush(b.ctx, batch, workers)
+		}
+		batch, batchSize, workers = nil, 0, map[string]int{}
+	}
+
+	for {
+		select {
+		case entry, ok := <-b.entries:
+			if !ok {
+				send()
+				return
+			}
+			if len(batch) == 0 {
+				batchAge = time.Now()
+			}
+			batch = append(batch, entry.entry)
+			workers[entry.worker]++
+			batchSize += b.size(entry.entry)
+			if batchSize >= b.cfg.Size {
+				send()
+			}
+		case <-ticker.C:
+			if len(batch) > 0 && time.Since(batchAge) >= b.cfg.Wait {
+				send()
+			}
+		}
+	}
+}
+
+// sendWithRetry sends a request body until it succeeds, fails with an error which can
+// not be retried, the retries are exhausted or the context is done. The body is sent
+// at least once.
+func sendWithRetry(ctx context.Context, cfg backoff.Config, send func() error) error {
+	retries := backoff.New(ctx, cfg)
+
+	var err error
+	for {
+		if err = send(); err == nil {
+			return nil
+		}
+		if _, ok := err.(retryableError); !ok {
+			return err
+		}
+		retries.Wait()
+		if !retries.Ongoing() {
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("giving up on shutdown after %d retries: %s", retries.NumRetries(), err)
+	}
+	return fmt.Errorf("giving up after %d retries: %s", retries.NumRetries(), err)
+}
+
+// checkResponse returns an error for unsuccessful responses, which can be retried for
+// server errors and rate limiting.
+func checkResponse(res *http.Response) error {
+	if res.StatusCode/100 == 2 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	err := fmt.Errorf("server returned %s: %s", res.Status, bytes.TrimSpace(body))
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode/100 == 5 {
+		return retryableError{err}
+	}
+	return err
+}
+
+func gzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -4,25 +4,85 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/elastic/go-elasticsearch/v6"
+	"github.com/elastic/go-elasticsearch/v6/esapi"
 	"github.com/elastic/go-elasticsearch/v6/esutil"
 	log "github.com/sirupsen/logrus"
 )
 
+// ElasticsearchBackend describes the distribution and major version of the cluster
+type ElasticsearchBackend string
+
 const (
-	IndexName = "logger"
+	// AutoBackend detects the backend from the version reported by the root endpoint
+	AutoBackend ElasticsearchBackend = "auto"
+
+	// Elasticsearch6Backend talks to Elasticsearch 6 clusters, which require document types
+	Elasticsearch6Backend ElasticsearchBackend = "elasticsearch6"
+
+	// Elasticsearch7Backend talks to Elasticsearch 7 clusters
+	Elasticsearch7Backend ElasticsearchBackend = "elasticsearch7"
+
+	// Elasticsearch8Backend talks to Elasticsearch 8 clusters, which reject document types
+	Elasticsearch8Backend ElasticsearchBackend = "elasticsearch8"
+
+	// OpenSearchBackend talks to OpenSearch clusters
+	OpenSearchBackend ElasticsearchBackend = "opensearch"
 )
 
-func NewElasticsearchClient(clientURL string) (*elasticsearch.Client, error) {
+// ElasticsearchConfig describes the settings of an Elasticsearch client
+type ElasticsearchConfig struct {
+	// URL is the address of the cluster
+	URL string
+	// Backend is the backend of the cluster, detected if empty or auto
+	Backend ElasticsearchBackend
+	// Username and Password are used for basic authentication if set
+	Username string
+	Password string
+	// APIKey is the base64 encoded API key, which overrides basic authentication if set
+	APIKey string
+	// DisableSecurityCheck deactivates the TLS checks
+	DisableSecurityCheck bool
+	// TLS configures the CA and client certificate of HTTPS connections
+	TLS TLSConfig
+}
+
+// ElasticsearchClient is an Elasticsearch client aware of the backend it talks to. The
+// REST API of the v6 client is compatible with all backends as long as the backend
+// specific parts of requests and responses are handled.
+type ElasticsearchClient struct {
+	*elasticsearch.Client
+	Backend ElasticsearchBackend
+
+	// version is the version number reported by the cluster, fetched on first use if
+	// the backend is configured
+	mu      sync.Mutex
+	version string
+}
+
+// clusterVersion describes the version reported by the root endpoint
+type clusterVersion struct {
+	Number       string `json:"number"`
+	Distribution string `json:"distribution"`
+}
+
+func NewElasticsearchClient(cfg ElasticsearchConfig) (*ElasticsearchClient, error) {
 	retryBackoff := backoff.NewExponentialBackOff()
-	client, err := elasticsearch.NewClient(elasticsearch.Config{
-		Addresses:     []string{clientURL},
+	esConfig := elasticsearch.Config{
+		Addresses:     []string{cfg.URL},
+		Username:      cfg.Username,
+		Password:      cfg.Password,
+		APIKey:        cfg.APIKey,
 		RetryOnStatus: []int{502, 503, 504, 429},
 		RetryBackoff: func(i int) time.Duration {
 			if i == 1 {
@@ -31,45 +91,243 @@ func NewElasticsearchClient(clientURL string) (*elasticsearch.Client, error) {
 			return retryBackoff.NextBackOff()
 		},
 		MaxRetries: 5,
-	})
+	}
+
+	if cfg.TLS != (TLSConfig{}) || cfg.DisableSecurityCheck {
+		cfg.TLS.InsecureSkipVerify = cfg.DisableSecurityCheck
+		tlsConfig, err := newTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		esConfig.Transport = transport
+	}
+
+	client, err := elasticsearch.NewClient(esConfig)
 	if err != nil {
 		return nil, fmt.Errorf("error creating the client: %s", err)
 	}
-	return client, nil
+
+	c := &ElasticsearchClient{
+		Client:  client,
+		Backend: cfg.Backend,
+	}
+	switch c.Backend {
+	case "", AutoBackend:
+		version, err := fetchVersion(client)
+		if err != nil {
+			return nil, fmt.Errorf("error detecting elasticsearch backend: %s", err)
+		}
+		if c.Backend, err = detectBackend(version); err != nil {
+			return nil, err
+		}
+		c.version = version.Number
+		log.Infof("detected elasticsearch backend %s version %s", c.Backend, c.version)
+	case Elasticsearch6Backend, Elasticsearch7Backend, Elasticsearch8Backend, OpenSearchBackend:
+	default:
+		return nil, fmt.Errorf("unknown elasticsearch backend: %s", c.Backend)
+	}
+	return c, nil
+}
+
+// Version returns the version number reported by the cluster
+func (c *ElasticsearchClient) Version() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.version == "" {
+		version, err := fetchVersion(c.Client)
+		if err != nil {
+			return "", fmt.Errorf("error fetching elasticsearch version: %s", err)
+		}
+		c.version = version.Number
+	}
+	return c.version, nil
 }
 
-func NewElasticsearchBulkIndexer(client *elasticsearch.Client) (esutil.BulkIndexer, error) {
+// fetchVersion reads the version reported by the root endpoint
+func fetchVersion(client *elasticsearch.Client) (clusterVersion, error) {
+	res, err := client.Info()
+	if err != nil {
+		return clusterVersion{}, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return clusterVersion{}, fmt.Errorf("%s", res.Status())
+	}
+
+	var info struct {
+		Version clusterVersion `json:"version"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return clusterVersion{}, fmt.Errorf("error parsing elasticsearch info: %s", err)
+	}
+	return info.Version, nil
+}
+
+// detectBackend picks the backend from the version reported by the root endpoint
+func detectBackend(version clusterVersion) (ElasticsearchBackend, error) {
+	if version.Distribution == "opensearch" {
+		return OpenSearchBackend, nil
+	}
+	major, _, _ := strings.Cut(version.Number, ".")
+	switch major {
+	case "6":
+		return Elasticsearch6Backend, nil
+	case "7":
+		return Elasticsearch7Backend, nil
+	case "8", "9":
+		return Elasticsearch8Backend, nil
+	default:
+		return "", fmt.Errorf("unsupported elasticsearch version %q", version.Number)
+	}
+}
+
+// versionAtLeast tells whether the version number is at least major.minor
+func versionAtLeast(version string, major, minor int) bool {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	gotMajor, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	gotMinor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	return gotMajor > major || gotMajor == major && gotMinor >= minor
+}
+
+// errBulkRequestFailed reports a bulk request which failed as a whole, the cause is
+// logged as the indexer reports it after the flush ended
+var errBulkRequestFailed = errors.New("bulk request failed")
+
+// ElasticsearchBulkObserver is notified about the outcome of bulk indexing, all
+// callbacks are optional
+type ElasticsearchBulkObserver struct {
+	// OnFlush is called with the outcome of every bulk request. Entries counts the
+	// documents with a response and Rejected the documents which failed to be written.
+	OnFlush BatchObserver
+	// OnFailure is called with the worker which sent the documents, the error type and
+	// the number of documents which failed to be written. The worker is empty for the
+	// documents of bulk requests which failed as a whole, reported with the request type.
+	OnFailure func(worker, errorType string, documents int)
+}
+
+// RequestFailure is the error type of documents of bulk requests which failed as a whole
+const RequestFailure = "request"
+
+// ElasticsearchBulkIndexer is a bulk indexer reporting the outcome of every document
+type ElasticsearchBulkIndexer struct {
+	esutil.BulkIndexer
+	observer ElasticsearchBulkObserver
+
+	// reported is the number of failed documents reported to the observer, the documents
+	// of failed bulk requests are only counted in the indexer stats
+	mu       sync.Mutex
+	reported uint64
+}
+
+type flushStateKey struct{}
+
+// flushState collects the outcome of a single bulk request. The callbacks of a flush
+// are called sequentially by the worker flushing.
+type flushState struct {
+	start  time.Time
+	result BatchResult
+}
+
+func NewElasticsearchBulkIndexer(client *ElasticsearchClient, index string, observer ElasticsearchBulkObserver) (*ElasticsearchBulkIndexer, error) {
+	// Document types are required by Elasticsearch 6 and removed afterwards
+	documentType := ""
+	if client.Backend == Elasticsearch6Backend {
+		documentType = "_doc"
+	}
+
+	indexer := &ElasticsearchBulkIndexer{observer: observer}
 	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
-		Index:         IndexName,        // The default index name
-		DocumentType:  "_doc",           // The default document type
-		Client:        client,           // The Elasticsearch client
+		Index:         index,            // The default index name
+		DocumentType:  documentType,     // The default document type
+		Client:        client.Client,    // The Elasticsearch client
 		NumWorkers:    runtime.NumCPU(), // The number of worker goroutines
 		FlushBytes:    int(5e+6),        // The flush threshold in bytes
 		FlushInterval: 2 * time.Second,  // The periodic flush interval
+
+		OnFlushStart: func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, flushStateKey{}, &flushState{start: time.Now()})
+		},
+		OnFlushEnd: func(ctx context.Context) {
+			state := flushStateFrom(ctx)
+			if state == nil || (state.result.Entries == 0 && state.result.Err == nil) {
+				return
+			}
+			state.result.Duration = time.Since(state.start)
+			if observer.OnFlush != nil {
+				observer.OnFlush(state.result)
+			}
+		},
+		OnError: func(ctx context.Context, err error) {
+			// Errors of a flush are reported again once the flush ended, with a
+			// meaningful message
+			if state := flushStateFrom(ctx); state != nil {
+				state.result.Err = errBulkRequestFailed
+				documents := indexer.reportRequestFailures()
+				state.result.Entries += documents
+				state.result.Rejected += documents
+				return
+			}
+			log.Errorf("error indexing documents: %s", err)
+		},
 	})
 	if err != nil {
 		return nil, fmt.Errorf("error creating the indexer: %s", err)
 	}
-	return bi, nil
+	indexer.BulkIndexer = bi
+	return indexer, nil
 }
 
-func RecreateElasticsearchIndex(client *elasticsearch.Client, index string) error {
-	if err := deleteIndex(client, index); err != nil {
-		return err
+// Close flushes the pending documents, waits for the bulk requests to end and reports
+// the failed documents not reported yet
+func (bi *ElasticsearchBulkIndexer) Close(ctx context.Context) error {
+	err := bi.BulkIndexer.Close(ctx)
+	bi.reportRequestFailures()
+	return err
+}
+
+// reportRequestFailures reports the failed documents the indexer counted but which were
+// not reported to the observer, i.e. the documents of the bulk requests which failed as a
+// whole, and returns their number. A document failing while another bulk request fails
+// as a whole may be reported with the request type.
+func (bi *ElasticsearchBulkIndexer) reportRequestFailures() int {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+
+	failed := bi.Stats().NumFailed
+	if failed <= bi.reported {
+		return 0
 	}
-	if err := createIndex(client, index); err != nil {
-		return err
+	documents := int(failed - bi.reported)
+	bi.reported = failed
+	if bi.observer.OnFailure != nil {
+		bi.observer.OnFailure("", RequestFailure, documents)
 	}
-	return nil
+	return documents
 }
 
-func SendLogWithElasticsearch(indexer esutil.BulkIndexer, logData []byte) error {
+// SendLogWithElasticsearch adds a document written by the given worker to the bulk indexer
+func SendLogWithElasticsearch(indexer *ElasticsearchBulkIndexer, worker, index, action string, logData []byte) error {
 	// Add an item to the BulkIndexer
 	err := indexer.Add(
 		context.Background(),
 		esutil.BulkIndexerItem{
+			// Index is the index, data stream or alias to write to
+			Index: index,
+
 			// Action field configures the operation to perform (index, create, delete, update)
-			Action: "index",
+			Action: action,
 
 			// DocumentID is the (optional) document ID
 			// DocumentID: strconv.Itoa(a.ID),
@@ -79,16 +337,14 @@ func SendLogWithElasticsearch(indexer esutil.BulkIndexer, logData []byte) error
 
 			// OnSuccess is called for each successful operation
 			OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
-				log.Infof("Injected doc ID: %v", res.DocumentID)
+				if state := flushStateFrom(ctx); state != nil {
+					state.result.Entries++
+				}
 			},
 
 			// OnFailure is called for each failed operation
 			OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
-				if err != nil {
-					log.Infof("ERROR: %s", err)
-				} else {
-					log.Infof("ERROR: %s: %s", res.Error.Type, res.Error.Reason)
-				}
+				indexer.onFailure(ctx, worker, res, err)
 			},
 		},
 	)
@@ -98,58 +354,156 @@ func SendLogWithElasticsearch(indexer esutil.BulkIndexer, logData []byte) error
 	return nil
 }
 
-func QueryLogsWithElasticsearch(client *elasticsearch.Client, index, query string) error {
-	res, err := client.Search(
+func (bi *ElasticsearchBulkIndexer) onFailure(ctx context.Context, worker string, res esutil.BulkIndexerResponseItem, err error) {
+	var errorType string
+	switch {
+	case err != nil:
+		// The document could not be added to a bulk request
+		errorType = "encoding"
+		log.Debugf("error encoding document: %s", err)
+	case res.Error.Type != "":
+		errorType = res.Error.Type
+		log.Debugf("error indexing document in %s: %s: %s", res.Index, res.Error.Type, res.Error.Reason)
+	default:
+		errorType = fmt.Sprintf("status_%d", res.Status)
+		log.Debugf("error indexing document in %s: status %d", res.Index, res.Status)
+	}
+
+	if state := flushStateFrom(ctx); state != nil {
+		state.result.Entries++
+		state.result.Rejected++
+	}
+
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	bi.reported++
+	if bi.observer.OnFailure != nil {
+		bi.observer.OnFailure(worker, errorType, 1)
+	}
+}
+
+func flushStateFrom(ctx context.Context) *flushState {
+	state, _ := ctx.Value(flushStateKey{}).(*flushState)
+	return state
+}
+
+// SearchTotal is the number of hits of a search, reported as number by Elasticsearch 6
+// and as object by later backends
+type SearchTotal int
+
+func (t *SearchTotal) UnmarshalJSON(data []byte) error {
+	var value int
+	if err := json.Unmarshal(data, &value); err == nil {
+		*t = SearchTotal(value)
+		return nil
+	}
+
+	var total struct {
+		Value int `json:"value"`
+	}
+	if err := json.Unmarshal(data, &total); err != nil {
+		return err
+	}
+	*t = SearchTotal(total.Value)
+	return nil
+}
+
+// SearchResponse describes the parts of an Elasticsearch search response used by the clients
+type SearchResponse struct {
+	Took     int
+	ScrollID string `json:"_scroll_id"`
+	PitID    string `json:"pit_id"`
+	Hits     struct {
+		Total SearchTotal
+		Hits  []SearchHit
+	}
+	Aggregations json.RawMessage `json:"aggregations"`
+}
+
+// SearchHit describes a single document returned by a search
+type SearchHit struct {
+	ID         string          `json:"_id"`
+	Source     json.RawMessage `json:"_source"`
+	Highlights json.RawMessage `json:"highlight"`
+	Sort       []interface{}   `json:"sort"`
+}
+
+// QueryLogsWithElasticsearch executes a search and returns the number of hits
+func QueryLogsWithElasticsearch(client *ElasticsearchClient, index, query string) (QueryResult, error) {
+	r, err := SearchWithElasticsearch(client, index, query)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	log.Infof("elasticsearch query complete. status is %s, %d results, took %f \n", "success", r.Hits.Total, float64(r.Took)/1000)
+	return QueryResult{
+		Results:  int(r.Hits.Total),
+		ExecTime: time.Duration(r.Took) * time.Millisecond,
+		Hits:     r.Hits.Hits,
+	}, nil
+}
+
+// SearchWithElasticsearch executes a search and returns the decoded response
+func SearchWithElasticsearch(client *ElasticsearchClient, index, query string, o ...func(*esapi.SearchRequest)) (*SearchResponse, error) {
+	opts := append([]func(*esapi.SearchRequest){
 		client.Search.WithIndex(index),
 		client.Search.WithBody(strings.NewReader(query)),
-	)
-	defer res.Body.Close()
+	}, o...)
 
+	return decodeSearchResponse(client.Search(opts...))
+}
+
+// decodeSearchResponse decodes the response of a search or scroll request
+func decodeSearchResponse(res *esapi.Response, err error) (*SearchResponse, error) {
 	if err != nil {
-		return fmt.Errorf("error getting search response: %s", err)
-	}
-
-	type envelopeResponse struct {
-		Took int
-		Hits struct {
-			Total int
-			Hits  []struct {
-				ID         string          `json:"_id"`
-				Source     json.RawMessage `json:"_source"`
-				Highlights json.RawMessage `json:"highlight"`
-				Sort       []interface{}   `json:"sort"`
-			}
+		return nil, &QueryError{Err: fmt.Errorf("error getting search response: %s", err)}
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, &QueryError{
+			StatusCode: res.StatusCode,
+			Err:        fmt.Errorf("error getting search response: %s", res.Status()),
 		}
 	}
 
-	var r envelopeResponse
+	var r SearchResponse
 	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
-		return fmt.Errorf("error parsing search response: %s", err)
+		return nil, fmt.Errorf("error parsing search response: %s", err)
 	}
-
-	log.Infof("elasticsearch query complete. status is %s, %d results, took %f \n", "success", r.Hits.Total, float64(r.Took)/1000)
-	return nil
+	return &r, nil
 }
 
-func createIndex(client *elasticsearch.Client, index string) error {
-	res, err := client.Indices.Create(index)
-	defer res.Body.Close()
+func createIndex(client *ElasticsearchClient, index string, body []byte) error {
+	opts := []func(*esapi.IndicesCreateRequest){}
+	if body != nil {
+		opts = append(opts, client.Indices.Create.WithBody(bytes.NewReader(body)))
+	}
 
-	if err != nil || res.IsError() {
+	res, err := client.Indices.Create(index, opts...)
+	if err != nil {
 		return fmt.Errorf("error creating index %s: %s", index, err)
 	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error creating index %s: %s", index, res)
+	}
 	return nil
 }
 
-func deleteIndex(client *elasticsearch.Client, index string) error {
+func deleteIndex(client *ElasticsearchClient, index string) error {
 	res, err := client.Indices.Delete(
 		[]string{index},
 		client.Indices.Delete.WithIgnoreUnavailable(true),
 	)
+	if err != nil {
+		return fmt.Errorf("error deleting index %s: %s", index, err)
+	}
 	defer res.Body.Close()
 
-	if err != nil || res.IsError() {
-		return fmt.Errorf("error deleting index %s: %s", index, err)
+	if res.IsError() {
+		return fmt.Errorf("error deleting index %s: %s", index, res)
 	}
 	return nil
 }
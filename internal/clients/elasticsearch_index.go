@@ -0,0 +1,308 @@
+package clients
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+	log "github.com/sirupsen/logrus"
+)
+
+// IndexStrategy describes how the indices logs are written to are named
+type IndexStrategy string
+
+const (
+	// StaticIndexStrategy writes all logs to a single index
+	StaticIndexStrategy IndexStrategy = "static"
+
+	// DailyIndexStrategy writes logs to one index per day, e.g. app-2006.01.02
+	DailyIndexStrategy IndexStrategy = "daily"
+
+	// NamespaceIndexStrategy writes logs to one index per namespace, e.g. app-cookie-jar
+	NamespaceIndexStrategy IndexStrategy = "namespace"
+
+	// DataStreamIndexStrategy writes logs to a data stream
+	DataStreamIndexStrategy IndexStrategy = "data-stream"
+
+	// AliasIndexStrategy writes logs to a write alias, bootstrapped with the index <alias>-000001
+	AliasIndexStrategy IndexStrategy = "alias"
+)
+
+// ElasticsearchIndexConfig describes where logs are written to and how the indices are set up
+type ElasticsearchIndexConfig struct {
+	// Name is the index, the prefix of the indices, the data stream or the alias,
+	// depending on the strategy
+	Name string
+	// Strategy is the strategy naming the indices
+	Strategy IndexStrategy
+	// Recreate deletes all indices matching the strategy before logs are written
+	Recreate bool
+	// TemplateFile is a JSON index template applied before logs are written, if set
+	TemplateFile string
+	// MappingsFile is the JSON body, i.e. the settings and mappings, of the indices
+	// created by the client, if set
+	MappingsFile string
+}
+
+// Validate checks the strategy of the index configuration
+func (c ElasticsearchIndexConfig) Validate() error {
+	switch c.Strategy {
+	case StaticIndexStrategy, DailyIndexStrategy, NamespaceIndexStrategy, DataStreamIndexStrategy, AliasIndexStrategy:
+		return nil
+	default:
+		return fmt.Errorf("unknown elasticsearch index strategy: %s", c.Strategy)
+	}
+}
+
+// Target returns the index, data stream or alias a log of the namespace created at t
+// is written to
+func (c ElasticsearchIndexConfig) Target(t time.Time, namespace string) string {
+	switch c.Strategy {
+	case DailyIndexStrategy:
+		return c.Name + "-" + t.UTC().Format("2006.01.02")
+	case NamespaceIndexStrategy:
+		return c.Name + "-" + namespace
+	default:
+		return c.Name
+	}
+}
+
+// Pattern returns the index pattern matching all logs written with the configuration
+func (c ElasticsearchIndexConfig) Pattern() string {
+	switch c.Strategy {
+	case DailyIndexStrategy, NamespaceIndexStrategy:
+		return c.Name + "-*"
+	default:
+		return c.Name
+	}
+}
+
+// Action returns the bulk action writing logs, data streams only accept creates
+func (c ElasticsearchIndexConfig) Action() string {
+	if c.Strategy == DataStreamIndexStrategy {
+		return "create"
+	}
+	return "index"
+}
+
+// SetupElasticsearchIndex applies the index template, deletes the existing indices if
+// requested and creates the index, data stream or alias logs are written to
+func SetupElasticsearchIndex(client *ElasticsearchClient, cfg ElasticsearchIndexConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.Strategy == DataStreamIndexStrategy && client.Backend == Elasticsearch6Backend {
+		return fmt.Errorf("data streams are not supported by %s", client.Backend)
+	}
+
+	var body []byte
+	if cfg.MappingsFile != "" {
+		data, err := os.ReadFile(cfg.MappingsFile)
+		if err != nil {
+			return fmt.Errorf("unable to read mappings: %s", err)
+		}
+		body = data
+	}
+
+	if cfg.TemplateFile != "" {
+		if err := putIndexTemplate(client, cfg.Name, cfg.TemplateFile); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Recreate {
+		if err := deleteIndices(client, cfg); err != nil {
+			return err
+		}
+	}
+
+	switch cfg.Strategy {
+	case StaticIndexStrategy:
+		return ensureIndex(client, cfg.Name, body)
+	case DataStreamIndexStrategy:
+		return ensureDataStream(client, cfg.Name)
+	case AliasIndexStrategy:
+		return ensureWriteAlias(client, cfg.Name, body)
+	default:
+		// The indices are created on the first write and configured by the template
+		if body != nil {
+			log.Warnf("mappings are not applied to indices created on write by the %s strategy, use an index template instead", cfg.Strategy)
+		}
+		return nil
+	}
+}
+
+// putIndexTemplate applies a composable index template, or a legacy template on
+// Elasticsearch 6
+func putIndexTemplate(client *ElasticsearchClient, name, file string) error {
+	template, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("unable to read index template: %s", err)
+	}
+
+	var res *esapi.Response
+	if client.Backend == Elasticsearch6Backend {
+		res, err = client.Indices.PutTemplate(name, bytes.NewReader(template))
+	} else {
+		res, err = client.perform(http.MethodPut, "/_index_template/"+name, template)
+	}
+	if err != nil {
+		return fmt.Errorf("error putting index template %s: %s", name, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error putting index template %s: %s", name, res)
+	}
+	return nil
+}
+
+// deleteIndices deletes the indices or the data stream written to with the configuration
+func deleteIndices(client *ElasticsearchClient, cfg ElasticsearchIndexConfig) error {
+	switch cfg.Strategy {
+	case StaticIndexStrategy:
+		return deleteIndex(client, cfg.Name)
+	case DataStreamIndexStrategy:
+		res, err := client.perform(http.MethodDelete, "/_data_stream/"+cfg.Name, nil)
+		if err != nil {
+			return fmt.Errorf("error deleting data stream %s: %s", cfg.Name, err)
+		}
+		defer res.Body.Close()
+
+		if res.IsError() && res.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("error deleting data stream %s: %s", cfg.Name, res)
+		}
+		return nil
+	}
+
+	// Wildcard deletes are rejected by default since Elasticsearch 8, so the
+	// indices are resolved first
+	pattern := cfg.Name + "-*"
+	res, err := client.Cat.Indices(
+		client.Cat.Indices.WithIndex(pattern),
+		client.Cat.Indices.WithH("index"),
+		client.Cat.Indices.WithFormat("json"),
+	)
+	if err != nil {
+		return fmt.Errorf("error listing indices %s: %s", pattern, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error listing indices %s: %s", pattern, res)
+	}
+
+	var indices []struct {
+		Index string `json:"index"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return fmt.Errorf("error parsing indices %s: %s", pattern, err)
+	}
+	for _, index := range indices {
+		if err := deleteIndex(client, index.Index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureIndex creates an index unless it exists
+func ensureIndex(client *ElasticsearchClient, index string, body []byte) error {
+	exists, err := indexExists(client, index)
+	if err != nil || exists {
+		return err
+	}
+	return createIndex(client, index, body)
+}
+
+// ensureDataStream creates a data stream unless it exists. A matching index template
+// with data streams enabled is required.
+func ensureDataStream(client *ElasticsearchClient, name string) error {
+	res, err := client.perform(http.MethodGet, "/_data_stream/"+name, nil)
+	if err != nil {
+		return fmt.Errorf("error getting data stream %s: %s", name, err)
+	}
+	res.Body.Close()
+	if !res.IsError() {
+		return nil
+	}
+
+	res, err = client.perform(http.MethodPut, "/_data_stream/"+name, nil)
+	if err != nil {
+		return fmt.Errorf("error creating data stream %s: %s", name, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error creating data stream %s: %s", name, res)
+	}
+	return nil
+}
+
+// ensureWriteAlias bootstraps the first index of a write alias unless the alias exists
+func ensureWriteAlias(client *ElasticsearchClient, alias string, body []byte) error {
+	res, err := client.Indices.ExistsAlias([]string{alias})
+	if err != nil {
+		return fmt.Errorf("error checking alias %s: %s", alias, err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	settings := map[string]interface{}{}
+	if body != nil {
+		if err := json.Unmarshal(body, &settings); err != nil {
+			return fmt.Errorf("error parsing mappings: %s", err)
+		}
+	}
+	settings["aliases"] = map[string]interface{}{
+		alias: map[string]interface{}{"is_write_index": true},
+	}
+	if body, err = json.Marshal(settings); err != nil {
+		return err
+	}
+	return createIndex(client, alias+"-000001", body)
+}
+
+func indexExists(client *ElasticsearchClient, index string) (bool, error) {
+	res, err := client.Indices.Exists([]string{index})
+	if err != nil {
+		return false, fmt.Errorf("error checking index %s: %s", index, err)
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("error checking index %s: %s", index, res.Status())
+	}
+}
+
+// perform sends a request to an API which is not covered by the v6 client
+func (c *ElasticsearchClient) perform(method, path string, body []byte) (*esapi.Response, error) {
+	req, err := http.NewRequest(method, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.Perform(req)
+	if err != nil {
+		return nil, err
+	}
+	return &esapi.Response{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       res.Body,
+	}, nil
+}
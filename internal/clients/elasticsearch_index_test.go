@@ -0,0 +1,249 @@
+package clients
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// indexSetupStandIn records the requests setting up indices and answers them as if
+// the resources listed in existing were there
+type indexSetupStandIn struct {
+	t        *testing.T
+	existing map[string]bool
+	indices  []string
+
+	mu       sync.Mutex
+	requests []string
+	bodies   map[string]string
+}
+
+func (s *indexSetupStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.t.Errorf("error reading body: %s", err)
+	}
+
+	request := r.Method + " " + r.URL.Path
+	if r.URL.RawQuery != "" {
+		request += "?" + r.URL.RawQuery
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, request)
+	if len(body) > 0 {
+		s.bodies[request] = string(body)
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/_cat/indices/"):
+		var indices []string
+		for _, index := range s.indices {
+			indices = append(indices, fmt.Sprintf(`{"index":%q}`, index))
+		}
+		fmt.Fprintf(w, "[%s]", strings.Join(indices, ","))
+	case r.Method == http.MethodHead, r.Method == http.MethodGet:
+		if !s.existing[r.URL.Path] {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"not found"}`)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	default:
+		fmt.Fprint(w, `{"acknowledged":true}`)
+	}
+}
+
+func TestSetupElasticsearchIndex(t *testing.T) {
+	dir := t.TempDir()
+	mappings := filepath.Join(dir, "mappings.json")
+	if err := os.WriteFile(mappings, []byte(`{"mappings":{"properties":{"message":{"type":"text"}}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	template := filepath.Join(dir, "template.json")
+	if err := os.WriteFile(template, []byte(`{"index_patterns":["logs-*"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		backend  ElasticsearchBackend
+		cfg      ElasticsearchIndexConfig
+		existing []string
+		indices  []string
+		wantErr  bool
+		// wantBodies maps requests to their JSON body
+		wantRequests []string
+		wantBodies   map[string]string
+	}{
+		{
+			name:         "static",
+			backend:      Elasticsearch7Backend,
+			cfg:          ElasticsearchIndexConfig{Name: "logs", Strategy: StaticIndexStrategy, MappingsFile: mappings},
+			wantRequests: []string{"HEAD /logs", "PUT /logs"},
+			wantBodies:   map[string]string{"PUT /logs": `{"mappings":{"properties":{"message":{"type":"text"}}}}`},
+		},
+		{
+			name:         "static existing",
+			backend:      Elasticsearch7Backend,
+			cfg:          ElasticsearchIndexConfig{Name: "logs", Strategy: StaticIndexStrategy},
+			existing:     []string{"/logs"},
+			wantRequests: []string{"HEAD /logs"},
+		},
+		{
+			name:     "static recreated",
+			backend:  Elasticsearch7Backend,
+			cfg:      ElasticsearchIndexConfig{Name: "logs", Strategy: StaticIndexStrategy, Recreate: true},
+			existing: []string{"/logs"},
+			// The stand-in does not forget deleted indices
+			wantRequests: []string{"DELETE /logs?ignore_unavailable=true", "HEAD /logs"},
+		},
+		{
+			name:    "daily with template recreated",
+			backend: Elasticsearch8Backend,
+			cfg:     ElasticsearchIndexConfig{Name: "logs", Strategy: DailyIndexStrategy, Recreate: true, TemplateFile: template},
+			indices: []string{"logs-2024.05.01", "logs-2024.05.02"},
+			wantRequests: []string{
+				"PUT /_index_template/logs",
+				"GET /_cat/indices/logs-*?format=json&h=index",
+				"DELETE /logs-2024.05.01?ignore_unavailable=true",
+				"DELETE /logs-2024.05.02?ignore_unavailable=true",
+			},
+			wantBodies: map[string]string{"PUT /_index_template/logs": `{"index_patterns":["logs-*"]}`},
+		},
+		{
+			name:    "namespace with legacy template recreated",
+			backend: Elasticsearch6Backend,
+			cfg:     ElasticsearchIndexConfig{Name: "logs", Strategy: NamespaceIndexStrategy, Recreate: true, TemplateFile: template},
+			indices: []string{"logs-cookie-jar"},
+			wantRequests: []string{
+				"PUT /_template/logs",
+				"GET /_cat/indices/logs-*?format=json&h=index",
+				"DELETE /logs-cookie-jar?ignore_unavailable=true",
+			},
+			wantBodies: map[string]string{"PUT /_template/logs": `{"index_patterns":["logs-*"]}`},
+		},
+		{
+			name:    "data stream recreated",
+			backend: OpenSearchBackend,
+			cfg:     ElasticsearchIndexConfig{Name: "logs", Strategy: DataStreamIndexStrategy, Recreate: true, TemplateFile: template},
+			wantRequests: []string{
+				"PUT /_index_template/logs",
+				"DELETE /_data_stream/logs",
+				"GET /_data_stream/logs",
+				"PUT /_data_stream/logs",
+			},
+		},
+		{
+			name:         "data stream existing",
+			backend:      Elasticsearch7Backend,
+			cfg:          ElasticsearchIndexConfig{Name: "logs", Strategy: DataStreamIndexStrategy},
+			existing:     []string{"/_data_stream/logs"},
+			wantRequests: []string{"GET /_data_stream/logs"},
+		},
+		{
+			name:    "data stream on elasticsearch 6",
+			backend: Elasticsearch6Backend,
+			cfg:     ElasticsearchIndexConfig{Name: "logs", Strategy: DataStreamIndexStrategy},
+			wantErr: true,
+		},
+		{
+			name:         "alias",
+			backend:      Elasticsearch7Backend,
+			cfg:          ElasticsearchIndexConfig{Name: "logs", Strategy: AliasIndexStrategy, MappingsFile: mappings},
+			wantRequests: []string{"HEAD /_alias/logs", "PUT /logs-000001"},
+			wantBodies: map[string]string{
+				"PUT /logs-000001": `{"aliases":{"logs":{"is_write_index":true}},"mappings":{"properties":{"message":{"type":"text"}}}}`,
+			},
+		},
+		{
+			name:         "alias existing",
+			backend:      Elasticsearch7Backend,
+			cfg:          ElasticsearchIndexConfig{Name: "logs", Strategy: AliasIndexStrategy},
+			existing:     []string{"/_alias/logs"},
+			wantRequests: []string{"HEAD /_alias/logs"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			standIn := &indexSetupStandIn{t: t, existing: map[string]bool{}, indices: tt.indices, bodies: map[string]string{}}
+			for _, path := range tt.existing {
+				standIn.existing[path] = true
+			}
+			server := httptest.NewServer(standIn)
+			defer server.Close()
+
+			client, err := NewElasticsearchClient(ElasticsearchConfig{URL: server.URL, Backend: tt.backend})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = SetupElasticsearchIndex(client, tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+
+			standIn.mu.Lock()
+			defer standIn.mu.Unlock()
+			if !reflect.DeepEqual(standIn.requests, tt.wantRequests) {
+				t.Errorf("got requests %v, want %v", standIn.requests, tt.wantRequests)
+			}
+			for request, want := range tt.wantBodies {
+				var got, wantBody interface{}
+				if err := json.Unmarshal([]byte(standIn.bodies[request]), &got); err != nil {
+					t.Errorf("error decoding body of %s: %s", request, err)
+				}
+				if err := json.Unmarshal([]byte(want), &wantBody); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, wantBody) {
+					t.Errorf("got body %s of %s, want %s", standIn.bodies[request], request, want)
+				}
+			}
+		})
+	}
+}
+
+func TestElasticsearchIndexConfig(t *testing.T) {
+	at := time.Date(2024, 5, 1, 23, 0, 0, 0, time.FixedZone("UTC-2", -2*60*60))
+	tests := []struct {
+		strategy    IndexStrategy
+		wantTarget  string
+		wantPattern string
+		wantAction  string
+	}{
+		{strategy: StaticIndexStrategy, wantTarget: "logs", wantPattern: "logs", wantAction: "index"},
+		{strategy: DailyIndexStrategy, wantTarget: "logs-2024.05.02", wantPattern: "logs-*", wantAction: "index"},
+		{strategy: NamespaceIndexStrategy, wantTarget: "logs-cookie-jar", wantPattern: "logs-*", wantAction: "index"},
+		{strategy: DataStreamIndexStrategy, wantTarget: "logs", wantPattern: "logs", wantAction: "create"},
+		{strategy: AliasIndexStrategy, wantTarget: "logs", wantPattern: "logs", wantAction: "index"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			cfg := ElasticsearchIndexConfig{Name: "logs", Strategy: tt.strategy}
+			if err := cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.Target(at, "cookie-jar"); got != tt.wantTarget {
+				t.Errorf("got target %s, want %s", got, tt.wantTarget)
+			}
+			if got := cfg.Pattern(); got != tt.wantPattern {
+				t.Errorf("got pattern %s, want %s", got, tt.wantPattern)
+			}
+			if got := cfg.Action(); got != tt.wantAction {
+				t.Errorf("got action %s, want %s", got, tt.wantAction)
+			}
+		})
+	}
+}
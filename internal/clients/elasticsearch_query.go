@@ -0,0 +1,400 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ElasticsearchPaging describes how the results of a search are paged through
+type ElasticsearchPaging struct {
+	// PageSize is the number of documents requested per page
+	PageSize int
+	// Limit stops paging once at least as many documents were read
+	Limit int
+	// KeepAlive is how long Elasticsearch keeps the search context of a scroll or of the
+	// point in time of a search_after search
+	KeepAlive time.Duration
+}
+
+// ElasticsearchAggregation describes the aggregations run if the query defines none
+type ElasticsearchAggregation struct {
+	// Terms are the fields of nested terms aggregations
+	Terms []string
+	// Interval is the interval of a date histogram over created_at, the terms
+	// aggregations are nested in it
+	Interval time.Duration
+}
+
+// PageObserver is notified about every page read with the time the request took and
+// the number of documents it returned
+type PageObserver func(duration time.Duration, documents int)
+
+// ScrollWithElasticsearch pages through the results of a search with a scroll
+func ScrollWithElasticsearch(ctx context.Context, client *ElasticsearchClient, index, query string, paging ElasticsearchPaging, observe PageObserver) (QueryResult, error) {
+	search, err := parseSearch(query)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	search["size"] = paging.PageSize
+	body, err := json.Marshal(search)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	start := time.Now()
+	r, err := SearchWithElasticsearch(client, index, string(body), client.Search.WithScroll(paging.KeepAlive))
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	var result QueryResult
+	defer func() {
+		if r.ScrollID != "" {
+			clearScroll(client, r.ScrollID)
+		}
+	}()
+
+	for {
+		observe(time.Since(start), len(r.Hits.Hits))
+		result.add(r)
+		if len(r.Hits.Hits) == 0 || result.Results >= paging.Limit || ctx.Err() != nil {
+			return result, nil
+		}
+
+		next, err := json.Marshal(map[string]interface{}{
+			"scroll":    fmt.Sprintf("%dms", paging.KeepAlive.Milliseconds()),
+			"scroll_id": r.ScrollID,
+		})
+		if err != nil {
+			return result, err
+		}
+
+		start = time.Now()
+		page, err := decodeSearchResponse(client.perform(http.MethodPost, "/_search/scroll", next))
+		if err != nil {
+			return result, err
+		}
+		r = page
+	}
+}
+
+// SearchAfterWithElasticsearch pages through the results of a search with search_after.
+// The hits are sorted by created_at unless the query sorts them. Many documents share
+// the same created_at, so the sort is completed with a unique tiebreaker to keep
+// documents from being skipped or read twice: the _shard_doc of a point in time on
+// Elasticsearch 7.10 and OpenSearch 2.4 onwards, _id on earlier versions without points
+// in time.
+func SearchAfterWithElasticsearch(ctx context.Context, client *ElasticsearchClient, index, query string, paging ElasticsearchPaging, observe PageObserver) (QueryResult, error) {
+	search, err := parseSearch(query)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	search["size"] = paging.PageSize
+	if _, ok := search["sort"]; !ok {
+		search["sort"] = []interface{}{map[string]interface{}{"created_at": "asc"}}
+	}
+
+	searchPage := func(search map[string]interface{}) (*SearchResponse, error) {
+		body, err := json.Marshal(search)
+		if err != nil {
+			return nil, err
+		}
+		return SearchWithElasticsearch(client, index, string(body))
+	}
+	supported, err := pointInTimeSupported(client)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	tiebreaker := "_id"
+	if supported {
+		pit, err := openPointInTime(client, index, paging.KeepAlive)
+		if err != nil {
+			return QueryResult{}, err
+		}
+		defer pit.close()
+
+		searchPage = pit.search
+		tiebreaker = "_shard_doc"
+	}
+	search["sort"] = withTiebreaker(search["sort"], tiebreaker)
+
+	var result QueryResult
+	for {
+		start := time.Now()
+		r, err := searchPage(search)
+		if err != nil {
+			return result, err
+		}
+		observe(time.Since(start), len(r.Hits.Hits))
+		result.add(r)
+
+		if len(r.Hits.Hits) < paging.PageSize || result.Results >= paging.Limit || ctx.Err() != nil {
+			return result, nil
+		}
+		search["search_after"] = r.Hits.Hits[len(r.Hits.Hits)-1].Sort
+	}
+}
+
+// pointInTimeSupported tells whether the cluster has points in time, which came with
+// Elasticsearch 7.10 and OpenSearch 2.4
+func pointInTimeSupported(client *ElasticsearchClient) (bool, error) {
+	var major, minor int
+	switch client.Backend {
+	case Elasticsearch6Backend:
+		return false, nil
+	case Elasticsearch7Backend:
+		major, minor = 7, 10
+	case OpenSearchBackend:
+		major, minor = 2, 4
+	default:
+		return true, nil
+	}
+
+	version, err := client.Version()
+	if err != nil {
+		return false, err
+	}
+	return versionAtLeast(version, major, minor), nil
+}
+
+// withTiebreaker appends the tiebreaker to the sort unless it sorts by it already
+func withTiebreaker(sort interface{}, tiebreaker string) []interface{} {
+	fields, ok := sort.([]interface{})
+	if !ok {
+		fields = []interface{}{sort}
+	}
+	for _, field := range fields {
+		switch f := field.(type) {
+		case string:
+			if f == tiebreaker {
+				return fields
+			}
+		case map[string]interface{}:
+			if _, ok := f[tiebreaker]; ok {
+				return fields
+			}
+		}
+	}
+	return append(fields, map[string]interface{}{tiebreaker: "asc"})
+}
+
+// pointInTime is a point in time searches page through, which keeps the view of the
+// indices the same while paging
+type pointInTime struct {
+	client    *ElasticsearchClient
+	id        string
+	keepAlive string
+}
+
+// openPointInTime opens a point in time over the index, OpenSearch exposes it under
+// its own endpoint
+func openPointInTime(client *ElasticsearchClient, index string, keepAlive time.Duration) (*pointInTime, error) {
+	pit := &pointInTime{
+		client:    client,
+		keepAlive: fmt.Sprintf("%dms", keepAlive.Milliseconds()),
+	}
+
+	path := fmt.Sprintf("/%s/_pit?keep_alive=%s", index, pit.keepAlive)
+	if client.Backend == OpenSearchBackend {
+		path = fmt.Sprintf("/%s/_search/point_in_time?keep_alive=%s", index, pit.keepAlive)
+	}
+	res, err := client.perform(http.MethodPost, path, nil)
+	if err != nil {
+		return nil, &QueryError{Err: fmt.Errorf("error opening point in time: %s", err)}
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, &QueryError{
+			StatusCode: res.StatusCode,
+			Err:        fmt.Errorf("error opening point in time: %s", res.Status()),
+		}
+	}
+
+	var r struct {
+		ID    string `json:"id"`
+		PitID string `json:"pit_id"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("error parsing point in time: %s", err)
+	}
+	if pit.id = r.ID; pit.id == "" {
+		pit.id = r.PitID
+	}
+	return pit, nil
+}
+
+// search runs a search within the point in time, which takes the place of the index
+func (p *pointInTime) search(search map[string]interface{}) (*SearchResponse, error) {
+	search["pit"] = map[string]interface{}{"id": p.id, "keep_alive": p.keepAlive}
+	body, err := json.Marshal(search)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := decodeSearchResponse(p.client.perform(http.MethodPost, "/_search", body))
+	if err != nil {
+		return nil, err
+	}
+	// The id of a point in time may change with every search
+	if r.PitID != "" {
+		p.id = r.PitID
+	}
+	return r, nil
+}
+
+// close releases the point in time
+func (p *pointInTime) close() {
+	path := "/_pit"
+	body, _ := json.Marshal(map[string]interface{}{"id": p.id})
+	if p.client.Backend == OpenSearchBackend {
+		path = "/_search/point_in_time"
+		body, _ = json.Marshal(map[string]interface{}{"pit_id": []string{p.id}})
+	}
+
+	res, err := p.client.perform(http.MethodDelete, path, body)
+	if err != nil {
+		log.Warnf("error closing point in time: %s", err)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != http.StatusNotFound {
+		log.Warnf("error closing point in time: %s", res)
+	}
+}
+
+// AggregateWithElasticsearch runs the aggregations of a search and returns the number
+// of buckets. Queries defining no aggregations of their own run the given ones.
+func AggregateWithElasticsearch(client *ElasticsearchClient, index, query string, agg ElasticsearchAggregation) (QueryResult, error) {
+	search, err := parseSearch(query)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	search["size"] = 0
+
+	_, hasAggs := search["aggs"]
+	_, hasAggregations := search["aggregations"]
+	if !hasAggs && !hasAggregations {
+		aggs := aggregations(client.Backend, agg)
+		if aggs == nil {
+			return QueryResult{}, fmt.Errorf("no aggregations given")
+		}
+		search["aggs"] = aggs
+	}
+
+	body, err := json.Marshal(search)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	r, err := SearchWithElasticsearch(client, index, string(body))
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	var aggs interface{}
+	if len(r.Aggregations) > 0 {
+		if err := json.Unmarshal(r.Aggregations, &aggs); err != nil {
+			return QueryResult{}, fmt.Errorf("error parsing aggregations: %s", err)
+		}
+	}
+
+	buckets := bucketCount(aggs)
+	log.Infof("elasticsearch aggregation complete. %d buckets, took %f \n", buckets, float64(r.Took)/1000)
+	return QueryResult{
+		Results:  buckets,
+		ExecTime: time.Duration(r.Took) * time.Millisecond,
+	}, nil
+}
+
+// add accumulates the documents read and the execution time of a page
+func (r *QueryResult) add(page *SearchResponse) {
+	r.Results += len(page.Hits.Hits)
+	r.ExecTime += time.Duration(page.Took) * time.Millisecond
+	r.Hits = append(r.Hits, page.Hits.Hits...)
+}
+
+// parseSearch decodes a search body to set some of its fields
+func parseSearch(query string) (map[string]interface{}, error) {
+	var search map[string]interface{}
+	if err := json.Unmarshal([]byte(query), &search); err != nil {
+		return nil, fmt.Errorf("invalid search body: %s", err)
+	}
+	if search == nil {
+		search = map[string]interface{}{}
+	}
+	return search, nil
+}
+
+// clearScroll releases the search context of a scroll
+func clearScroll(client *ElasticsearchClient, scrollID string) {
+	body, _ := json.Marshal(map[string]interface{}{"scroll_id": []string{scrollID}})
+	res, err := client.perform(http.MethodDelete, "/_search/scroll", body)
+	if err != nil {
+		log.Warnf("error clearing scroll: %s", err)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != http.StatusNotFound {
+		log.Warnf("error clearing scroll: %s", res)
+	}
+}
+
+// aggregations builds nested terms aggregations, within a date histogram over
+// created_at if an interval is given
+func aggregations(backend ElasticsearchBackend, agg ElasticsearchAggregation) map[string]interface{} {
+	var aggs map[string]interface{}
+	for i := len(agg.Terms) - 1; i >= 0; i-- {
+		terms := map[string]interface{}{
+			"terms": map[string]interface{}{"field": agg.Terms[i]},
+		}
+		if aggs != nil {
+			terms["aggs"] = aggs
+		}
+		aggs = map[string]interface{}{agg.Terms[i]: terms}
+	}
+
+	if agg.Interval > 0 {
+		// Elasticsearch 6 predates fixed and calendar intervals
+		intervalKey := "fixed_interval"
+		if backend == Elasticsearch6Backend {
+			intervalKey = "interval"
+		}
+		histogram := map[string]interface{}{
+			"date_histogram": map[string]interface{}{
+				"field":     "created_at",
+				intervalKey: fmt.Sprintf("%dms", agg.Interval.Milliseconds()),
+			},
+		}
+		if aggs != nil {
+			histogram["aggs"] = aggs
+		}
+		aggs = map[string]interface{}{"created_at": histogram}
+	}
+	return aggs
+}
+
+// bucketCount returns the number of buckets of the aggregations, including nested ones
+func bucketCount(aggs interface{}) int {
+	var count int
+	switch v := aggs.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if buckets, ok := value.([]interface{}); key == "buckets" && ok {
+				count += len(buckets)
+			}
+			count += bucketCount(value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			count += bucketCount(value)
+		}
+	}
+	return count
+}
@@ -0,0 +1,204 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// searchAfterStandIn pages through documents sharing the same created_at, sorted by the
+// tiebreaker of the sort, and records the requests it receives
+type searchAfterStandIn struct {
+	t         *testing.T
+	documents int
+	version   clusterVersion
+
+	mu       sync.Mutex
+	requests []string
+	sorts    []interface{}
+}
+
+func (s *searchAfterStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	switch {
+	case r.URL.Path == "/":
+		json.NewEncoder(w).Encode(map[string]interface{}{"version": s.version})
+		return
+	case strings.HasSuffix(r.URL.Path, "/_pit"), strings.HasSuffix(r.URL.Path, "/point_in_time"):
+		if r.Method == http.MethodPost {
+			fmt.Fprint(w, `{"id":"pit-0","pit_id":"pit-0"}`)
+		} else {
+			fmt.Fprint(w, `{"succeeded":true}`)
+		}
+		return
+	}
+
+	var search struct {
+		Size        int           `json:"size"`
+		Sort        []interface{} `json:"sort"`
+		SearchAfter []interface{} `json:"search_after"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&search); err != nil {
+		s.t.Errorf("error decoding search: %s", err)
+	}
+	s.sorts = append(s.sorts, search.Sort)
+
+	from := 0
+	if len(search.SearchAfter) == 2 {
+		fmt.Sscanf(search.SearchAfter[1].(string), "doc-%d", &from)
+		from++
+	}
+	var hits []string
+	for i := from; i < s.documents && len(hits) < search.Size; i++ {
+		hits = append(hits, fmt.Sprintf(`{"_id":"doc-%d","_source":{},"sort":[1000,"doc-%d"]}`, i, i))
+	}
+	fmt.Fprintf(w, `{"took":1,"pit_id":"pit-0","hits":{"total":%d,"hits":[%s]}}`, s.documents, strings.Join(hits, ","))
+}
+
+func TestSearchAfterWithElasticsearch(t *testing.T) {
+	createdAt := map[string]interface{}{"created_at": "asc"}
+	tests := []struct {
+		backend ElasticsearchBackend
+		version clusterVersion
+		query   string
+		// wantSort is the sort of the searches, wantRequests the requests but the searches
+		wantSort     []interface{}
+		wantRequests []string
+	}{
+		{
+			backend:  Elasticsearch6Backend,
+			query:    `{}`,
+			wantSort: []interface{}{createdAt, map[string]interface{}{"_id": "asc"}},
+		},
+		{
+			backend:      Elasticsearch7Backend,
+			version:      clusterVersion{Number: "7.9.3"},
+			query:        `{"sort":"created_at"}`,
+			wantSort:     []interface{}{"created_at", map[string]interface{}{"_id": "asc"}},
+			wantRequests: []string{"GET /"},
+		},
+		{
+			backend:      Elasticsearch7Backend,
+			version:      clusterVersion{Number: "7.9.3"},
+			query:        `{"sort":[{"created_at":"desc"},{"_id":"desc"}]}`,
+			wantSort:     []interface{}{map[string]interface{}{"created_at": "desc"}, map[string]interface{}{"_id": "desc"}},
+			wantRequests: []string{"GET /"},
+		},
+		{
+			backend:      Elasticsearch7Backend,
+			version:      clusterVersion{Number: "7.17.18"},
+			query:        `{"sort":"created_at"}`,
+			wantSort:     []interface{}{"created_at", map[string]interface{}{"_shard_doc": "asc"}},
+			wantRequests: []string{"GET /", "POST /logs/_pit", "DELETE /_pit"},
+		},
+		{
+			backend:      AutoBackend,
+			version:      clusterVersion{Number: "7.10.0"},
+			query:        `{}`,
+			wantSort:     []interface{}{createdAt, map[string]interface{}{"_shard_doc": "asc"}},
+			wantRequests: []string{"GET /", "POST /logs/_pit", "DELETE /_pit"},
+		},
+		{
+			backend:      Elasticsearch8Backend,
+			query:        `{}`,
+			wantSort:     []interface{}{createdAt, map[string]interface{}{"_shard_doc": "asc"}},
+			wantRequests: []string{"POST /logs/_pit", "DELETE /_pit"},
+		},
+		{
+			backend:      OpenSearchBackend,
+			version:      clusterVersion{Number: "2.11.1", Distribution: "opensearch"},
+			query:        `{}`,
+			wantSort:     []interface{}{createdAt, map[string]interface{}{"_shard_doc": "asc"}},
+			wantRequests: []string{"GET /", "POST /logs/_search/point_in_time", "DELETE /_search/point_in_time"},
+		},
+		{
+			backend:      AutoBackend,
+			version:      clusterVersion{Number: "2.3.0", Distribution: "opensearch"},
+			query:        `{}`,
+			wantSort:     []interface{}{createdAt, map[string]interface{}{"_id": "asc"}},
+			wantRequests: []string{"GET /"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.backend)+" "+tt.version.Number+" "+tt.query, func(t *testing.T) {
+			standIn := &searchAfterStandIn{t: t, documents: 7, version: tt.version}
+			server := httptest.NewServer(standIn)
+			defer server.Close()
+
+			client, err := NewElasticsearchClient(ElasticsearchConfig{URL: server.URL, Backend: tt.backend})
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := SearchAfterWithElasticsearch(context.Background(), client, "logs", tt.query,
+				ElasticsearchPaging{PageSize: 3, Limit: 100, KeepAlive: time.Minute}, func(time.Duration, int) {})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Documents sharing the created_at of the last document of a page are read
+			// on the next page
+			if result.Results != standIn.documents {
+				t.Errorf("got %d documents, want %d", result.Results, standIn.documents)
+			}
+			for _, sort := range standIn.sorts {
+				if !reflect.DeepEqual(sort, tt.wantSort) {
+					t.Errorf("got sort %v, want %v", sort, tt.wantSort)
+				}
+			}
+
+			var requests []string
+			for _, request := range standIn.requests {
+				if !strings.HasSuffix(request, "/_search") {
+					requests = append(requests, request)
+				}
+			}
+			if !reflect.DeepEqual(requests, tt.wantRequests) {
+				t.Errorf("got requests %v, want %v", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestBucketCount(t *testing.T) {
+	tests := []struct {
+		name string
+		aggs string
+		want int
+	}{
+		{name: "none", aggs: `null`, want: 0},
+		{name: "terms", aggs: `{"service":{"buckets":[{"key":"a"},{"key":"b"}]}}`, want: 2},
+		{
+			name: "nested",
+			aggs: `{"created_at":{"buckets":[
+				{"key":1,"level":{"buckets":[{"key":"info"},{"key":"error"}]}},
+				{"key":2,"level":{"buckets":[{"key":"info"}]}}
+			]}}`,
+			want: 5,
+		},
+		{name: "sibling", aggs: `{"a":{"buckets":[{}]},"b":{"buckets":[{},{}]}}`, want: 3},
+		{name: "metric", aggs: `{"count":{"value":10}}`, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var aggs interface{}
+			if err := json.Unmarshal([]byte(tt.aggs), &aggs); err != nil {
+				t.Fatal(err)
+			}
+			if got := bucketCount(aggs); got != tt.want {
+				t.Errorf("got %d buckets, want %d", got, tt.want)
+			}
+		})
+	}
+}
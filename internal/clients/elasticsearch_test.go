@@ -0,0 +1,115 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestElasticsearchBulkIndexerFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		// status and items describe the bulk response, the status of each document
+		status int
+		items  []int
+		// want maps the worker and error type to the documents reported as failed
+		want         map[string]int
+		wantRejected int
+	}{
+		{
+			name:   "all written",
+			status: http.StatusOK,
+			items:  []int{http.StatusCreated, http.StatusCreated, http.StatusCreated},
+			want:   map[string]int{},
+		},
+		{
+			name:         "document rejected",
+			status:       http.StatusOK,
+			items:        []int{http.StatusCreated, http.StatusBadRequest, http.StatusCreated},
+			want:         map[string]int{"1/mapper_parsing_exception": 1},
+			wantRejected: 1,
+		},
+		{
+			name:         "request failed",
+			status:       http.StatusInternalServerError,
+			want:         map[string]int{"/" + RequestFailure: 3},
+			wantRejected: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.status != http.StatusOK {
+					http.Error(w, `{"error":"stand-in error"}`, tt.status)
+					return
+				}
+				var items []string
+				for _, status := range tt.items {
+					item := fmt.Sprintf(`{"index":{"_index":"logs","status":%d}}`, status)
+					if status >= http.StatusBadRequest {
+						item = fmt.Sprintf(`{"index":{"_index":"logs","status":%d,"error":{"type":"mapper_parsing_exception","reason":"stand-in"}}}`, status)
+					}
+					items = append(items, item)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"took":1,"errors":false,"items":[%s]}`, strings.Join(items, ","))
+			}))
+			defer server.Close()
+
+			client, err := NewElasticsearchClient(ElasticsearchConfig{URL: server.URL, Backend: Elasticsearch7Backend})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				mu       sync.Mutex
+				got      = map[string]int{}
+				rejected int
+			)
+			indexer, err := NewElasticsearchBulkIndexer(client, "logs", ElasticsearchBulkObserver{
+				OnFlush: func(result BatchResult) {
+					mu.Lock()
+					defer mu.Unlock()
+					rejected += result.Rejected
+				},
+				OnFailure: func(worker, errorType string, documents int) {
+					mu.Lock()
+					defer mu.Unlock()
+					got[worker+"/"+errorType] += documents
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 3; i++ {
+				if err := SendLogWithElasticsearch(indexer, fmt.Sprint(i), "logs", "index", []byte(`{"message":"line"}`)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Closing flushes the documents in a single bulk request
+			if err := indexer.Close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(got) != len(tt.want) {
+				t.Errorf("got failures %v, want %v", got, tt.want)
+			}
+			for key, want := range tt.want {
+				if got[key] != want {
+					t.Errorf("got failures %v, want %v", got, tt.want)
+				}
+			}
+			if rejected != tt.wantRejected {
+				t.Errorf("got %d documents rejected in flushes, want %d", rejected, tt.wantRejected)
+			}
+		})
+	}
+}
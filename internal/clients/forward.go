@@ -0,0 +1,475 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ForwardMode describes how events are packed into Fluent forward protocol messages
+type ForwardMode string
+
+const (
+	// ForwardMessageMode sends every event in its own message
+	ForwardMessageMode ForwardMode = "message"
+
+	// ForwardForwardMode sends the events of a tag as an array of entries
+	ForwardForwardMode ForwardMode = "forward"
+
+	// ForwardPackedForwardMode sends the events of a tag as a binary stream of entries,
+	// which may be compressed
+	ForwardPackedForwardMode ForwardMode = "packed-forward"
+)
+
+// ForwardConfig describes the settings of a Fluent forward protocol client
+type ForwardConfig struct {
+	// URL is the address of the server, e.g. tcp://localhost:24224 or tls://localhost:24224
+	URL string
+	// Mode is the way events are packed into messages
+	Mode ForwardMode
+	// Compression compresses the entries of packed forward messages with "gzip"
+	Compression string
+	// RequireAck requests an acknowledgement of every message from the server
+	RequireAck bool
+	// AckTimeout is the time to wait for the acknowledgements of a batch before it is resent
+	AckTimeout time.Duration
+	// SharedKey authenticates the client in the handshake requested by the server
+	SharedKey string
+	// TLS configures TLS connections
+	TLS TLSConfig
+	// Batch configures batching and retries
+	Batch BatchConfig
+	// OnBatch is called with the outcome of every batch, if set
+	OnBatch BatchObserver
+	// OnAck is called with the latency of every acknowledged message, if set
+	OnAck func(time.Duration)
+}
+
+// ForwardEntry describes an event sent with the Fluent forward protocol
+type ForwardEntry struct {
+	Tag    string
+	Time   time.Time
+	Record map[string]string
+}
+
+// ForwardClient sends batches of events with the Fluent forward protocol
+type ForwardClient struct {
+	cfg      ForwardConfig
+	address  string
+	tls      *tls.Config
+	hostname string
+	conn     net.Conn
+	dec      *msgpack.Decoder
+	unacked  atomic.Int64
+	batcher  *batcher[ForwardEntry]
+}
+
+// eventTime encodes timestamps with nanosecond precision as EventTime extension
+type eventTime time.Time
+
+// forwardMessage is an encoded message waiting to be sent or acknowledged
+type forwardMessage struct {
+	chunk  string
+	data   []byte
+	sentAt time.Time
+	// unacked is set once the message was sent until it is acknowledged or dropped
+	unacked bool
+}
+
+func init() {
+	msgpack.RegisterExt(0, (*eventTime)(nil))
+}
+
+func (t *eventTime) MarshalMsgpack() ([]byte, error) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b, uint32(time.Time(*t).Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(time.Time(*t).Nanosecond()))
+	return b, nil
+}
+
+func (t *eventTime) UnmarshalMsgpack(b []byte) error {
+	if len(b) != 8 {
+		return fmt.Errorf("invalid event time length %d", len(b))
+	}
+	*t = eventTime(time.Unix(int64(binary.BigEndian.Uint32(b)), int64(binary.BigEndian.Uint32(b[4:]))))
+	return nil
+}
+
+// NewForwardClient creates a Fluent forward protocol client
+func NewForwardClient(cfg ForwardConfig) (*ForwardClient, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Mode {
+	case ForwardMessageMode, ForwardForwardMode, ForwardPackedForwardMode:
+	default:
+		return nil, fmt.Errorf("unknown forward mode: %s", cfg.Mode)
+	}
+
+	switch cfg.Compression {
+	case "", "none":
+	case GzipCompression:
+		if cfg.Mode != ForwardPackedForwardMode {
+			return nil, fmt.Errorf("forward compression requires the %s mode", ForwardPackedForwardMode)
+		}
+	default:
+		return nil, fmt.Errorf("unknown forward compression: %s", cfg.Compression)
+	}
+
+	c := &ForwardClient{
+		cfg:     cfg,
+		address: u.Host,
+	}
+
+	switch u.Scheme {
+	case "tcp":
+	case "tls":
+		c.tls, err = newTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown forward network %q: expected tcp or tls", u.Scheme)
+	}
+
+	c.hostname, err = os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	c.batcher = newBatcher(cfg.Batch, forwardEntrySize, c.flush)
+	return c, nil
+}
+
+// Send adds an event written by the given worker to the current batch
+func (c *ForwardClient) Send(worker string, entry ForwardEntry) {
+	c.batcher.Add(worker, entry)
+}
+
+// Stop sends the events left in the current batch and closes the connection
+func (c *ForwardClient) Stop() {
+	c.batcher.Stop()
+	c.close()
+}
+
+// Unacked returns the number of messages sent but not acknowledged yet
+func (c *ForwardClient) Unacked() int64 {
+	return c.unacked.Load()
+}
+
+func (c *ForwardClient) flush(ctx context.Context, entries []ForwardEntry, workers map[string]int) {
+	start := time.Now()
+	result := BatchResult{
+		Entries: len(entries),
+		Workers: workers,
+	}
+
+	messages, err := c.encode(entries)
+	if err == nil {
+		for _, m := range messages {
+			result.Bytes += len(m.data)
+		}
+		err = sendWithRetry(ctx, c.cfg.Batch.Backoff, func() error {
+			var err error
+			messages, err = c.send(messages)
+			return err
+		})
+	}
+	// The messages left were given up on
+	for _, m := range messages {
+		if m.unacked {
+			c.unacked.Add(-1)
+		}
+	}
+
+	result.Duration = time.Since(start)
+	result.Err = err
+	if c.cfg.OnBatch != nil {
+		c.cfg.OnBatch(result)
+	}
+}
+
+// send writes the messages and waits for their acknowledgements if required. It
+// returns the messages which have to be sent again.
+func (c *ForwardClient) send(messages []*forwardMessage) ([]*forwardMessage, error) {
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return messages, retryableError{err}
+		}
+	}
+
+	pending := map[string]*forwardMessage{}
+	for _, m := range messages {
+		m.sentAt = time.Now()
+		if _, err := c.conn.Write(m.data); err != nil {
+			c.close()
+			return messages, retryableError{err}
+		}
+		if c.cfg.RequireAck {
+			pending[m.chunk] = m
+			// Messages sent again are still waiting for the same acknowledgement
+			if !m.unacked {
+				m.unacked = true
+				c.unacked.Add(1)
+			}
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	if err := c.conn.SetReadDeadline(time.Now().Add(c.cfg.AckTimeout)); err != nil {
+		return messages, retryableError{err}
+	}
+	for len(pending) > 0 {
+		var res struct {
+			Ack string `msgpack:"ack"`
+		}
+		if err := c.dec.Decode(&res); err != nil {
+			c.close()
+			var unacked []*forwardMessage
+			for _, m := range pending {
+				unacked = append(unacked, m)
+			}
+			return unacked, retryableError{fmt.Errorf("%d messages not acknowledged: %s", len(pending), err)}
+		}
+
+		if m, ok := pending[res.Ack]; ok {
+			delete(pending, res.Ack)
+			m.unacked = false
+			c.unacked.Add(-1)
+			if c.cfg.OnAck != nil {
+				c.cfg.OnAck(time.Since(m.sentAt))
+			}
+		}
+	}
+	return nil, nil
+}
+
+// encode packs the entries into messages, grouped by tag unless every entry is sent
+// in its own message
+func (c *ForwardClient) encode(entries []ForwardEntry) ([]*forwardMessage, error) {
+	var messages []*forwardMessage
+	if c.cfg.Mode == ForwardMessageMode {
+		for _, e := range entries {
+			t := eventTime(e.Time)
+			m, err := c.message(1, e.Tag, &t, e.Record)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, m)
+		}
+		return messages, nil
+	}
+
+	var (
+		tags   []string
+		groups = map[string][]ForwardEntry{}
+	)
+	for _, e := range entries {
+		if _, ok := groups[e.Tag]; !ok {
+			tags = append(tags, e.Tag)
+		}
+		groups[e.Tag] = append(groups[e.Tag], e)
+	}
+
+	for _, tag := range tags {
+		group := groups[tag]
+		events := make([][]interface{}, 0, len(group))
+		for _, e := range group {
+			t := eventTime(e.Time)
+			events = append(events, []interface{}{&t, e.Record})
+		}
+
+		var m *forwardMessage
+		if c.cfg.Mode == ForwardForwardMode {
+			var err error
+			if m, err = c.message(len(group), tag, events); err != nil {
+				return nil, err
+			}
+		} else {
+			var buf bytes.Buffer
+			enc := msgpack.NewEncoder(&buf)
+			for _, event := range events {
+				if err := enc.Encode(event); err != nil {
+					return nil, err
+				}
+			}
+
+			stream := buf.Bytes()
+			if c.cfg.Compression == GzipCompression {
+				var err error
+				if stream, err = gzipBody(stream); err != nil {
+					return nil, err
+				}
+			}
+
+			var err error
+			if m, err = c.message(len(group), tag, stream); err != nil {
+				return nil, err
+			}
+		}
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
+
+// message encodes a message with the given fields followed by the options
+func (c *ForwardClient) message(entries int, fields ...interface{}) (*forwardMessage, error) {
+	m := &forwardMessage{}
+
+	options := map[string]interface{}{}
+	if c.cfg.Mode != ForwardMessageMode {
+		options["size"] = entries
+	}
+	if c.cfg.Compression == GzipCompression {
+		options["compressed"] = GzipCompression
+	}
+	if c.cfg.RequireAck {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		m.chunk = base64.StdEncoding.EncodeToString(id)
+		options["chunk"] = m.chunk
+	}
+
+	var err error
+	m.data, err = msgpack.Marshal(append(fields, options))
+	return m, err
+}
+
+func (c *ForwardClient) connect() error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if c.tls != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.address, c.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", c.address)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to connect to forward server %s: %s", c.cfg.URL, err)
+	}
+
+	dec := msgpack.NewDecoder(conn)
+	if c.cfg.SharedKey != "" {
+		if err := c.handshake(conn, dec); err != nil {
+			conn.Close()
+			return fmt.Errorf("forward handshake with %s failed: %s", c.cfg.URL, err)
+		}
+	}
+	c.conn, c.dec = conn, dec
+	return nil
+}
+
+// handshake authenticates the client with the shared key after the server sent HELO
+func (c *ForwardClient) handshake(conn net.Conn, dec *msgpack.Decoder) error {
+	if err := conn.SetReadDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+
+	var helo []interface{}
+	if err := dec.Decode(&helo); err != nil {
+		return err
+	}
+	if len(helo) != 2 || helo[0] != "HELO" {
+		return fmt.Errorf("expected HELO, got %v", helo)
+	}
+	options, ok := helo[1].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid HELO options %v", helo[1])
+	}
+	nonce := toBytes(options["nonce"])
+	if auth := toBytes(options["auth"]); len(auth) > 0 {
+		return fmt.Errorf("user authentication is not supported")
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	saltHex := hex.EncodeToString(salt)
+	ping, err := msgpack.Marshal([]interface{}{
+		"PING", c.hostname, saltHex, sharedKeyDigest(saltHex, c.hostname, nonce, c.cfg.SharedKey), "", "",
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(ping); err != nil {
+		return err
+	}
+
+	var pong []interface{}
+	if err := dec.Decode(&pong); err != nil {
+		return err
+	}
+	if len(pong) != 5 || pong[0] != "PONG" {
+		return fmt.Errorf("expected PONG, got %v", pong)
+	}
+	if authenticated, _ := pong[1].(bool); !authenticated {
+		return fmt.Errorf("authentication failed: %v", pong[2])
+	}
+	serverHostname, _ := pong[3].(string)
+	if pong[4] != sharedKeyDigest(saltHex, serverHostname, nonce, c.cfg.SharedKey) {
+		return fmt.Errorf("server shared key mismatch")
+	}
+	return conn.SetReadDeadline(time.Time{})
+}
+
+func (c *ForwardClient) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn, c.dec = nil, nil
+	}
+}
+
+func sharedKeyDigest(salt, hostname string, nonce []byte, sharedKey string) string {
+	h := sha512.New()
+	h.Write([]byte(salt))
+	h.Write([]byte(hostname))
+	h.Write(nonce)
+	h.Write([]byte(sharedKey))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func toBytes(v interface{}) []byte {
+	switch b := v.(type) {
+	case []byte:
+		return b
+	case string:
+		return []byte(b)
+	default:
+		return nil
+	}
+}
+
+func forwardEntrySize(e ForwardEntry) int {
+	size := len(e.Tag)
+	for name, value := range e.Record {
+		size += len(name) + len(value)
+	}
+	return size
+}
@@ -0,0 +1,154 @@
+package clients
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/backoff"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestForwardClientUnacked(t *testing.T) {
+	tests := []struct {
+		name string
+		// ack tells whether the server acknowledges the given receipt of a chunk
+		ack         func(receipt int) bool
+		wantErr     bool
+		wantSent    int
+		wantResends []int64
+	}{
+		{
+			name:     "acknowledged",
+			ack:      func(int) bool { return true },
+			wantSent: 1,
+		},
+		{
+			name:        "acknowledged after resend",
+			ack:         func(receipt int) bool { return receipt > 1 },
+			wantSent:    2,
+			wantResends: []int64{1},
+		},
+		{
+			name:        "dropped",
+			ack:         func(int) bool { return false },
+			wantErr:     true,
+			wantSent:    2,
+			wantResends: []int64{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+
+			var (
+				client   atomic.Pointer[ForwardClient]
+				mu       sync.Mutex
+				receipts = map[string]int{}
+				resends  []int64
+			)
+			go func() {
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					go func() {
+						defer conn.Close()
+						dec := msgpack.NewDecoder(conn)
+						for {
+							var message []interface{}
+							if err := dec.Decode(&message); err != nil {
+								return
+							}
+							options, _ := message[len(message)-1].(map[string]interface{})
+							chunk, _ := options["chunk"].(string)
+
+							mu.Lock()
+							receipts[chunk]++
+							receipt := receipts[chunk]
+							if receipt > 1 {
+								resends = append(resends, client.Load().Unacked())
+							}
+							mu.Unlock()
+
+							if !tt.ack(receipt) {
+								continue
+							}
+							ack, _ := msgpack.Marshal(map[string]string{"ack": chunk})
+							if _, err := conn.Write(ack); err != nil {
+								return
+							}
+						}
+					}()
+				}
+			}()
+
+			results := make(chan BatchResult, 1)
+			c, err := NewForwardClient(ForwardConfig{
+				URL:        "tcp://" + listener.Addr().String(),
+				Mode:       ForwardForwardMode,
+				RequireAck: true,
+				AckTimeout: 50 * time.Millisecond,
+				Batch: BatchConfig{
+					Size: 1 << 20,
+					Wait: 10 * time.Millisecond,
+					Backoff: backoff.Config{
+						MinBackoff: time.Millisecond,
+						MaxBackoff: time.Millisecond,
+						MaxRetries: 2,
+					},
+				},
+				OnBatch: func(res BatchResult) { results <- res },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.Store(c)
+			defer c.Stop()
+
+			for i := 0; i < 2; i++ {
+				c.Send("worker", ForwardEntry{Tag: "app.web", Time: time.Now(), Record: map[string]string{"message": "line"}})
+			}
+
+			var res BatchResult
+			select {
+			case res = <-results:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the batch")
+			}
+
+			if (res.Err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", res.Err, tt.wantErr)
+			}
+			if got := c.Unacked(); got != 0 {
+				t.Errorf("got %d unacked messages after the batch, want 0", got)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			sent := 0
+			for _, n := range receipts {
+				sent += n
+			}
+			if sent != tt.wantSent {
+				t.Errorf("got %d messages sent, want %d", sent, tt.wantSent)
+			}
+			if len(resends) != len(tt.wantResends) {
+				t.Fatalf("got unacked messages %v on resends, want %v", resends, tt.wantResends)
+			}
+			for i := range resends {
+				if resends[i] != tt.wantResends[i] {
+					t.Errorf("got unacked messages %v on resends, want %v", resends, tt.wantResends)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package clients
+
+import (
+	"net/http"
+
+	"github.com/prometheus/common/config"
+)
+
+// newHTTPClient creates an HTTP client which authenticates with the service account token
+// and verifies the server against the service CA unless the security check is disabled
+func newHTTPClient(name string, disableSecurityCheck bool) (*http.Client, error) {
+	clientConfig := config.HTTPClientConfig{
+		TLSConfig: config.TLSConfig{
+			InsecureSkipVerify: disableSecurityCheck,
+		},
+	}
+
+	if !disableSecurityCheck {
+		clientConfig.Authorization = &config.Authorization{
+			CredentialsFile: serviceAccountToken,
+		}
+		clientConfig.TLSConfig.CAFile = serviceCA
+	}
+
+	if err := clientConfig.Validate(); err != nil {
+		return nil, err
+	}
+	return config.NewClientFromConfig(clientConfig, name)
+}
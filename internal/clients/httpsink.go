@@ -0,0 +1,215 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ZstdCompression compresses request bodies with zstd
+const ZstdCompression = "zstd"
+
+// HTTPEncoding describes how a batch of logs is encoded in a request body
+type HTTPEncoding string
+
+const (
+	// NDJSONEncoding sends one JSON document per line
+	NDJSONEncoding HTTPEncoding = "ndjson"
+
+	// JSONArrayEncoding sends a JSON array of documents
+	JSONArrayEncoding HTTPEncoding = "json"
+
+	// TextEncoding sends one log line per line
+	TextEncoding HTTPEncoding = "text"
+)
+
+// HTTPSinkConfig describes the settings of a generic HTTP client
+type HTTPSinkConfig struct {
+	// URL is the address logs are sent to
+	URL string
+	// Method is the HTTP method of the requests
+	Method string
+	// Headers are added to every request
+	Headers map[string]string
+	// DisableSecurityCheck deactivates the TLS checks and the service account token
+	DisableSecurityCheck bool
+	// Encoding is the encoding of the request bodies
+	Encoding HTTPEncoding
+	// Compression is the content encoding applied to the requests, "gzip", "zstd" or none
+	Compression string
+	// RetryStatusCodes are the response status codes on which a request is retried
+	RetryStatusCodes []int
+	// Batch configures batching and retries
+	Batch BatchConfig
+	// OnBatch is called with the outcome of every batch, if set
+	OnBatch BatchObserver
+	// OnResponse is called with the status code of every response, or 0 if the
+	// request failed without response, if set
+	OnResponse func(int)
+}
+
+// HTTPSinkClient sends batches of logs in the body of HTTP requests
+type HTTPSinkClient struct {
+	cfg     HTTPSinkConfig
+	client  *http.Client
+	zstd    *zstd.Encoder
+	batcher *batcher[[]byte]
+}
+
+// NewHTTPSinkClient creates a generic HTTP client
+func NewHTTPSinkClient(cfg HTTPSinkConfig) (*HTTPSinkClient, error) {
+	if _, err := url.Parse(cfg.URL); err != nil {
+		return nil, err
+	}
+
+	switch cfg.Encoding {
+	case NDJSONEncoding, JSONArrayEncoding, TextEncoding:
+	default:
+		return nil, fmt.Errorf("unknown http encoding: %s", cfg.Encoding)
+	}
+
+	httpClient, err := newHTTPClient("http", cfg.DisableSecurityCheck)
+	if err != nil {
+		return nil, err
+	}
+	httpClient.Timeout = 30 * time.Second
+
+	c := &HTTPSinkClient{
+		cfg:    cfg,
+		client: httpClient,
+	}
+
+	switch cfg.Compression {
+	case "", "none", GzipCompression:
+	case ZstdCompression:
+		if c.zstd, err = zstd.NewWriter(nil); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown http compression: %s", cfg.Compression)
+	}
+
+	c.batcher = newBatcher(cfg.Batch, func(entry []byte) int { return len(entry) }, c.flush)
+	return c, nil
+}
+
+// Send adds an encoded log written by the given worker, i.e. a JSON document or a
+// line, to the current batch
+func (c *HTTPSinkClient) Send(worker string, entry []byte) {
+	c.batcher.Add(worker, entry)
+}
+
+// Stop sends the logs left in the current batch
+func (c *HTTPSinkClient) Stop() {
+	c.batcher.Stop()
+}
+
+func (c *HTTPSinkClient) flush(ctx context.Context, entries [][]byte, workers map[string]int) {
+	start := time.Now()
+	result := BatchResult{
+		Entries: len(entries),
+		Workers: workers,
+	}
+
+	body, err := c.encode(entries)
+	if err == nil {
+		result.Bytes = len(body)
+		err = sendWithRetry(ctx, c.cfg.Batch.Backoff, func() error {
+			return c.send(body)
+		})
+	}
+
+	result.Duration = time.Since(start)
+	result.Err = err
+	if c.cfg.OnBatch != nil {
+		c.cfg.OnBatch(result)
+	}
+}
+
+func (c *HTTPSinkClient) encode(entries [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	switch c.cfg.Encoding {
+	case JSONArrayEncoding:
+		buf.WriteByte('[')
+		for i, entry := range entries {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(bytes.TrimRight(entry, "\n"))
+		}
+		buf.WriteByte(']')
+	default:
+		for _, entry := range entries {
+			buf.Write(entry)
+			if !bytes.HasSuffix(entry, []byte("\n")) {
+				buf.WriteByte('\n')
+			}
+		}
+	}
+
+	switch c.cfg.Compression {
+	case GzipCompression:
+		return gzipBody(buf.Bytes())
+	case ZstdCompression:
+		return c.zstd.EncodeAll(buf.Bytes(), nil), nil
+	default:
+		return buf.Bytes(), nil
+	}
+}
+
+func (c *HTTPSinkClient) send(body []byte) error {
+	req, err := http.NewRequest(c.cfg.Method, c.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	switch c.cfg.Encoding {
+	case NDJSONEncoding:
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	case JSONArrayEncoding:
+		req.Header.Set("Content-Type", "application/json")
+	default:
+		req.Header.Set("Content-Type", "text/plain")
+	}
+	req.Header.Set("User-Agent", "cluster-logging-load-client")
+	if c.cfg.Compression == GzipCompression || c.cfg.Compression == ZstdCompression {
+		req.Header.Set("Content-Encoding", c.cfg.Compression)
+	}
+	for name, value := range c.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.observeResponse(0)
+		return retryableError{err}
+	}
+	defer res.Body.Close()
+	c.observeResponse(res.StatusCode)
+
+	if res.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, res.Body)
+		return nil
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	err = fmt.Errorf("server returned %s: %s", res.Status, bytes.TrimSpace(data))
+	for _, code := range c.cfg.RetryStatusCodes {
+		if res.StatusCode == code {
+			return retryableError{err}
+		}
+	}
+	return err
+}
+
+func (c *HTTPSinkClient) observeResponse(code int) {
+	if c.cfg.OnResponse != nil {
+		c.cfg.OnResponse(code)
+	}
+}
@@ -0,0 +1,178 @@
+package clients
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/backoff"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestHTTPSinkClient(t *testing.T) {
+	tests := []struct {
+		name        string
+		encoding    HTTPEncoding
+		compression string
+		// statuses are the status codes of the responses, 200 once they run out
+		statuses      []int
+		wantErr       bool
+		wantType      string
+		wantBody      string
+		wantResponses []int
+	}{
+		{
+			name:          "ndjson",
+			encoding:      NDJSONEncoding,
+			wantType:      "application/x-ndjson",
+			wantBody:      "{\"a\":1}\n{\"b\":2}\n",
+			wantResponses: []int{200},
+		},
+		{
+			name:          "json gzip",
+			encoding:      JSONArrayEncoding,
+			compression:   GzipCompression,
+			wantType:      "application/json",
+			wantBody:      `[{"a":1},{"b":2}]`,
+			wantResponses: []int{200},
+		},
+		{
+			name:          "text zstd",
+			encoding:      TextEncoding,
+			compression:   ZstdCompression,
+			wantType:      "text/plain",
+			wantBody:      "{\"a\":1}\n{\"b\":2}\n",
+			wantResponses: []int{200},
+		},
+		{
+			name:          "retried status",
+			encoding:      NDJSONEncoding,
+			statuses:      []int{503, 429},
+			wantType:      "application/x-ndjson",
+			wantBody:      "{\"a\":1}\n{\"b\":2}\n",
+			wantResponses: []int{503, 429, 200},
+		},
+		{
+			name:          "status not retried",
+			encoding:      NDJSONEncoding,
+			statuses:      []int{500},
+			wantErr:       true,
+			wantType:      "application/x-ndjson",
+			wantBody:      "{\"a\":1}\n{\"b\":2}\n",
+			wantResponses: []int{500},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				requests int
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				if r.Method != http.MethodPut {
+					t.Errorf("got method %s, want PUT", r.Method)
+				}
+				if got := r.Header.Get("Content-Type"); got != tt.wantType {
+					t.Errorf("got content type %q, want %q", got, tt.wantType)
+				}
+				if got := r.Header.Get("X-Scope-OrgID"); got != "tenant" {
+					t.Errorf("got header X-Scope-OrgID %q, want tenant", got)
+				}
+				if got := r.Header.Get("Content-Encoding"); got != tt.compression {
+					t.Errorf("got content encoding %q, want %q", got, tt.compression)
+				}
+
+				data, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Errorf("error reading body: %s", err)
+				}
+				switch tt.compression {
+				case GzipCompression:
+					gz, err := gzip.NewReader(bytes.NewReader(data))
+					if err != nil {
+						t.Errorf("error reading gzip body: %s", err)
+						return
+					}
+					data, err = io.ReadAll(gz)
+					if err != nil {
+						t.Errorf("error reading gzip body: %s", err)
+					}
+				case ZstdCompression:
+					dec, err := zstd.NewReader(nil)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					defer dec.Close()
+					if data, err = dec.DecodeAll(data, nil); err != nil {
+						t.Errorf("error reading zstd body: %s", err)
+					}
+				}
+				if string(data) != tt.wantBody {
+					t.Errorf("got body %q, want %q", data, tt.wantBody)
+				}
+
+				status := http.StatusOK
+				if requests < len(tt.statuses) {
+					status = tt.statuses[requests]
+				}
+				requests++
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			var responses []int
+			results := make(chan BatchResult, 1)
+			client, err := NewHTTPSinkClient(HTTPSinkConfig{
+				URL:                  server.URL,
+				Method:               http.MethodPut,
+				Headers:              map[string]string{"X-Scope-OrgID": "tenant"},
+				DisableSecurityCheck: true,
+				Encoding:             tt.encoding,
+				Compression:          tt.compression,
+				RetryStatusCodes:     []int{429, 503},
+				Batch: BatchConfig{
+					Size:    1 << 20,
+					Wait:    10 * time.Millisecond,
+					Backoff: backoff.Config{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, MaxRetries: 5},
+				},
+				OnBatch: func(res BatchResult) { results <- res },
+				// Responses are observed from the goroutine flushing batches
+				OnResponse: func(code int) { responses = append(responses, code) },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.Send("0", []byte("{\"a\":1}\n"))
+			client.Send("0", []byte(`{"b":2}`))
+			defer client.Stop()
+
+			// Batches are not retried anymore once the client stops
+			var res BatchResult
+			select {
+			case res = <-results:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the batch")
+			}
+			if (res.Err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", res.Err, tt.wantErr)
+			}
+			if res.Entries != 2 {
+				t.Errorf("got %d entries, want 2", res.Entries)
+			}
+			if !reflect.DeepEqual(responses, tt.wantResponses) {
+				t.Errorf("got responses %v, want %v", responses, tt.wantResponses)
+			}
+		})
+	}
+}
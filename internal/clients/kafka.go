@@ -0,0 +1,159 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/grafana/dskit/backoff"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// KafkaPartitioner describes how records are distributed over the partitions of a topic
+type KafkaPartitioner string
+
+const (
+	// RandomPartitioner produces every record to a random partition
+	RandomPartitioner KafkaPartitioner = "random"
+
+	// RoundRobinPartitioner produces records to the partitions in turn
+	RoundRobinPartitioner KafkaPartitioner = "round-robin"
+
+	// HostnamePartitioner produces the records of a hostname to the same partition
+	HostnamePartitioner KafkaPartitioner = "hostname"
+)
+
+// KafkaConfig describes the settings of a Kafka producer
+type KafkaConfig struct {
+	// Brokers is a comma separated list of seed brokers, e.g. localhost:9092
+	Brokers string
+	// Topic is the topic records are produced to
+	Topic string
+	// Partitioner distributes the records over the partitions of the topic
+	Partitioner KafkaPartitioner
+	// Compression is the codec of record batches: none, gzip, snappy, lz4 or zstd
+	Compression string
+	// Acks is the number of acknowledgements required for a record: all, leader or none
+	Acks string
+	// BatchSize is the maximum number of bytes of a record batch
+	BatchSize int
+	// Linger is the time to wait for more records before a batch is produced
+	Linger time.Duration
+	// Backoff configures the retries of records which failed to be produced
+	Backoff backoff.Config
+	// OnProduce is called with the worker which sent a record, the latency and the error
+	// of every produced record, if set
+	OnProduce func(worker string, latency time.Duration, err error)
+}
+
+// KafkaClient produces logs to a Kafka topic
+type KafkaClient struct {
+	cfg    KafkaConfig
+	client *kgo.Client
+}
+
+// randomPartitioner implements kgo.Partitioner for the random partitioner
+type randomPartitioner struct{}
+
+func (randomPartitioner) ForTopic(string) kgo.TopicPartitioner {
+	return randomPartitioner{}
+}
+
+func (randomPartitioner) RequiresConsistency(*kgo.Record) bool {
+	return false
+}
+
+func (randomPartitioner) Partition(_ *kgo.Record, n int) int {
+	return rand.Intn(n)
+}
+
+// NewKafkaClient creates a Kafka producer
+func NewKafkaClient(cfg KafkaConfig) (*KafkaClient, error) {
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(strings.Split(cfg.Brokers, ",")...),
+		kgo.DefaultProduceTopic(cfg.Topic),
+		kgo.ProducerBatchMaxBytes(int32(cfg.BatchSize)),
+		kgo.ProducerLinger(cfg.Linger),
+		kgo.SoftwareNameAndVersion("cluster-logging-load-client", "1.0.0"),
+		kgo.RetryBackoffFn(func(tries int) time.Duration {
+			wait := cfg.Backoff.MinBackoff << (tries - 1)
+			if wait <= 0 || wait > cfg.Backoff.MaxBackoff {
+				wait = cfg.Backoff.MaxBackoff
+			}
+			return wait
+		}),
+	}
+	if cfg.Backoff.MaxRetries > 0 {
+		opts = append(opts, kgo.RecordRetries(cfg.Backoff.MaxRetries))
+	}
+
+	switch cfg.Partitioner {
+	case RandomPartitioner:
+		opts = append(opts, kgo.RecordPartitioner(randomPartitioner{}))
+	case RoundRobinPartitioner:
+		opts = append(opts, kgo.RecordPartitioner(kgo.RoundRobinPartitioner()))
+	case HostnamePartitioner:
+		opts = append(opts, kgo.RecordPartitioner(kgo.StickyKeyPartitioner(nil)))
+	default:
+		return nil, fmt.Errorf("unknown kafka partitioner: %s", cfg.Partitioner)
+	}
+
+	switch cfg.Compression {
+	case "", "none":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.NoCompression()))
+	case "gzip":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.GzipCompression()))
+	case "snappy":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.SnappyCompression()))
+	case "lz4":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.Lz4Compression()))
+	case "zstd":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.ZstdCompression()))
+	default:
+		return nil, fmt.Errorf("unknown kafka compression: %s", cfg.Compression)
+	}
+
+	// Idempotent writes require acknowledgements of all in-sync replicas
+	switch cfg.Acks {
+	case "all":
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	case "leader":
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()), kgo.DisableIdempotentWrite())
+	case "none":
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()), kgo.DisableIdempotentWrite())
+	default:
+		return nil, fmt.Errorf("unknown kafka acks: %s", cfg.Acks)
+	}
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &KafkaClient{
+		cfg:    cfg,
+		client: client,
+	}, nil
+}
+
+// Send produces a log written by the given worker asynchronously. It blocks while the
+// buffer of records waiting to be produced is full.
+func (c *KafkaClient) Send(worker, key, value string) {
+	record := &kgo.Record{
+		Key:       []byte(key),
+		Value:     []byte(value),
+		Timestamp: time.Now(),
+	}
+	c.client.Produce(context.Background(), record, func(r *kgo.Record, err error) {
+		if c.cfg.OnProduce != nil {
+			c.cfg.OnProduce(worker, time.Since(r.Timestamp), err)
+		}
+	})
+}
+
+// Stop waits for the buffered records to be produced and closes the client
+func (c *KafkaClient) Stop() {
+	_ = c.client.Flush(context.Background())
+	c.client.Close()
+}
@@ -0,0 +1,248 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/backoff"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+const (
+	testKafkaTopic      = "logs"
+	testKafkaPartitions = 3
+)
+
+// kafkaProduceRequests records the acks and the compression codecs of the produce
+// requests a fake broker receives
+type kafkaProduceRequests struct {
+	mu     sync.Mutex
+	acks   map[int16]bool
+	codecs map[int16]bool
+}
+
+func (r *kafkaProduceRequests) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.acks)
+}
+
+func newFakeKafka(t *testing.T) (*kfake.Cluster, *kafkaProduceRequests) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(testKafkaPartitions, testKafkaTopic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+
+	requests := &kafkaProduceRequests{acks: map[int16]bool{}, codecs: map[int16]bool{}}
+	cluster.ControlKey(kmsg.Produce.Int16(), func(req kmsg.Request) (kmsg.Response, error, bool) {
+		cluster.KeepControl()
+		produce := req.(*kmsg.ProduceRequest)
+
+		requests.mu.Lock()
+		defer requests.mu.Unlock()
+		requests.acks[produce.Acks] = true
+		for _, topic := range produce.Topics {
+			for _, partition := range topic.Partitions {
+				var batch kmsg.RecordBatch
+				if err := batch.ReadFrom(partition.Records); err != nil {
+					t.Errorf("error reading record batch: %s", err)
+					continue
+				}
+				requests.codecs[batch.Attributes&0x07] = true
+			}
+		}
+		return nil, nil, false
+	})
+	return cluster, requests
+}
+
+// produceAll sends the records with the given keys and waits until all are produced
+func produceAll(t *testing.T, cfg KafkaConfig, keys []string) {
+	var (
+		mu       sync.Mutex
+		produced int
+		done     = make(chan struct{})
+	)
+	cfg.Topic = testKafkaTopic
+	cfg.Linger = time.Millisecond
+	cfg.BatchSize = 1 << 20
+	cfg.Backoff = backoff.Config{MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond, MaxRetries: 3}
+	cfg.OnProduce = func(worker string, _ time.Duration, err error) {
+		if err != nil {
+			t.Errorf("error producing record of worker %s: %s", worker, err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if produced++; produced == len(keys) {
+			close(done)
+		}
+	}
+
+	client, err := NewKafkaClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+
+	for i, key := range keys {
+		// Codecs leave records uncompressed unless compression shrinks them
+		client.Send("0", key, fmt.Sprintf("line %d %s", i, strings.Repeat("x", 1024)))
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("records not produced")
+	}
+}
+
+// consumeAll returns the partitions of the records of the topic by key
+func consumeAll(t *testing.T, brokers string, count int) map[string][]int32 {
+	consumer, err := kgo.NewClient(
+		kgo.SeedBrokers(brokers),
+		kgo.ConsumeTopics(testKafkaTopic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	partitions := map[string][]int32{}
+	for consumed := 0; consumed < count; {
+		fetches := consumer.PollFetches(ctx)
+		if err := ctx.Err(); err != nil {
+			t.Fatalf("consumed %d of %d records: %s", consumed, count, err)
+		}
+		fetches.EachRecord(func(r *kgo.Record) {
+			partitions[string(r.Key)] = append(partitions[string(r.Key)], r.Partition)
+			consumed++
+		})
+	}
+	return partitions
+}
+
+func TestKafkaClientPartitioners(t *testing.T) {
+	tests := []struct {
+		partitioner KafkaPartitioner
+		keys        []string
+		check       func(t *testing.T, partitions map[string][]int32)
+	}{
+		{
+			partitioner: HostnamePartitioner,
+			keys:        repeatKeys(10, "host-a", "host-b", "host-c"),
+			check: func(t *testing.T, partitions map[string][]int32) {
+				for key, ps := range partitions {
+					for _, p := range ps {
+						if p != ps[0] {
+							t.Errorf("records of %s produced to partitions %v, want a single one", key, ps)
+							break
+						}
+					}
+				}
+			},
+		},
+		{
+			partitioner: RoundRobinPartitioner,
+			keys:        repeatKeys(3*testKafkaPartitions, "host"),
+			check: func(t *testing.T, partitions map[string][]int32) {
+				counts := map[int32]int{}
+				for _, p := range partitions["host"] {
+					counts[p]++
+				}
+				for p := int32(0); p < testKafkaPartitions; p++ {
+					if counts[p] != 3 {
+						t.Errorf("got %v records by partition, want 3 each", counts)
+						break
+					}
+				}
+			},
+		},
+		{
+			partitioner: RandomPartitioner,
+			keys:        repeatKeys(30, "host"),
+			check: func(t *testing.T, partitions map[string][]int32) {
+				used := map[int32]bool{}
+				for _, p := range partitions["host"] {
+					used[p] = true
+				}
+				if len(used) < 2 {
+					t.Errorf("records produced to partitions %v only", used)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.partitioner), func(t *testing.T) {
+			cluster, _ := newFakeKafka(t)
+			brokers := strings.Join(cluster.ListenAddrs(), ",")
+
+			produceAll(t, KafkaConfig{
+				Brokers:     brokers,
+				Partitioner: tt.partitioner,
+				Acks:        "all",
+			}, tt.keys)
+			tt.check(t, consumeAll(t, brokers, len(tt.keys)))
+		})
+	}
+}
+
+func TestKafkaClientSettings(t *testing.T) {
+	tests := []struct {
+		acks        string
+		compression string
+		wantAcks    int16
+		wantCodec   int16
+	}{
+		{acks: "all", compression: "none", wantAcks: -1, wantCodec: 0},
+		{acks: "leader", compression: "gzip", wantAcks: 1, wantCodec: 1},
+		{acks: "none", compression: "snappy", wantAcks: 0, wantCodec: 2},
+		{acks: "all", compression: "lz4", wantAcks: -1, wantCodec: 3},
+		{acks: "leader", compression: "zstd", wantAcks: 1, wantCodec: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acks+" "+tt.compression, func(t *testing.T) {
+			cluster, requests := newFakeKafka(t)
+			produceAll(t, KafkaConfig{
+				Brokers:     strings.Join(cluster.ListenAddrs(), ","),
+				Partitioner: RoundRobinPartitioner,
+				Compression: tt.compression,
+				Acks:        tt.acks,
+			}, repeatKeys(5, "host"))
+
+			// Records produced without acks are done before the broker reads them
+			deadline := time.Now().Add(10 * time.Second)
+			for requests.count() == 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			requests.mu.Lock()
+			defer requests.mu.Unlock()
+			if len(requests.acks) != 1 || !requests.acks[tt.wantAcks] {
+				t.Errorf("got acks %v, want %d", requests.acks, tt.wantAcks)
+			}
+			if len(requests.codecs) != 1 || !requests.codecs[tt.wantCodec] {
+				t.Errorf("got codecs %v, want %d", requests.codecs, tt.wantCodec)
+			}
+		})
+	}
+}
+
+func repeatKeys(n int, keys ...string) []string {
+	var repeated []string
+	for i := 0; i < n; i++ {
+		repeated = append(repeated, keys...)
+	}
+	return repeated
+}
@@ -1,11 +1,18 @@
 package clients
 
 import (
+	"context"
+	"fmt"
+	"net/http"
 	"net/url"
+	"sync/atomic"
 	"time"
 
+	"github.com/gorilla/websocket"
 	logcli "github.com/grafana/loki/pkg/logcli/client"
+	"github.com/grafana/loki/pkg/loghttp"
 	"github.com/grafana/loki/pkg/logproto"
+	"github.com/grafana/loki/pkg/util/unmarshal"
 	"github.com/prometheus/common/config"
 	log "github.com/sirupsen/logrus"
 )
@@ -33,15 +40,182 @@ func NewLogCLIClient(clientURL, tenant string, disableSecurityCheck bool) (*logc
 	return &client, nil
 }
 
-// QueryLogsWithLogCLI executes a query range action with logCLI
-func QueryLogsWithLogCLI(client *logcli.DefaultClient, query string, queryRange time.Duration) error {
-	now := time.Now()
-	res, err := client.QueryRange(query, 4000, now.Add(queryRange), now, logproto.FORWARD, 0, 0, false)
+// LokiQuery describes a request to one of the Loki query endpoints
+type LokiQuery struct {
+	// Query is the LogQL query or, for series requests, the stream selector
+	Query string
+	// Start and End bound the time range of the request, instant queries run at End
+	Start time.Time
+	End   time.Time
+	// Limit caps the number of log lines returned
+	Limit int
+	// Direction is the order log lines are returned in
+	Direction logproto.Direction
+	// Step is the resolution of metric queries, Interval the spacing of log lines
+	Step     time.Duration
+	Interval time.Duration
+}
 
-	log.Infof("logcli query complete. status: %s, %d results, took %f \n", res.Status, res.Data.Statistics.Ingester.TotalLinesSent, res.Data.Statistics.Summary.ExecTime)
+// QueryLogsWithLogCLI executes a query range action with logCLI and returns the number
+// of results, i.e. log lines or samples, and the statistics reported by Loki
+func QueryLogsWithLogCLI(client *logcli.DefaultClient, q LokiQuery) (QueryResult, error) {
+	c, recorder := recordStatus(client)
+	res, err := c.QueryRange(q.Query, q.Limit, q.Start, q.End, q.Direction, q.Step, q.Interval, false)
+	if err != nil {
+		return QueryResult{}, &QueryError{StatusCode: recorder.statusCode, Err: err}
+	}
+	return queryResult(res), nil
+}
 
+// InstantQueryWithLogCLI executes an instant query at the end of the time range with logCLI
+func InstantQueryWithLogCLI(client *logcli.DefaultClient, q LokiQuery) (QueryResult, error) {
+	c, recorder := recordStatus(client)
+	res, err := c.Query(q.Query, q.Limit, q.End, q.Direction, false)
 	if err != nil {
-		return err
+		return QueryResult{}, &QueryError{StatusCode: recorder.statusCode, Err: err}
+	}
+	return queryResult(res), nil
+}
+
+// SeriesWithLogCLI lists the streams matching the stream selector of the query
+func SeriesWithLogCLI(client *logcli.DefaultClient, q LokiQuery) (QueryResult, error) {
+	c, recorder := recordStatus(client)
+	res, err := c.Series([]string{q.Query}, q.Start, q.End, false)
+	if err != nil {
+		return QueryResult{}, &QueryError{StatusCode: recorder.statusCode, Err: err}
+	}
+	return QueryResult{Results: len(res.Data)}, nil
+}
+
+// LabelsWithLogCLI lists the label names within the time range
+func LabelsWithLogCLI(client *logcli.DefaultClient, q LokiQuery) (QueryResult, error) {
+	c, recorder := recordStatus(client)
+	res, err := c.ListLabelNames(false, q.Start, q.End)
+	if err != nil {
+		return QueryResult{}, &QueryError{StatusCode: recorder.statusCode, Err: err}
+	}
+	return QueryResult{Results: len(res.Data)}, nil
+}
+
+// LabelValuesWithLogCLI lists the values of a label within the time range
+func LabelValuesWithLogCLI(client *logcli.DefaultClient, label string, q LokiQuery) (QueryResult, error) {
+	c, recorder := recordStatus(client)
+	res, err := c.ListLabelValues(label, false, q.Start, q.End)
+	if err != nil {
+		return QueryResult{}, &QueryError{StatusCode: recorder.statusCode, Err: err}
+	}
+	return QueryResult{Results: len(res.Data)}, nil
+}
+
+// TailLogsWithLogCLI keeps a tail session open for the given duration or until the
+// context is done and reports the streaming latency of every log line received, i.e.
+// the time between the timestamp of the line and its arrival
+func TailLogsWithLogCLI(ctx context.Context, client *logcli.DefaultClient, q LokiQuery, duration time.Duration, observe func(time.Duration)) (QueryResult, error) {
+	conn, err := client.LiveTailQueryConn(q.Query, 0, q.Limit, time.Now(), true)
+	if err != nil {
+		return QueryResult{}, &QueryError{Err: err}
+	}
+	defer conn.Close()
+
+	// Closing the connection ends the read loop once the session is over
+	var over atomic.Bool
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		case <-done:
+			return
+		}
+		over.Store(true)
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		conn.Close()
+	}()
+
+	var result QueryResult
+	res := new(loghttp.TailResponse)
+	for {
+		if err := unmarshal.ReadTailResponseJSON(res, conn); err != nil {
+			if over.Load() || websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return result, nil
+			}
+			return result, &QueryError{Err: fmt.Errorf("error reading tail session: %s", err)}
+		}
+
+		now := time.Now()
+		for _, stream := range res.Streams {
+			for _, entry := range stream.Entries {
+				observe(now.Sub(entry.Timestamp))
+				result.Results++
+			}
+		}
+		if len(res.DroppedStreams) > 0 {
+			log.Debugf("Loki dropped %d entries of the tail session", len(res.DroppedStreams))
+		}
+	}
+}
+
+// FetchLogsWithLogCLI executes a query range action with logCLI and returns the matching streams
+func FetchLogsWithLogCLI(client *logcli.DefaultClient, query string, start, end time.Time, limit int) (loghttp.Streams, error) {
+	res, err := client.QueryRange(query, limit, start, end, logproto.FORWARD, 0, 0, true)
+	if err != nil {
+		return nil, err
+	}
+
+	streams, ok := res.Data.Result.(loghttp.Streams)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type for log query: %s", res.Data.ResultType)
+	}
+	return streams, nil
+}
+
+// recordStatus returns a copy of the client recording the status code of the last
+// response, as logCLI hides the status codes of failed requests
+func recordStatus(client *logcli.DefaultClient) (*logcli.DefaultClient, *statusRecorder) {
+	recorder := &statusRecorder{}
+	c := *client
+	c.Tripperware = func(next http.RoundTripper) http.RoundTripper {
+		recorder.next = next
+		return recorder
+	}
+	return &c, recorder
+}
+
+// queryResult returns the number of results and the statistics of a query response
+func queryResult(res *loghttp.QueryResponse) QueryResult {
+	summary := res.Data.Statistics.Summary
+	result := QueryResult{
+		Results:        resultCount(res.Data.Result),
+		BytesProcessed: summary.TotalBytesProcessed,
+		LinesProcessed: summary.TotalLinesProcessed,
+		ExecTime:       time.Duration(summary.ExecTime * float64(time.Second)),
+	}
+	if streams, ok := res.Data.Result.(loghttp.Streams); ok {
+		result.Streams = streams
+	}
+	log.Infof("logcli query complete. status: %s, %d results, took %f \n", res.Status, result.Results, summary.ExecTime)
+	return result
+}
+
+// resultCount returns the number of log lines or samples of a query result
+func resultCount(result loghttp.ResultValue) int {
+	var count int
+	switch r := result.(type) {
+	case loghttp.Streams:
+		for _, stream := range r {
+			count += len(stream.Entries)
+		}
+	case loghttp.Matrix:
+		for _, series := range r {
+			count += len(series.Values)
+		}
+	case loghttp.Vector:
+		count = len(r)
+	case loghttp.Scalar:
+		count = 1
 	}
-	return nil
+	return count
 }
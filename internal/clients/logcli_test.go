@@ -0,0 +1,44 @@
+package clients
+
+import (
+	"testing"
+
+	"github.com/grafana/loki/pkg/loghttp"
+	"github.com/prometheus/common/model"
+)
+
+func TestResultCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		result loghttp.ResultValue
+		want   int
+	}{
+		{name: "no result", result: nil, want: 0},
+		{
+			name: "streams",
+			result: loghttp.Streams{
+				{Entries: []loghttp.Entry{{Line: "a"}, {Line: "b"}}},
+				{Entries: []loghttp.Entry{{Line: "c"}}},
+			},
+			want: 3,
+		},
+		{
+			name: "matrix",
+			result: loghttp.Matrix{
+				{Values: []model.SamplePair{{Value: 1}, {Value: 2}}},
+				{Values: []model.SamplePair{{Value: 3}}},
+			},
+			want: 3,
+		},
+		{name: "vector", result: loghttp.Vector{{Value: 1}, {Value: 2}}, want: 2},
+		{name: "scalar", result: loghttp.Scalar{Value: 1}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resultCount(tt.result); got != tt.want {
+				t.Errorf("got %d results, want %d", got, tt.want)
+			}
+		})
+	}
+}
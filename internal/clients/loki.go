@@ -0,0 +1,217 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/prometheus/common/model"
+)
+
+// LokiEncoding describes how the body of Loki push requests is encoded
+type LokiEncoding string
+
+const (
+	// LokiProtobufEncoding encodes push requests as snappy compressed protobuf
+	LokiProtobufEncoding LokiEncoding = "protobuf"
+
+	// LokiJSONEncoding encodes push requests as JSON
+	LokiJSONEncoding LokiEncoding = "json"
+
+	// GzipCompression compresses request bodies with gzip
+	GzipCompression = "gzip"
+)
+
+// LokiConfig describes the settings of a Loki push client
+type LokiConfig struct {
+	// URL is the push endpoint, e.g. http://localhost:3100/loki/api/v1/push
+	URL string
+	// TenantID is sent in the X-Scope-OrgID header if set
+	TenantID string
+	// DisableSecurityCheck deactivates the TLS checks and the service account token
+	DisableSecurityCheck bool
+	// Encoding is the encoding of the push requests
+	Encoding LokiEncoding
+	// Compression is the content encoding applied on top of the body, "gzip" or none
+	Compression string
+	// Batch configures batching and retries
+	Batch BatchConfig
+	// OnBatch is called with the outcome of every batch, if set
+	OnBatch BatchObserver
+}
+
+// LokiEntry describes a log pushed to a Loki stream
+type LokiEntry struct {
+	Labels model.LabelSet
+	logproto.Entry
+}
+
+// LokiClient pushes batches of logs to the Loki push API
+type LokiClient struct {
+	cfg     LokiConfig
+	client  *http.Client
+	batcher *batcher[LokiEntry]
+}
+
+type lokiJSONStream struct {
+	Stream model.LabelSet  `json:"stream"`
+	Values [][]interface{} `json:"values"`
+}
+
+// NewLokiClient creates a Loki push client
+func NewLokiClient(cfg LokiConfig) (*LokiClient, error) {
+	if _, err := url.Parse(cfg.URL); err != nil {
+		return nil, err
+	}
+
+	switch cfg.Encoding {
+	case LokiProtobufEncoding, LokiJSONEncoding:
+	default:
+		return nil, fmt.Errorf("unknown loki encoding: %s", cfg.Encoding)
+	}
+
+	switch cfg.Compression {
+	case "", "none", GzipCompression:
+	default:
+		return nil, fmt.Errorf("unknown loki compression: %s", cfg.Compression)
+	}
+
+	httpClient, err := newHTTPClient("loki", cfg.DisableSecurityCheck)
+	if err != nil {
+		return nil, err
+	}
+	httpClient.Timeout = 30 * time.Second
+
+	c := &LokiClient{
+		cfg:    cfg,
+		client: httpClient,
+	}
+	c.batcher = newBatcher(cfg.Batch, lokiEntrySize, c.flush)
+	return c, nil
+}
+
+// Send adds a log written by the given worker to the current batch
+func (c *LokiClient) Send(worker string, entry LokiEntry) {
+	c.batcher.Add(worker, entry)
+}
+
+// Stop pushes the logs left in the current batch
+func (c *LokiClient) Stop() {
+	c.batcher.Stop()
+}
+
+func (c *LokiClient) flush(ctx context.Context, entries []LokiEntry, workers map[string]int) {
+	start := time.Now()
+	result := BatchResult{
+		Entries: len(entries),
+		Workers: workers,
+	}
+
+	body, contentType, err := c.encode(entries)
+	if err == nil && c.cfg.Compression == GzipCompression {
+		body, err = gzipBody(body)
+	}
+	if err == nil {
+		result.Bytes = len(body)
+		err = sendWithRetry(ctx, c.cfg.Batch.Backoff, func() error {
+			return c.push(body, contentType)
+		})
+	}
+
+	result.Duration = time.Since(start)
+	result.Err = err
+	if c.cfg.OnBatch != nil {
+		c.cfg.OnBatch(result)
+	}
+}
+
+func (c *LokiClient) encode(entries []LokiEntry) ([]byte, string, error) {
+	var (
+		streams []*logproto.Stream
+		labels  []model.LabelSet
+		index   = map[string]int{}
+	)
+	for _, e := range entries {
+		key := e.Labels.String()
+		i, ok := index[key]
+		if !ok {
+			i = len(streams)
+			index[key] = i
+			streams = append(streams, &logproto.Stream{Labels: key})
+			labels = append(labels, e.Labels)
+		}
+		streams[i].Entries = append(streams[i].Entries, e.Entry)
+	}
+
+	if c.cfg.Encoding == LokiJSONEncoding {
+		req := struct {
+			Streams []lokiJSONStream `json:"streams"`
+		}{}
+		for i, stream := range streams {
+			s := lokiJSONStream{Stream: labels[i]}
+			for _, entry := range stream.Entries {
+				value := []interface{}{strconv.FormatInt(entry.Timestamp.UnixNano(), 10), entry.Line}
+				if len(entry.StructuredMetadata) > 0 {
+					metadata := map[string]string{}
+					for _, l := range entry.StructuredMetadata {
+						metadata[l.Name] = l.Value
+					}
+					value = append(value, metadata)
+				}
+				s.Values = append(s.Values, value)
+			}
+			req.Streams = append(req.Streams, s)
+		}
+
+		body, err := json.Marshal(req)
+		return body, "application/json", err
+	}
+
+	req := logproto.PushRequest{}
+	for _, stream := range streams {
+		req.Streams = append(req.Streams, *stream)
+	}
+	data, err := req.Marshal()
+	if err != nil {
+		return nil, "", err
+	}
+	return snappy.Encode(nil, data), "application/x-protobuf", nil
+}
+
+func (c *LokiClient) push(body []byte, contentType string) error {
+	req, err := http.NewRequest(http.MethodPost, c.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "cluster-logging-load-client")
+	if c.cfg.Compression == GzipCompression {
+		req.Header.Set("Content-Encoding", GzipCompression)
+	}
+	if c.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", c.cfg.TenantID)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return retryableError{err}
+	}
+	defer res.Body.Close()
+
+	return checkResponse(res)
+}
+
+func lokiEntrySize(e LokiEntry) int {
+	size := len(e.Line)
+	for _, l := range e.StructuredMetadata {
+		size += len(l.Name) + len(l.Value)
+	}
+	return size
+}
@@ -0,0 +1,189 @@
+package clients
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/prometheus/common/model"
+)
+
+// lokiStandIn is a Loki push endpoint recording the lines pushed and answering with the
+// given status codes in turn, the last one is repeated
+type lokiStandIn struct {
+	t        *testing.T
+	statuses []int
+
+	mu       sync.Mutex
+	requests int
+	lines    map[string][]string
+}
+
+func (s *lokiStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.statuses[len(s.statuses)-1]
+	if s.requests < len(s.statuses) {
+		status = s.statuses[s.requests]
+	}
+	s.requests++
+	if status != http.StatusNoContent {
+		http.Error(w, "stand-in error", status)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.t.Errorf("error reading body: %s", err)
+	}
+	if r.Header.Get("Content-Encoding") == GzipCompression {
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			s.t.Fatalf("error reading gzip body: %s", err)
+		}
+		if body, err = io.ReadAll(gz); err != nil {
+			s.t.Fatalf("error reading gzip body: %s", err)
+		}
+	}
+
+	switch r.Header.Get("Content-Type") {
+	case "application/x-protobuf":
+		data, err := snappy.Decode(nil, body)
+		if err != nil {
+			s.t.Fatalf("error decoding snappy body: %s", err)
+		}
+		var req logproto.PushRequest
+		if err := req.Unmarshal(data); err != nil {
+			s.t.Fatalf("error decoding protobuf body: %s", err)
+		}
+		for _, stream := range req.Streams {
+			for _, e := range stream.Entries {
+				s.lines[stream.Labels] = append(s.lines[stream.Labels], e.Line)
+			}
+		}
+	case "application/json":
+		var req struct {
+			Streams []lokiJSONStream `json:"streams"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.t.Fatalf("error decoding json body: %s", err)
+		}
+		for _, stream := range req.Streams {
+			for _, value := range stream.Values {
+				s.lines[stream.Stream.String()] = append(s.lines[stream.Stream.String()], value[1].(string))
+			}
+		}
+	default:
+		s.t.Errorf("unexpected content type %q", r.Header.Get("Content-Type"))
+	}
+	w.WriteHeader(status)
+}
+
+func TestLokiClient(t *testing.T) {
+	tests := []struct {
+		name        string
+		encoding    LokiEncoding
+		compression string
+		statuses    []int
+		// wantRequests is the number of requests expected for the single batch
+		wantRequests int
+		wantErr      bool
+	}{
+		{name: "protobuf", encoding: LokiProtobufEncoding, statuses: []int{http.StatusNoContent}, wantRequests: 1},
+		{name: "json", encoding: LokiJSONEncoding, statuses: []int{http.StatusNoContent}, wantRequests: 1},
+		{name: "protobuf gzip", encoding: LokiProtobufEncoding, compression: GzipCompression, statuses: []int{http.StatusNoContent}, wantRequests: 1},
+		{name: "json gzip", encoding: LokiJSONEncoding, compression: GzipCompression, statuses: []int{http.StatusNoContent}, wantRequests: 1},
+		{
+			name:         "server errors retried",
+			encoding:     LokiProtobufEncoding,
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusNoContent},
+			wantRequests: 3,
+		},
+		{
+			name:         "rate limit retried",
+			encoding:     LokiJSONEncoding,
+			statuses:     []int{http.StatusTooManyRequests, http.StatusNoContent},
+			wantRequests: 2,
+		},
+		{
+			name:         "client error dropped",
+			encoding:     LokiProtobufEncoding,
+			statuses:     []int{http.StatusBadRequest, http.StatusNoContent},
+			wantRequests: 1,
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			standIn := &lokiStandIn{t: t, statuses: tt.statuses, lines: map[string][]string{}}
+			server := httptest.NewServer(standIn)
+			defer server.Close()
+
+			results := make(chan BatchResult, 1)
+			client, err := NewLokiClient(LokiConfig{
+				URL:                  server.URL,
+				DisableSecurityCheck: true,
+				Encoding:             tt.encoding,
+				Compression:          tt.compression,
+				Batch: BatchConfig{
+					// The three lines fill a batch, batches flushed on stop are not retried
+					Size: len("first") + len("second") + len("third"),
+					Wait: time.Minute,
+					Backoff: backoff.Config{
+						MinBackoff: time.Millisecond,
+						MaxBackoff: time.Millisecond,
+						MaxRetries: 5,
+					},
+				},
+				OnBatch: func(result BatchResult) {
+					results <- result
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			labels := model.LabelSet{"client": "promtail"}
+			for _, line := range []string{"first", "second", "third"} {
+				client.Send("0", LokiEntry{Labels: labels, Entry: logproto.Entry{Timestamp: time.Now(), Line: line}})
+			}
+
+			var result BatchResult
+			select {
+			case result = <-results:
+			case <-time.After(10 * time.Second):
+				t.Fatal("batch not sent")
+			}
+			client.Stop()
+
+			if standIn.requests != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", standIn.requests, tt.wantRequests)
+			}
+			if result.Entries != 3 || result.Workers["0"] != 3 {
+				t.Errorf("got %d entries by workers %v, want 3 by worker 0", result.Entries, result.Workers)
+			}
+			if (result.Err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", result.Err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got := standIn.lines[labels.String()]
+			if len(got) != 3 || got[0] != "first" || got[2] != "third" {
+				t.Errorf("got lines %v in stream %s", got, labels)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceCA           = "/var/run/secrets/kubernetes.io/serviceaccount/service-ca.crt"
+)
+
+// OTLPProtocol describes the transport and encoding of OTLP export requests
+type OTLPProtocol string
+
+const (
+	// OTLPHTTPProtobufProtocol posts protobuf encoded requests over HTTP
+	OTLPHTTPProtobufProtocol OTLPProtocol = "http/protobuf"
+
+	// OTLPHTTPJSONProtocol posts JSON encoded requests over HTTP
+	OTLPHTTPJSONProtocol OTLPProtocol = "http/json"
+
+	// OTLPGRPCProtocol calls the logs service over gRPC
+	OTLPGRPCProtocol OTLPProtocol = "grpc"
+)
+
+// OTLPConfig describes the settings of an OTLP logs client
+type OTLPConfig struct {
+	// URL is the logs endpoint, e.g. http://localhost:4318/v1/logs for HTTP or
+	// http://localhost:4317 for gRPC. gRPC uses TLS for https URLs.
+	URL string
+	// DisableSecurityCheck deactivates the TLS checks and the service account token
+	DisableSecurityCheck bool
+	// Protocol is the transport and encoding of the export requests
+	Protocol OTLPProtocol
+	// Compression is the content encoding applied to the requests, "gzip" or none
+	Compression string
+	// Batch configures batching and retries
+	Batch BatchConfig
+	// OnBatch is called with the outcome of every batch, if set
+	OnBatch BatchObserver
+}
+
+// OTLPEntry describes a log record exported with the attributes of the resource
+// which emitted it
+type OTLPEntry struct {
+	Resource     map[string]string
+	Timestamp    time.Time
+	SeverityText string
+	Severity     plog.SeverityNumber
+	Body         string
+}
+
+// OTLPClient exports batches of logs with the OpenTelemetry protocol
+type OTLPClient struct {
+	cfg     OTLPConfig
+	client  *http.Client
+	conn    *grpc.ClientConn
+	grpc    plogotlp.GRPCClient
+	batcher *batcher[OTLPEntry]
+}
+
+// NewOTLPClient creates an OTLP logs client
+func NewOTLPClient(cfg OTLPConfig) (*OTLPClient, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Compression {
+	case "", "none", GzipCompression:
+	default:
+		return nil, fmt.Errorf("unknown otlp compression: %s", cfg.Compression)
+	}
+
+	c := &OTLPClient{
+		cfg: cfg,
+	}
+
+	switch cfg.Protocol {
+	case OTLPHTTPProtobufProtocol, OTLPHTTPJSONProtocol:
+		c.client, err = newHTTPClient("otlp", cfg.DisableSecurityCheck)
+		if err != nil {
+			return nil, err
+		}
+		c.client.Timeout = 30 * time.Second
+	case OTLPGRPCProtocol:
+		opts, err := grpcDialOptions(u.Scheme == "https", cfg.DisableSecurityCheck)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Compression == GzipCompression {
+			opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)))
+		}
+
+		c.conn, err = grpc.Dial(u.Host, opts...)
+		if err != nil {
+			return nil, err
+		}
+		c.grpc = plogotlp.NewGRPCClient(c.conn)
+	default:
+		return nil, fmt.Errorf("unknown otlp protocol: %s", cfg.Protocol)
+	}
+
+	c.batcher = newBatcher(cfg.Batch, otlpEntrySize, c.flush)
+	return c, nil
+}
+
+// Send adds a log written by the given worker to the current batch
+func (c *OTLPClient) Send(worker string, entry OTLPEntry) {
+	c.batcher.Add(worker, entry)
+}
+
+// Stop exports the logs left in the current batch and closes the connection
+func (c *OTLPClient) Stop() {
+	c.batcher.Stop()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+func (c *OTLPClient) flush(ctx context.Context, entries []OTLPEntry, workers map[string]int) {
+	start := time.Now()
+	result := BatchResult{
+		Entries: len(entries),
+		Workers: workers,
+	}
+
+	req := plogotlp.NewExportRequestFromLogs(otlpLogs(entries))
+
+	var err error
+	if c.cfg.Protocol == OTLPGRPCProtocol {
+		err = sendWithRetry(ctx, c.cfg.Batch.Backoff, func() error {
+			rejected, err := c.export(req)
+			result.Rejected = rejected
+			return err
+		})
+	} else {
+		var (
+			body        []byte
+			contentType = "application/x-protobuf"
+		)
+		if c.cfg.Protocol == OTLPHTTPJSONProtocol {
+			body, err = req.MarshalJSON()
+			contentType = "application/json"
+		} else {
+			body, err = req.MarshalProto()
+		}
+		if err == nil && c.cfg.Compression == GzipCompression {
+			body, err = gzipBody(body)
+		}
+		if err == nil {
+			result.Bytes = len(body)
+			err = sendWithRetry(ctx, c.cfg.Batch.Backoff, func() error {
+				rejected, err := c.post(body, contentType)
+				result.Rejected = rejected
+				return err
+			})
+		}
+	}
+
+	result.Duration = time.Since(start)
+	result.Err = err
+	if c.cfg.OnBatch != nil {
+		c.cfg.OnBatch(result)
+	}
+}
+
+func (c *OTLPClient) export(req plogotlp.ExportRequest) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := c.grpc.Export(ctx, req)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.Canceled, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted,
+			codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+			return 0, retryableError{err}
+		}
+		return 0, err
+	}
+	return int(res.PartialSuccess().RejectedLogRecords()), nil
+}
+
+func (c *OTLPClient) post(body []byte, contentType string) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, c.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "cluster-logging-load-client")
+	if c.cfg.Compression == GzipCompression {
+		req.Header.Set("Content-Encoding", GzipCompression)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return 0, retryableError{err}
+	}
+	defer res.Body.Close()
+
+	if err := checkResponse(res); err != nil {
+		return 0, err
+	}
+
+	// The response body is optional, but reports the records rejected by the receiver
+	data, err := io.ReadAll(res.Body)
+	if err != nil || len(data) == 0 {
+		return 0, nil
+	}
+	response := plogotlp.NewExportResponse()
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		err = response.UnmarshalJSON(data)
+	} else {
+		err = response.UnmarshalProto(data)
+	}
+	if err != nil {
+		return 0, nil
+	}
+	return int(response.PartialSuccess().RejectedLogRecords()), nil
+}
+
+// otlpLogs groups the entries by resource
+func otlpLogs(entries []OTLPEntry) plog.Logs {
+	logs := plog.NewLogs()
+	index := map[string]plog.LogRecordSlice{}
+	for _, e := range entries {
+		key := resourceKey(e.Resource)
+		records, ok := index[key]
+		if !ok {
+			rl := logs.ResourceLogs().AppendEmpty()
+			for name, value := range e.Resource {
+				rl.Resource().Attributes().PutStr(name, value)
+			}
+			records = rl.ScopeLogs().AppendEmpty().LogRecords()
+			index[key] = records
+		}
+
+		record := records.AppendEmpty()
+		record.SetTimestamp(pcommon.NewTimestampFromTime(e.Timestamp))
+		record.SetObservedTimestamp(pcommon.NewTimestampFromTime(e.Timestamp))
+		record.SetSeverityText(e.SeverityText)
+		record.SetSeverityNumber(e.Severity)
+		record.Body().SetStr(e.Body)
+	}
+	return logs
+}
+
+func resourceKey(resource map[string]string) string {
+	pairs := make([]string, 0, len(resource))
+	for name, value := range resource {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func otlpEntrySize(e OTLPEntry) int {
+	return len(e.Body)
+}
+
+// grpcDialOptions configures the transport security of gRPC connections like
+// newHTTPClient does for HTTP clients
+func grpcDialOptions(useTLS, disableSecurityCheck bool) ([]grpc.DialOption, error) {
+	if !useTLS {
+		return []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: disableSecurityCheck,
+	}
+	if disableSecurityCheck {
+		return []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))}, nil
+	}
+
+	ca, err := os.ReadFile(serviceCA)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA cert: %s", err)
+	}
+	tlsConfig.RootCAs = x509.NewCertPool()
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", serviceCA)
+	}
+
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		grpc.WithPerRPCCredentials(tokenCredentials(serviceAccountToken)),
+	}, nil
+}
+
+// tokenCredentials authenticates gRPC calls with the bearer token read from a file
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	token, err := os.ReadFile(string(t))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"authorization": "Bearer " + strings.TrimSpace(string(token)),
+	}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return true
+}
@@ -0,0 +1,266 @@
+package clients
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/backoff"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// otlpReceiver records the logs exported to it and rejects some of their records
+type otlpReceiver struct {
+	plogotlp.UnimplementedGRPCServer
+
+	mu       sync.Mutex
+	logs     []plog.Logs
+	rejected int64
+	fail     bool
+}
+
+func (r *otlpReceiver) receive(req plogotlp.ExportRequest) (plogotlp.ExportResponse, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	logs := plog.NewLogs()
+	req.Logs().CopyTo(logs)
+	r.logs = append(r.logs, logs)
+
+	res := plogotlp.NewExportResponse()
+	res.PartialSuccess().SetRejectedLogRecords(r.rejected)
+	return res, !r.fail
+}
+
+func (r *otlpReceiver) Export(_ context.Context, req plogotlp.ExportRequest) (plogotlp.ExportResponse, error) {
+	res, ok := r.receive(req)
+	if !ok {
+		return res, status.Error(codes.InvalidArgument, "invalid logs")
+	}
+	return res, nil
+}
+
+func (r *otlpReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body io.Reader = req.Body
+	if req.Header.Get("Content-Encoding") == GzipCompression {
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = gz
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json := req.Header.Get("Content-Type") == "application/json"
+	export := plogotlp.NewExportRequest()
+	if json {
+		err = export.UnmarshalJSON(data)
+	} else {
+		err = export.UnmarshalProto(data)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, ok := r.receive(export)
+	if !ok {
+		http.Error(w, "invalid logs", http.StatusBadRequest)
+		return
+	}
+	if json {
+		data, err = res.MarshalJSON()
+	} else {
+		data, err = res.MarshalProto()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", req.Header.Get("Content-Type"))
+	w.Write(data)
+}
+
+// newOTLPReceiver starts a server for the protocol and returns the URL of its logs endpoint
+func newOTLPReceiver(t *testing.T, protocol OTLPProtocol, receiver *otlpReceiver) string {
+	if protocol != OTLPGRPCProtocol {
+		server := httptest.NewServer(receiver)
+		t.Cleanup(server.Close)
+		return server.URL + "/v1/logs"
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	plogotlp.RegisterGRPCServer(server, receiver)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return "http://" + listener.Addr().String()
+}
+
+func TestOTLPClient(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	entries := []OTLPEntry{
+		{
+			Resource:     map[string]string{"host.name": "host-1", "service.name": "app"},
+			Timestamp:    at,
+			SeverityText: "error",
+			Severity:     plog.SeverityNumberError,
+			Body:         "first",
+		},
+		{
+			Resource:     map[string]string{"host.name": "host-2", "service.name": "app"},
+			Timestamp:    at,
+			SeverityText: "info",
+			Severity:     plog.SeverityNumberInfo,
+			Body:         "second",
+		},
+		{
+			Resource:     map[string]string{"service.name": "app", "host.name": "host-1"},
+			Timestamp:    at,
+			SeverityText: "info",
+			Severity:     plog.SeverityNumberInfo,
+			Body:         "third",
+		},
+	}
+
+	tests := []struct {
+		name         string
+		protocol     OTLPProtocol
+		compression  string
+		rejected     int64
+		fail         bool
+		wantRejected int
+		wantErr      bool
+	}{
+		{
+			name:     "http/protobuf",
+			protocol: OTLPHTTPProtobufProtocol,
+		},
+		{
+			name:         "http/protobuf partial success",
+			protocol:     OTLPHTTPProtobufProtocol,
+			compression:  GzipCompression,
+			rejected:     2,
+			wantRejected: 2,
+		},
+		{
+			name:         "http/json partial success",
+			protocol:     OTLPHTTPJSONProtocol,
+			rejected:     1,
+			wantRejected: 1,
+		},
+		{
+			name:     "http/json failure",
+			protocol: OTLPHTTPJSONProtocol,
+			fail:     true,
+			wantErr:  true,
+		},
+		{
+			name:        "grpc",
+			protocol:    OTLPGRPCProtocol,
+			compression: GzipCompression,
+		},
+		{
+			name:         "grpc partial success",
+			protocol:     OTLPGRPCProtocol,
+			rejected:     1,
+			wantRejected: 1,
+		},
+		{
+			name:     "grpc failure",
+			protocol: OTLPGRPCProtocol,
+			fail:     true,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := &otlpReceiver{rejected: tt.rejected, fail: tt.fail}
+			url := newOTLPReceiver(t, tt.protocol, receiver)
+
+			results := make(chan BatchResult, 1)
+			client, err := NewOTLPClient(OTLPConfig{
+				URL:                  url,
+				DisableSecurityCheck: true,
+				Protocol:             tt.protocol,
+				Compression:          tt.compression,
+				Batch: BatchConfig{
+					Size: 1 << 20,
+					Wait: time.Hour,
+					Backoff: backoff.Config{
+						MinBackoff: time.Millisecond,
+						MaxBackoff: time.Millisecond,
+						MaxRetries: 2,
+					},
+				},
+				OnBatch: func(res BatchResult) { results <- res },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, e := range entries {
+				client.Send([]string{"0", "1", "1"}[i], e)
+			}
+			// Stopping flushes the batch
+			client.Stop()
+
+			res := <-results
+			if (res.Err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", res.Err, tt.wantErr)
+			}
+			if res.Rejected != tt.wantRejected {
+				t.Errorf("got %d rejected logs, want %d", res.Rejected, tt.wantRejected)
+			}
+			if res.Entries != len(entries) || res.Workers["0"] != 1 || res.Workers["1"] != 2 {
+				t.Errorf("got %d entries of workers %v, want 3 entries of workers 0 and 1", res.Entries, res.Workers)
+			}
+
+			receiver.mu.Lock()
+			defer receiver.mu.Unlock()
+			// Failed requests are not retried
+			if len(receiver.logs) != 1 {
+				t.Fatalf("got %d requests, want 1", len(receiver.logs))
+			}
+			got := map[string][]string{}
+			logs := receiver.logs[0]
+			for i := 0; i < logs.ResourceLogs().Len(); i++ {
+				rl := logs.ResourceLogs().At(i)
+				host, _ := rl.Resource().Attributes().Get("host.name")
+				records := rl.ScopeLogs().At(0).LogRecords()
+				for j := 0; j < records.Len(); j++ {
+					r := records.At(j)
+					if !r.Timestamp().AsTime().Equal(at) {
+						t.Errorf("got timestamp %s, want %s", r.Timestamp().AsTime(), at)
+					}
+					got[host.Str()] = append(got[host.Str()], r.SeverityText()+" "+r.Body().Str())
+				}
+			}
+			want := map[string][]string{
+				"host-1": {"error first", "info third"},
+				"host-2": {"info second"},
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got records by host %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package clients
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// PodLogConfig describes the pods simulated by a pod log client
+type PodLogConfig struct {
+	// Directory is the root of the pod log directories, e.g. /var/log/pods
+	Directory string
+	// Namespaces is the number of namespaces, Pods the number of pods per namespace
+	// and Containers the number of containers per pod
+	Namespaces int
+	Pods       int
+	Containers int
+	// ChurnInterval is the interval at which a random pod is deleted and replaced by a
+	// new one, pods are never replaced if 0
+	ChurnInterval time.Duration
+	// RestartInterval is the interval at which a random container restarts, containers
+	// never restart if 0
+	RestartInterval time.Duration
+}
+
+// PodLogClient writes logs into the files the kubelet lays out for the containers of the
+// pods of a node, /<directory>/<namespace>_<pod>_<uid>/<container>/<restart count>.log.
+// Every line is written to the current file of a random container.
+type PodLogClient struct {
+	cfg  PodLogConfig
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	mu   sync.Mutex
+	pods []*pod
+}
+
+// pod is a simulated pod with the log files of its containers
+type pod struct {
+	dir        string
+	containers []*podContainer
+}
+
+// podContainer is a simulated container writing to the file of its current restart
+type podContainer struct {
+	dir      string
+	restarts int
+	file     *os.File
+}
+
+// NewPodLogClient creates the log directories and files of the pods and starts
+// replacing pods and restarting containers at the configured intervals
+func NewPodLogClient(cfg PodLogConfig) (*PodLogClient, error) {
+	if cfg.Namespaces < 1 || cfg.Pods < 1 || cfg.Containers < 1 {
+		return nil, fmt.Errorf("invalid number of namespaces %d, pods %d or containers %d: must be at least 1",
+			cfg.Namespaces, cfg.Pods, cfg.Containers)
+	}
+	if cfg.ChurnInterval < 0 || cfg.RestartInterval < 0 {
+		return nil, fmt.Errorf("invalid churn interval %s or restart interval %s: must not be negative",
+			cfg.ChurnInterval, cfg.RestartInterval)
+	}
+
+	c := &PodLogClient{
+		cfg:  cfg,
+		stop: make(chan struct{}),
+	}
+	for i := 0; i < cfg.Namespaces*cfg.Pods; i++ {
+		p, err := c.createPod(i / cfg.Pods)
+		if err != nil {
+			c.closeFiles()
+			return nil, err
+		}
+		c.pods = append(c.pods, p)
+	}
+
+	c.wg.Add(1)
+	go c.run()
+	return c, nil
+}
+
+// Write appends a CRI formatted line to the log file of a random container
+func (c *PodLogClient) Write(line string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := c.pods[rand.Intn(len(c.pods))]
+	_, err := p.containers[rand.Intn(len(p.containers))].file.WriteString(line)
+	return err
+}
+
+// Close stops replacing pods and restarting containers and closes the log files. The
+// files are left in place for collectors to finish reading them.
+func (c *PodLogClient) Close() {
+	close(c.stop)
+	c.wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeFiles()
+}
+
+func (c *PodLogClient) run() {
+	defer c.wg.Done()
+
+	var churn, restart <-chan time.Time
+	if c.cfg.ChurnInterval > 0 {
+		ticker := time.NewTicker(c.cfg.ChurnInterval)
+		defer ticker.Stop()
+		churn = ticker.C
+	}
+	if c.cfg.RestartInterval > 0 {
+		ticker := time.NewTicker(c.cfg.RestartInterval)
+		defer ticker.Stop()
+		restart = ticker.C
+	}
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-churn:
+			if err := c.replacePod(); err != nil {
+				log.Errorf("error replacing pod: %s", err)
+			}
+		case <-restart:
+			if err := c.restartContainer(); err != nil {
+				log.Errorf("error restarting container: %s", err)
+			}
+		}
+	}
+}
+
+// replacePod deletes a random pod along with its log directory, like the kubelet does
+// once a pod is removed, and creates a new pod in the same namespace
+func (c *PodLogClient) replacePod() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := rand.Intn(len(c.pods))
+	p, err := c.createPod(i / c.cfg.Pods)
+	if err != nil {
+		return err
+	}
+
+	old := c.pods[i]
+	c.pods[i] = p
+	old.closeFiles()
+	log.Debugf("replacing pod %s with %s", filepath.Base(old.dir), filepath.Base(p.dir))
+	return os.RemoveAll(old.dir)
+}
+
+// restartContainer moves a random container on to the log file of its next restart.
+// The kubelet keeps the log file of the previous container only.
+func (c *PodLogClient) restartContainer() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := c.pods[rand.Intn(len(c.pods))]
+	container := p.containers[rand.Intn(len(p.containers))]
+
+	file, err := openContainerLog(container.dir, container.restarts+1)
+	if err != nil {
+		return err
+	}
+	container.file.Close()
+	container.file = file
+	container.restarts++
+	log.Debugf("restarting container %s, restart count %d", container.dir, container.restarts)
+
+	if container.restarts < 2 {
+		return nil
+	}
+	return os.Remove(containerLogPath(container.dir, container.restarts-2))
+}
+
+// createPod creates the log directories and files of a new pod in the given namespace
+func (c *PodLogClient) createPod(namespace int) (*pod, error) {
+	name := fmt.Sprintf("loader-%d-%s", namespace, randomPodSuffix())
+	p := &pod{
+		dir: filepath.Join(c.cfg.Directory, fmt.Sprintf("loader-ns-%d_%s_%s", namespace, name, uuid.NewString())),
+	}
+
+	for i := 0; i < c.cfg.Containers; i++ {
+		dir := filepath.Join(p.dir, fmt.Sprintf("container-%d", i))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			p.closeFiles()
+			return nil, fmt.Errorf("unable to create pod log directory %s: %s", dir, err)
+		}
+		file, err := openContainerLog(dir, 0)
+		if err != nil {
+			p.closeFiles()
+			return nil, err
+		}
+		p.containers = append(p.containers, &podContainer{dir: dir, file: file})
+	}
+	return p, nil
+}
+
+func (p *pod) closeFiles() {
+	for _, container := range p.containers {
+		container.file.Close()
+	}
+}
+
+func (c *PodLogClient) closeFiles() {
+	for _, p := range c.pods {
+		p.closeFiles()
+	}
+}
+
+func containerLogPath(dir string, restarts int) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.log", restarts))
+}
+
+func openContainerLog(dir string, restarts int) (*os.File, error) {
+	path := containerLogPath(dir, restarts)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create container log %s: %s", path, err)
+	}
+	return file, nil
+}
+
+// randomPodSuffix returns the random suffix of a pod name, e.g. the 7c5d9 of
+// loader-0-7c5d9
+func randomPodSuffix() string {
+	const alphabet = "bcdfghjklmnpqrstvwxz2456789"
+	suffix := make([]byte, 5)
+	for i := range suffix {
+		suffix[i] = alphabet[rand.Intn(len(alphabet))]
+	}
+	return string(suffix)
+}
@@ -0,0 +1,160 @@
+package clients
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var podDirPattern = regexp.MustCompile(`^loader-ns-(\d+)_loader-(\d+)-[b-z2-9]{5}_[0-9a-f-]{36}$`)
+
+// podDirs returns the pod log directories by namespace
+func podDirs(t *testing.T, root string) map[string][]string {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := map[string][]string{}
+	for _, entry := range entries {
+		m := podDirPattern.FindStringSubmatch(entry.Name())
+		if m == nil || m[1] != m[2] {
+			t.Errorf("got unexpected pod directory %s", entry.Name())
+			continue
+		}
+		dirs[m[1]] = append(dirs[m[1]], entry.Name())
+	}
+	return dirs
+}
+
+// containerLogs returns the log files of a container directory
+func containerLogs(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestPodLogClientLayout(t *testing.T) {
+	root := t.TempDir()
+	c, err := NewPodLogClient(PodLogConfig{Directory: root, Namespaces: 2, Pods: 3, Containers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const lines = 100
+	for i := 0; i < lines; i++ {
+		if err := c.Write("2024-05-01T12:00:00Z stdout F line\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Close()
+
+	dirs := podDirs(t, root)
+	if len(dirs) != 2 || len(dirs["0"]) != 3 || len(dirs["1"]) != 3 {
+		t.Fatalf("got pod directories %v, want 3 in each of 2 namespaces", dirs)
+	}
+
+	var written int
+	for _, pods := range dirs {
+		for _, pod := range pods {
+			for _, container := range []string{"container-0", "container-1"} {
+				dir := filepath.Join(root, pod, container)
+				if files := containerLogs(t, dir); len(files) != 1 || files[0] != "0.log" {
+					t.Errorf("got log files %v in %s, want 0.log", files, dir)
+				}
+				data, err := os.ReadFile(filepath.Join(dir, "0.log"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				written += strings.Count(string(data), "\n")
+			}
+		}
+	}
+	if written != lines {
+		t.Errorf("got %d lines written, want %d", written, lines)
+	}
+}
+
+func TestPodLogClientChurn(t *testing.T) {
+	root := t.TempDir()
+	c, err := NewPodLogClient(PodLogConfig{Directory: root, Namespaces: 2, Pods: 2, Containers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	before := podDirs(t, root)
+	if err := c.replacePod(); err != nil {
+		t.Fatal(err)
+	}
+	after := podDirs(t, root)
+
+	// A single pod is replaced by a new one in the same namespace
+	var replaced int
+	for namespace, pods := range before {
+		if len(after[namespace]) != len(pods) {
+			t.Errorf("got pods %v in namespace %s after churn, want %d", after[namespace], namespace, len(pods))
+		}
+		for _, pod := range pods {
+			if !slices.Contains(after[namespace], pod) {
+				replaced++
+			}
+		}
+	}
+	if replaced != 1 {
+		t.Errorf("got %d pods replaced, want 1: %v before, %v after", replaced, before, after)
+	}
+	for i := 0; i < 10; i++ {
+		if err := c.Write("line\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPodLogClientRestart(t *testing.T) {
+	root := t.TempDir()
+	c, err := NewPodLogClient(PodLogConfig{Directory: root, Namespaces: 1, Pods: 1, Containers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	dir := c.pods[0].containers[0].dir
+	tests := []struct {
+		restarts int
+		want     []string
+	}{
+		{restarts: 1, want: []string{"0.log", "1.log"}},
+		{restarts: 2, want: []string{"1.log", "2.log"}},
+		{restarts: 3, want: []string{"2.log", "3.log"}},
+	}
+	for _, tt := range tests {
+		if err := c.restartContainer(); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Write("line\n"); err != nil {
+			t.Fatal(err)
+		}
+
+		files := containerLogs(t, dir)
+		if strings.Join(files, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("got log files %v after %d restarts, want %v", files, tt.restarts, tt.want)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, tt.want[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "line\n" {
+			t.Errorf("got %q in the log of restart %d, want the line written since", data, tt.restarts)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package clients
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/grafana/loki/pkg/loghttp"
+)
+
+// QueryResult describes the outcome of a query
+type QueryResult struct {
+	// Results is the number of log lines, samples or documents returned
+	Results int
+	// BytesProcessed and LinesProcessed are the amount of data the backend scanned,
+	// if reported
+	BytesProcessed int64
+	LinesProcessed int64
+	// ExecTime is the time the backend reports to have spent executing the query
+	ExecTime time.Duration
+	// Streams are the log lines returned by Loki log queries
+	Streams loghttp.Streams
+	// Hits are the documents returned by Elasticsearch queries
+	Hits []SearchHit
+}
+
+// QueryError is returned for queries which failed, StatusCode is the status code of
+// the last response or 0 if no response was received
+type QueryError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *QueryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// statusRecorder records the status code of the last response of a round tripper
+type statusRecorder struct {
+	next       http.RoundTripper
+	statusCode int
+}
+
+func (r *statusRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := r.next.RoundTrip(req)
+	if err == nil {
+		r.statusCode = res.StatusCode
+	}
+	return res, err
+}
@@ -0,0 +1,386 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// splunkAckInterval is the time between two polls of the indexer acknowledgements
+const splunkAckInterval = 1 * time.Second
+
+// SplunkEndpoint describes the HTTP Event Collector endpoint events are posted to
+type SplunkEndpoint string
+
+const (
+	// SplunkEventEndpoint posts events with metadata to /services/collector/event
+	SplunkEventEndpoint SplunkEndpoint = "event"
+
+	// SplunkRawEndpoint posts newline separated events to /services/collector/raw
+	SplunkRawEndpoint SplunkEndpoint = "raw"
+)
+
+// SplunkConfig describes the settings of a Splunk HTTP Event Collector client
+type SplunkConfig struct {
+	// URL is the address of the collector, e.g. https://localhost:8088
+	URL string
+	// Token is the HEC token to authenticate with
+	Token string
+	// DisableSecurityCheck deactivates the TLS checks
+	DisableSecurityCheck bool
+	// Endpoint is the endpoint events are posted to
+	Endpoint SplunkEndpoint
+	// Index, SourceType and Source are set on every event if not empty
+	Index      string
+	SourceType string
+	Source     string
+	// Channel identifies the client, a random channel is used if empty
+	Channel string
+	// Ack polls the indexer acknowledgement of every batch
+	Ack bool
+	// AckTimeout is the time after which batches which are not acknowledged count as failed
+	AckTimeout time.Duration
+	// Compression is the content encoding applied to the requests, "gzip" or none
+	Compression string
+	// Batch configures batching and retries
+	Batch BatchConfig
+	// OnBatch is called with the outcome of every batch, if set. With Ack the outcome
+	// is reported once the batch is acknowledged or the ack timeout passed.
+	OnBatch BatchObserver
+}
+
+// SplunkEvent describes an event posted to the HTTP Event Collector
+type SplunkEvent struct {
+	Time time.Time
+	Host string
+	// Event is the JSON encoded event
+	Event json.RawMessage
+}
+
+// SplunkClient posts batches of events to the Splunk HTTP Event Collector
+type SplunkClient struct {
+	cfg     SplunkConfig
+	client  *http.Client
+	batcher *batcher[SplunkEvent]
+
+	mu      sync.Mutex
+	pending map[int64]*splunkBatch
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// splunkBatch is a batch waiting for its indexer acknowledgement
+type splunkBatch struct {
+	result BatchResult
+	start  time.Time
+}
+
+type splunkEventBody struct {
+	Time       float64         `json:"time"`
+	Host       string          `json:"host,omitempty"`
+	Index      string          `json:"index,omitempty"`
+	SourceType string          `json:"sourcetype,omitempty"`
+	Source     string          `json:"source,omitempty"`
+	Event      json.RawMessage `json:"event"`
+}
+
+type splunkResponse struct {
+	Text  string `json:"text"`
+	Code  int    `json:"code"`
+	AckID *int64 `json:"ackId"`
+}
+
+// NewSplunkClient creates a Splunk HTTP Event Collector client
+func NewSplunkClient(cfg SplunkConfig) (*SplunkClient, error) {
+	if _, err := url.Parse(cfg.URL); err != nil {
+		return nil, err
+	}
+
+	switch cfg.Endpoint {
+	case SplunkEventEndpoint, SplunkRawEndpoint:
+	default:
+		return nil, fmt.Errorf("unknown splunk endpoint: %s", cfg.Endpoint)
+	}
+
+	switch cfg.Compression {
+	case "", "none", GzipCompression:
+	default:
+		return nil, fmt.Errorf("unknown splunk compression: %s", cfg.Compression)
+	}
+
+	// Channels are required by the raw endpoint and by indexer acknowledgement
+	if cfg.Channel == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		cfg.Channel = fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+	}
+
+	httpClient, err := newHTTPClient("splunk", cfg.DisableSecurityCheck)
+	if err != nil {
+		return nil, err
+	}
+	httpClient.Timeout = 30 * time.Second
+
+	c := &SplunkClient{
+		cfg:     cfg,
+		client:  httpClient,
+		pending: map[int64]*splunkBatch{},
+		stop:    make(chan struct{}),
+	}
+	if cfg.Ack {
+		c.wg.Add(1)
+		go c.pollAcks()
+	}
+	c.batcher = newBatcher(cfg.Batch, splunkEventSize, c.flush)
+	return c, nil
+}
+
+// Send adds an event written by the given worker to the current batch
+func (c *SplunkClient) Send(worker string, event SplunkEvent) {
+	c.batcher.Add(worker, event)
+}
+
+// Stop posts the events left in the current batch and waits for the pending
+// acknowledgements
+func (c *SplunkClient) Stop() {
+	c.batcher.Stop()
+	close(c.stop)
+	c.wg.Wait()
+}
+
+func (c *SplunkClient) flush(ctx context.Context, events []SplunkEvent, workers map[string]int) {
+	start := time.Now()
+	result := BatchResult{
+		Entries: len(events),
+		Workers: workers,
+	}
+
+	var ackID *int64
+	body, err := c.encode(events)
+	if err == nil && c.cfg.Compression == GzipCompression {
+		body, err = gzipBody(body)
+	}
+	if err == nil {
+		result.Bytes = len(body)
+		err = sendWithRetry(ctx, c.cfg.Batch.Backoff, func() error {
+			var err error
+			ackID, err = c.post(body)
+			return err
+		})
+	}
+
+	if err == nil && c.cfg.Ack {
+		if ackID == nil {
+			err = fmt.Errorf("indexer acknowledgement is not enabled for the token")
+		} else {
+			c.mu.Lock()
+			c.pending[*ackID] = &splunkBatch{result: result, start: start}
+			c.mu.Unlock()
+			return
+		}
+	}
+
+	result.Duration = time.Since(start)
+	result.Err = err
+	c.report(result)
+}
+
+func (c *SplunkClient) encode(events []SplunkEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	for _, e := range events {
+		if c.cfg.Endpoint == SplunkRawEndpoint {
+			buf.Write(e.Event)
+			buf.WriteByte('\n')
+			continue
+		}
+
+		body := splunkEventBody{
+			Time:       float64(e.Time.UnixNano()) / float64(time.Second),
+			Host:       e.Host,
+			Index:      c.cfg.Index,
+			SourceType: c.cfg.SourceType,
+			Source:     c.cfg.Source,
+			Event:      e.Event,
+		}
+		if err := enc.Encode(body); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *SplunkClient) post(body []byte) (*int64, error) {
+	u := strings.TrimSuffix(c.cfg.URL, "/") + "/services/collector/" + string(c.cfg.Endpoint)
+	if c.cfg.Endpoint == SplunkRawEndpoint {
+		params := url.Values{}
+		for name, value := range map[string]string{"index": c.cfg.Index, "sourcetype": c.cfg.SourceType, "source": c.cfg.Source} {
+			if value != "" {
+				params.Set(name, value)
+			}
+		}
+		if len(params) > 0 {
+			u += "?" + params.Encode()
+		}
+	}
+
+	req, err := c.newRequest(u, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.cfg.Compression == GzipCompression {
+		req.Header.Set("Content-Encoding", GzipCompression)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, retryableError{err}
+	}
+	defer res.Body.Close()
+
+	if err := checkResponse(res); err != nil {
+		return nil, err
+	}
+
+	var response splunkResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error decoding splunk response: %s", err)
+	}
+	if response.Code != 0 {
+		return nil, fmt.Errorf("splunk rejected the events: %s (code %d)", response.Text, response.Code)
+	}
+	return response.AckID, nil
+}
+
+// pollAcks queries the acknowledgements of the pending batches until the client stops
+// and no batch is pending anymore
+func (c *SplunkClient) pollAcks() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(splunkAckInterval)
+	defer ticker.Stop()
+
+	stop, stopped := c.stop, false
+	for {
+		select {
+		case <-stop:
+			stop, stopped = nil, true
+		case <-ticker.C:
+		}
+
+		c.checkAcks()
+
+		c.mu.Lock()
+		done := stopped && len(c.pending) == 0
+		c.mu.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+func (c *SplunkClient) checkAcks() {
+	c.mu.Lock()
+	ids := make([]int64, 0, len(c.pending))
+	for id := range c.pending {
+		ids = append(ids, id)
+	}
+	c.mu.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+
+	acks, err := c.queryAcks(ids)
+	if err != nil {
+		log.Errorf("error polling splunk acknowledgements: %s", err)
+	}
+
+	c.mu.Lock()
+	var results []BatchResult
+	for _, id := range ids {
+		batch := c.pending[id]
+		acked := acks[strconv.FormatInt(id, 10)]
+		expired := time.Since(batch.start) > c.cfg.AckTimeout
+		if !acked && !expired {
+			continue
+		}
+
+		delete(c.pending, id)
+		batch.result.Duration = time.Since(batch.start)
+		if !acked {
+			batch.result.Err = fmt.Errorf("batch %d not acknowledged within %s", id, c.cfg.AckTimeout)
+		}
+		results = append(results, batch.result)
+	}
+	c.mu.Unlock()
+
+	for _, result := range results {
+		c.report(result)
+	}
+}
+
+func (c *SplunkClient) queryAcks(ids []int64) (map[string]bool, error) {
+	body, err := json.Marshal(map[string][]int64{"acks": ids})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(strings.TrimSuffix(c.cfg.URL, "/")+"/services/collector/ack", body)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if err := checkResponse(res); err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Acks map[string]bool `json:"acks"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error decoding splunk ack response: %s", err)
+	}
+	return response.Acks, nil
+}
+
+func (c *SplunkClient) newRequest(u string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Splunk "+c.cfg.Token)
+	req.Header.Set("X-Splunk-Request-Channel", c.cfg.Channel)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cluster-logging-load-client")
+	return req, nil
+}
+
+func (c *SplunkClient) report(result BatchResult) {
+	if c.cfg.OnBatch != nil {
+		c.cfg.OnBatch(result)
+	}
+}
+
+func splunkEventSize(e SplunkEvent) int {
+	return len(e.Event)
+}
@@ -0,0 +1,219 @@
+package clients
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/backoff"
+)
+
+// splunkRequest is a request received by the stand-in collector
+type splunkRequest struct {
+	path    string
+	query   string
+	auth    string
+	channel string
+	body    string
+}
+
+// splunkStandIn accepts events like an HTTP Event Collector and acknowledges them if
+// ack is set
+type splunkStandIn struct {
+	t      *testing.T
+	ackIDs bool
+	ack    bool
+
+	mu       sync.Mutex
+	requests []splunkRequest
+	nextID   int64
+}
+
+func (s *splunkStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == GzipCompression {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			s.t.Errorf("error reading gzip body: %s", err)
+			return
+		}
+		body = gz
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		s.t.Errorf("error reading body: %s", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, splunkRequest{
+		path:    r.URL.Path,
+		query:   r.URL.RawQuery,
+		auth:    r.Header.Get("Authorization"),
+		channel: r.Header.Get("X-Splunk-Request-Channel"),
+		body:    string(data),
+	})
+
+	if r.URL.Path == "/services/collector/ack" {
+		var req struct {
+			Acks []int64 `json:"acks"`
+		}
+		if err := json.Unmarshal(data, &req); err != nil {
+			s.t.Errorf("error decoding ack request: %s", err)
+		}
+		acks := map[string]bool{}
+		for _, id := range req.Acks {
+			acks[fmt.Sprint(id)] = s.ack
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"acks": acks})
+		return
+	}
+
+	if !s.ackIDs {
+		fmt.Fprint(w, `{"text":"Success","code":0}`)
+		return
+	}
+	fmt.Fprintf(w, `{"text":"Success","code":0,"ackId":%d}`, s.nextID)
+	s.nextID++
+}
+
+func TestSplunkClient(t *testing.T) {
+	at := time.Unix(1714564800, 500000000)
+	events := []SplunkEvent{
+		{Time: at, Host: "host-1", Event: json.RawMessage(`{"message":"first"}`)},
+		{Time: at, Host: "host-2", Event: json.RawMessage(`{"message":"second"}`)},
+	}
+
+	tests := []struct {
+		name        string
+		endpoint    SplunkEndpoint
+		compression string
+		ack         bool
+		ackIDs      bool
+		acked       bool
+		wantErr     string
+		// wantRequests are the paths and queries of the requests received, wantBody
+		// the body of the first one
+		wantRequests []string
+		wantBody     string
+	}{
+		{
+			name:         "event",
+			endpoint:     SplunkEventEndpoint,
+			compression:  GzipCompression,
+			wantRequests: []string{"/services/collector/event"},
+			wantBody: `{"time":1714564800.5,"host":"host-1","index":"main","sourcetype":"_json","source":"load","event":{"message":"first"}}` + "\n" +
+				`{"time":1714564800.5,"host":"host-2","index":"main","sourcetype":"_json","source":"load","event":{"message":"second"}}` + "\n",
+		},
+		{
+			name:         "raw",
+			endpoint:     SplunkRawEndpoint,
+			wantRequests: []string{"/services/collector/raw?index=main&source=load&sourcetype=_json"},
+			wantBody:     `{"message":"first"}` + "\n" + `{"message":"second"}` + "\n",
+		},
+		{
+			name:         "acknowledged",
+			endpoint:     SplunkEventEndpoint,
+			ack:          true,
+			ackIDs:       true,
+			acked:        true,
+			wantRequests: []string{"/services/collector/event", "/services/collector/ack"},
+		},
+		{
+			name:     "ack timeout",
+			endpoint: SplunkRawEndpoint,
+			ack:      true,
+			ackIDs:   true,
+			wantErr:  "batch 0 not acknowledged within 100ms",
+			// Stopping polls at once, the batch expires by the next poll
+			wantRequests: []string{
+				"/services/collector/raw?index=main&source=load&sourcetype=_json",
+				"/services/collector/ack",
+				"/services/collector/ack",
+			},
+		},
+		{
+			name:         "ack disabled for the token",
+			endpoint:     SplunkEventEndpoint,
+			ack:          true,
+			wantErr:      "indexer acknowledgement is not enabled for the token",
+			wantRequests: []string{"/services/collector/event"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			standIn := &splunkStandIn{t: t, ackIDs: tt.ackIDs, ack: tt.acked}
+			server := httptest.NewServer(standIn)
+			defer server.Close()
+
+			results := make(chan BatchResult, 1)
+			client, err := NewSplunkClient(SplunkConfig{
+				URL:                  server.URL,
+				Token:                "token",
+				DisableSecurityCheck: true,
+				Endpoint:             tt.endpoint,
+				Index:                "main",
+				SourceType:           "_json",
+				Source:               "load",
+				Channel:              "channel",
+				Ack:                  tt.ack,
+				AckTimeout:           100 * time.Millisecond,
+				Compression:          tt.compression,
+				Batch: BatchConfig{
+					Size:    1 << 20,
+					Wait:    time.Hour,
+					Backoff: backoff.Config{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, MaxRetries: 2},
+				},
+				OnBatch: func(res BatchResult) { results <- res },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range events {
+				client.Send("0", e)
+			}
+			// Stopping posts the batch and waits for its acknowledgement
+			client.Stop()
+
+			res := <-results
+			if tt.wantErr == "" && res.Err != nil || tt.wantErr != "" && (res.Err == nil || res.Err.Error() != tt.wantErr) {
+				t.Errorf("got error %v, want %q", res.Err, tt.wantErr)
+			}
+			if res.Entries != len(events) || res.Workers["0"] != len(events) {
+				t.Errorf("got %d entries of workers %v, want %d of worker 0", res.Entries, res.Workers, len(events))
+			}
+
+			standIn.mu.Lock()
+			defer standIn.mu.Unlock()
+			var requests []string
+			for _, r := range standIn.requests {
+				if r.auth != "Splunk token" || r.channel != "channel" {
+					t.Errorf("got authorization %q and channel %q, want the token and channel", r.auth, r.channel)
+				}
+				path := r.path
+				if r.query != "" {
+					path += "?" + r.query
+				}
+				requests = append(requests, path)
+			}
+			if strings.Join(requests, " ") != strings.Join(tt.wantRequests, " ") {
+				t.Fatalf("got requests %v, want %v", requests, tt.wantRequests)
+			}
+			if tt.wantBody != "" && standIn.requests[0].body != tt.wantBody {
+				t.Errorf("got body\n%s\nwant\n%s", standIn.requests[0].body, tt.wantBody)
+			}
+			if tt.ack && tt.ackIDs && standIn.requests[1].body != `{"acks":[0]}` {
+				t.Errorf("got ack request %s, want the ack id of the batch", standIn.requests[1].body)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package clients
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyslogFormat describes the message format of syslog messages
+type SyslogFormat string
+
+const (
+	// RFC5424Format formats messages as described in RFC 5424
+	RFC5424Format SyslogFormat = "rfc5424"
+
+	// RFC3164Format formats messages in the BSD style described in RFC 3164
+	RFC3164Format SyslogFormat = "rfc3164"
+)
+
+// SyslogFraming describes how messages are delimited on stream transports
+type SyslogFraming string
+
+const (
+	// OctetCountingFraming prefixes every message with its length
+	OctetCountingFraming SyslogFraming = "octet-counting"
+
+	// NonTransparentFraming terminates every message with a newline, newlines within
+	// messages are escaped
+	NonTransparentFraming SyslogFraming = "non-transparent"
+)
+
+// TLSConfig describes the files used to establish TLS connections
+type TLSConfig struct {
+	// CAFile is the CA bundle to verify the server with, the system roots are used if empty
+	CAFile string
+	// CertFile and KeyFile are the client certificate and key, if any
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables the verification of the server certificate
+	InsecureSkipVerify bool
+}
+
+// SyslogConfig describes the settings of a syslog client
+type SyslogConfig struct {
+	// URL is the address of the server, e.g. udp://localhost:514, tcp://localhost:514
+	// or tls://localhost:6514
+	URL string
+	// Format is the message format
+	Format SyslogFormat
+	// Framing delimits messages on TCP and TLS connections
+	Framing SyslogFraming
+	// TLS configures TLS connections
+	TLS TLSConfig
+}
+
+// SyslogMessage describes a single syslog message
+type SyslogMessage struct {
+	Facility  int
+	Severity  int
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	Message   string
+}
+
+// SyslogClient writes syslog messages over UDP, TCP or TLS. Broken connections are
+// reestablished on the next write.
+type SyslogClient struct {
+	cfg     SyslogConfig
+	network string
+	address string
+	tls     *tls.Config
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogClient creates a syslog client and connects to the server
+func NewSyslogClient(cfg SyslogConfig) (*SyslogClient, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Format {
+	case RFC5424Format, RFC3164Format:
+	default:
+		return nil, fmt.Errorf("unknown syslog format: %s", cfg.Format)
+	}
+
+	switch cfg.Framing {
+	case OctetCountingFraming, NonTransparentFraming:
+	default:
+		return nil, fmt.Errorf("unknown syslog framing: %s", cfg.Framing)
+	}
+
+	c := &SyslogClient{
+		cfg:     cfg,
+		network: u.Scheme,
+		address: u.Host,
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+	case "tls":
+		c.network = "tcp"
+		c.tls, err = newTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown syslog network %q: expected udp, tcp or tls", u.Scheme)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Send writes a message to the server
+func (c *SyslogClient) Send(msg SyslogMessage) error {
+	data := c.frame(c.format(msg))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.conn.Write(data); err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Close closes the connection to the server
+func (c *SyslogClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *SyslogClient) connect() error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	var err error
+	if c.tls != nil {
+		c.conn, err = tls.DialWithDialer(dialer, c.network, c.address, c.tls)
+	} else {
+		c.conn, err = dialer.Dial(c.network, c.address)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to connect to syslog server %s: %s", c.cfg.URL, err)
+	}
+	return nil
+}
+
+func (c *SyslogClient) format(msg SyslogMessage) string {
+	pri := msg.Facility*8 + msg.Severity
+	text := strings.TrimRight(msg.Message, "\n")
+
+	if c.cfg.Format == RFC3164Format {
+		return fmt.Sprintf("<%d>%s %s %s: %s", pri, msg.Timestamp.Format(time.Stamp),
+			syslogField(msg.Hostname, 255), syslogField(msg.AppName, 32), text)
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s %d - - %s", pri, msg.Timestamp.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogField(msg.Hostname, 255), syslogField(msg.AppName, 48), os.Getpid(), text)
+}
+
+func (c *SyslogClient) frame(msg string) []byte {
+	switch {
+	case c.network == "udp":
+		return []byte(msg)
+	case c.cfg.Framing == OctetCountingFraming:
+		return []byte(fmt.Sprintf("%d %s", len(msg), msg))
+	default:
+		// Newlines of multi-line logs would split the message
+		return []byte(strings.ReplaceAll(msg, "\n", `\n`) + "\n")
+	}
+}
+
+// syslogField truncates a header field to its maximum length and replaces an empty
+// value with the nil value
+func syslogField(value string, max int) string {
+	if value == "" {
+		return "-"
+	}
+	if len(value) > max {
+		return value[:max]
+	}
+	return value
+}
+
+func newTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		ca, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA cert: %s", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,85 @@
+package clients
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogClientFraming(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	message := SyslogMessage{
+		Facility:  1,
+		Severity:  3,
+		Timestamp: at,
+		Hostname:  "host",
+		AppName:   "app",
+		Message:   "panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n",
+	}
+	header := "<11>May  1 12:00:00 host app: "
+	body := header + "panic: boom\n\ngoroutine 1 [running]:\nmain.main()"
+
+	tests := []struct {
+		framing SyslogFraming
+		want    string
+	}{
+		{
+			framing: OctetCountingFraming,
+			want:    fmt.Sprintf("%d %s", len(body), body),
+		},
+		{
+			framing: NonTransparentFraming,
+			want:    header + `panic: boom\n\ngoroutine 1 [running]:\nmain.main()` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.framing), func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+
+			received := make(chan string, 1)
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				data, _ := io.ReadAll(bufio.NewReader(conn))
+				received <- string(data)
+			}()
+
+			client, err := NewSyslogClient(SyslogConfig{
+				URL:     "tcp://" + listener.Addr().String(),
+				Format:  RFC3164Format,
+				Framing: tt.framing,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := client.Send(message); err != nil {
+				t.Fatal(err)
+			}
+			client.Close()
+
+			select {
+			case got := <-received:
+				if got != tt.want {
+					t.Errorf("got %q, want %q", got, tt.want)
+				}
+				if tt.framing == NonTransparentFraming && strings.Count(got, "\n") != 1 {
+					t.Errorf("got %d messages, want 1", strings.Count(got, "\n"))
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("message not received")
+			}
+		})
+	}
+}
@@ -8,3 +8,9 @@ import (
 type Component interface {
 	Start(ctx context.Context, wg *sync.WaitGroup, errCh chan<- error)
 }
+
+// Bounded is implemented by components which may finish on their own before the
+// context is done, e.g. once a limit is reached. All components are shut down then.
+type Bounded interface {
+	Done() <-chan struct{}
+}
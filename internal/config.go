@@ -4,16 +4,89 @@ type Options struct {
 	Command              string
 	Destination          string
 	OutputFile           string
+	PodsDirectory        string
+	PodsNamespaces       int
+	PodsPerNamespace     int
+	PodsContainers       int
+	PodsChurnInterval    string
+	PodsRestartInterval  string
 	ClientURL            string
 	DisableSecurityCheck bool
-	LogsPerSecond        int
+	LogsPerSecond        float64
+	PacingJitter         float64
+	Workers              int
+	LoadProfile          string
+	LoadProfileFile      string
 	LogType              string
 	LogFormat            string
 	LabelType            string
 	SyntheticPayloadSize int
+	MultilineFrameDepth  int
+	CRIOPartialSize      int
 	UseRandomHostname    bool
 	Tenant               string
+	BatchSize            int
+	BatchWait            string
+	MaxRetries           int
+	MinBackoff           string
+	MaxBackoff           string
+	Compression          string
+	LokiEncoding         string
+	OTLPProtocol         string
+	ForwardMode          string
+	ForwardRequireAck    bool
+	ForwardAckTimeout    string
+	ForwardSharedKey     string
+	KafkaTopic           string
+	KafkaPartitioner     string
+	KafkaAcks            string
+	SplunkToken          string
+	SplunkEndpoint       string
+	SplunkIndex          string
+	SplunkSourceType     string
+	SplunkSource         string
+	SplunkChannel        string
+	SplunkAck            bool
+	SplunkAckTimeout     string
+	HTTPMethod           string
+	HTTPHeaders          []string
+	HTTPEncoding         string
+	HTTPRetryStatusCodes string
+	SyslogFormat         string
+	SyslogFraming        string
+	SyslogFacility       string
+	TLSCAFile            string
+	TLSCertFile          string
+	TLSKeyFile           string
+	LokiMetadata         string
+	ESBackend            string
+	ESUsername           string
+	ESPassword           string
+	ESAPIKey             string
+	ESIndex              string
+	ESIndexStrategy      string
+	ESRecreateIndex      bool
+	ESTemplateFile       string
+	ESMappingsFile       string
+	ESMaxFailureRatio    float64
+	MaxLines             int64
+	MaxBytes             int64
+	Duration             string
 	QueriesPerMinute     int
+	QueryWorkers         int
+	QueryHostnames       []string
 	Query                string
+	QueryFile            string
+	QueryOrder           string
+	QueryKind            string
+	QueryLimit           int
+	QueryDirection       string
+	QueryValidate        bool
+	QueryFailOnInvalid   bool
 	QueryRange           string
+	RunID                string
+	RoundtripDestination string
+	RoundtripURL         string
+	RoundtripInterval    string
+	RoundtripLookback    string
 }
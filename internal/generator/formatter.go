@@ -3,6 +3,9 @@ package generator
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -24,20 +27,33 @@ const (
 	RawFormat Format = "raw"
 )
 
-func FormatLog(style Format, hash string, messageCount int64, payload string) (string, error) {
+// FormatLog formats the payload in the given style. CRI-O formatted lines longer than
+// the partial size, if set, are split into partial records like container runtimes do.
+// The default and raw styles intentionally keep the newlines of multi-line payloads
+// such as stack traces, for collectors to join the lines again. The csv and json
+// styles escape them into a single line, the crio style writes a record per line.
+func FormatLog(style Format, hash string, messageCount int64, payload string, partialSize int) (string, error) {
 	now := time.Now().Format(time.RFC3339Nano)
 
 	switch style {
 	case CRIOFormat:
-		return fmt.Sprintf("%s stdout F goloader seq - %s - %010d - %s\n", now, hash, messageCount, payload), nil
+		// The container runtime writes every physical line of a multi-line log as a
+		// record of its own
+		lines := strings.Split(payload, "\n")
+		lines[0] = sequenceHeader(hash, messageCount) + lines[0]
+		var b strings.Builder
+		for _, line := range lines {
+			writeCRIORecords(&b, now, line, partialSize)
+		}
+		return b.String(), nil
 	case CSVFormat:
-		return fmt.Sprintf("ts=%s stream=%s host=%s level=%s count=%d msg=%q\n", now, randStream(), hash, randLevel(), messageCount, payload), nil
+		return fmt.Sprintf("ts=%s stream=%s host=%s level=%s count=%d msg=%q\n", now, randStream(), hash, RandomLevel(), messageCount, payload), nil
 	case JSONFormat:
 		message := map[string]interface{}{
 			"ts":     now,
 			"stream": randStream(),
 			"host":   hash,
-			"lvl":    randLevel(),
+			"lvl":    RandomLevel(),
 			"count":  messageCount,
 			"msg":    payload,
 		}
@@ -49,6 +65,63 @@ func FormatLog(style Format, hash string, messageCount int64, payload string) (s
 	case RawFormat:
 		return fmt.Sprintln(payload), nil
 	default:
-		return fmt.Sprintf("goloader seq - %s - %010d - %s\n", hash, messageCount, payload), nil
+		return fmt.Sprintf("%s%s\n", sequenceHeader(hash, messageCount), payload), nil
+	}
+}
+
+// sequenceHeader returns the header carrying the hostname and the message count of
+// default and crio formatted logs
+func sequenceHeader(hash string, messageCount int64) string {
+	return fmt.Sprintf("goloader seq - %s - %010d - ", hash, messageCount)
+}
+
+// writeCRIORecords writes a line as full record or, if it is longer than the partial
+// size, as partial records followed by a full record with the rest of the line
+func writeCRIORecords(b *strings.Builder, now, line string, partialSize int) {
+	for partialSize > 0 && len(line) > partialSize {
+		fmt.Fprintf(b, "%s stdout P %s\n", now, line[:partialSize])
+		line = line[partialSize:]
+	}
+	fmt.Fprintf(b, "%s stdout F %s\n", now, line)
+}
+
+var (
+	defaultLogPattern = regexp.MustCompile(`goloader seq - (\S+) - (\d+) - `)
+	csvLogPattern     = regexp.MustCompile(`host=(\S+) level=\S+ count=(\d+) `)
+	jsonLogPattern    = regexp.MustCompile(`"count":(\d+),"host":"([^"]*)"`)
+)
+
+// ParseLog extracts the hostname and message count written by FormatLog from a log line.
+// The line may be embedded in other content, e.g. when a collector wraps it in an envelope.
+func ParseLog(style Format, line string) (string, int64, error) {
+	var host, count string
+
+	switch style {
+	case CSVFormat:
+		m := csvLogPattern.FindStringSubmatch(line)
+		if m == nil {
+			return "", 0, fmt.Errorf("no csv formatted log found in line: %q", line)
+		}
+		host, count = m[1], m[2]
+	case JSONFormat:
+		m := jsonLogPattern.FindStringSubmatch(strings.ReplaceAll(line, `\"`, `"`))
+		if m == nil {
+			return "", 0, fmt.Errorf("no json formatted log found in line: %q", line)
+		}
+		host, count = m[2], m[1]
+	case RawFormat:
+		return "", 0, fmt.Errorf("raw formatted logs carry no message count")
+	default:
+		m := defaultLogPattern.FindStringSubmatch(line)
+		if m == nil {
+			return "", 0, fmt.Errorf("no %s formatted log found in line: %q", style, line)
+		}
+		host, count = m[1], m[2]
+	}
+
+	messageCount, err := strconv.ParseInt(count, 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid message count %q: %s", count, err)
 	}
+	return host, messageCount, nil
 }
@@ -0,0 +1,198 @@
+package generator
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFormatLogMultiline(t *testing.T) {
+	criORecord := regexp.MustCompile(`^\S+ stdout F `)
+	tests := []struct {
+		style Format
+		// lines returns the number of lines expected for a payload of the given number of lines
+		lines func(payloadLines int) int
+		// check is run on every line written
+		check func(line string) bool
+	}{
+		{style: "default", lines: func(n int) int { return n }},
+		{style: RawFormat, lines: func(n int) int { return n }},
+		{style: CSVFormat, lines: func(int) int { return 1 }, check: func(line string) bool { return strings.Contains(line, `\n`) }},
+		{style: JSONFormat, lines: func(int) int { return 1 }, check: func(line string) bool { return strings.Contains(line, `\n`) }},
+		{style: CRIOFormat, lines: func(n int) int { return n }, check: criORecord.MatchString},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.style), func(t *testing.T) {
+			payload, err := RandomLog(MultilineLogType, 0, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			payloadLines := strings.Count(payload, "\n") + 1
+			if payloadLines < 2 {
+				t.Fatalf("got a single line payload %q", payload)
+			}
+
+			formatted, err := FormatLog(tt.style, "host", 7, payload, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(formatted, "\n") {
+				t.Errorf("got log %q without trailing newline", formatted)
+			}
+			lines := strings.Split(strings.TrimSuffix(formatted, "\n"), "\n")
+			if len(lines) != tt.lines(payloadLines) {
+				t.Errorf("got %d lines for a payload of %d lines: %q", len(lines), payloadLines, formatted)
+			}
+			for _, line := range lines {
+				if tt.check != nil && !tt.check(line) {
+					t.Errorf("got unexpected line %q", line)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteCRIORecords(t *testing.T) {
+	const now = "2024-05-01T12:00:00Z"
+	tests := []struct {
+		name        string
+		line        string
+		partialSize int
+		want        []string
+	}{
+		{name: "full records only", line: "abcdefgh", want: []string{"F abcdefgh"}},
+		{name: "shorter than partial size", line: "abc", partialSize: 4, want: []string{"F abc"}},
+		{name: "partial size", line: "abcd", partialSize: 4, want: []string{"F abcd"}},
+		{name: "split", line: "abcdefghij", partialSize: 4, want: []string{"P abcd", "P efgh", "F ij"}},
+		{name: "split evenly", line: "abcdefgh", partialSize: 4, want: []string{"P abcd", "F efgh"}},
+		{name: "empty line", line: "", partialSize: 4, want: []string{"F "}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			writeCRIORecords(&b, now, tt.line, tt.partialSize)
+
+			var want strings.Builder
+			for _, record := range tt.want {
+				want.WriteString(now + " stdout " + record + "\n")
+			}
+			if b.String() != want.String() {
+				t.Errorf("got records %q, want %q", b.String(), want.String())
+			}
+		})
+	}
+}
+
+func TestFormatLogCRIOPartial(t *testing.T) {
+	header := sequenceHeader("host", 7)
+	formatted, err := FormatLog(CRIOFormat, "host", 7, strings.Repeat("x", 100)+"\nshort", len(header)+10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tags []string
+	records := strings.Split(strings.TrimSuffix(formatted, "\n"), "\n")
+	for _, record := range records {
+		fields := strings.SplitN(record, " ", 4)
+		if len(fields) != 4 {
+			t.Fatalf("got malformed record %q", record)
+		}
+		tags = append(tags, fields[2])
+	}
+	// The header and 10 bytes, the remaining 90 bytes in two records, then the second line
+	if got := strings.Join(tags, ""); got != "PPFF" {
+		t.Errorf("got records %s, want PPFF: %q", got, formatted)
+	}
+	if host, count, err := ParseLog(CRIOFormat, records[0]); err != nil || host != "host" || count != 7 {
+		t.Errorf("got host %q, count %d, error %v from the first record", host, count, err)
+	}
+}
+
+func TestValidatePartialSize(t *testing.T) {
+	hostnames, err := Hostnames(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := len(sequenceHeader(hostnames[11], 0))
+
+	tests := []struct {
+		name           string
+		partialSize    int
+		workers        int
+		randomHostname bool
+		wantErr        bool
+	}{
+		{name: "header fits", partialSize: header, workers: 12},
+		{name: "header of the last worker cut", partialSize: header - 1, workers: 12, wantErr: true},
+		{name: "single worker", partialSize: header - 3, workers: 1},
+		{name: "random hostname cut", partialSize: header, workers: 12, randomHostname: true, wantErr: true},
+		{name: "random hostname fits", partialSize: header + 33, workers: 12, randomHostname: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePartialSize(tt.partialSize, tt.workers, tt.randomHostname)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseLog(t *testing.T) {
+	tests := []struct {
+		name      string
+		style     Format
+		line      string
+		wantHost  string
+		wantCount int64
+		wantErr   bool
+	}{
+		{name: "default", style: "default", line: "goloader seq - host-0 - 0000000042 - payload", wantHost: "host-0", wantCount: 42},
+		{name: "crio", style: CRIOFormat, line: "2024-05-01T12:00:00Z stdout F goloader seq - host - 0000000001 - payload", wantHost: "host", wantCount: 1},
+		{name: "csv", style: CSVFormat, line: `ts=2024-05-01T12:00:00Z stream=stdout host=host level=info count=7 msg="payload"`, wantHost: "host", wantCount: 7},
+		{name: "json", style: JSONFormat, line: `{"count":9,"host":"host","lvl":"info","msg":"payload"}`, wantHost: "host", wantCount: 9},
+		{
+			name:      "json in an envelope",
+			style:     JSONFormat,
+			line:      `{"message":"{\"count\":9,\"host\":\"host\",\"msg\":\"payload\"}","kubernetes":{}}`,
+			wantHost:  "host",
+			wantCount: 9,
+		},
+		{name: "default in an envelope", style: "default", line: `{"message":"goloader seq - host - 0000000003 - payload"}`, wantHost: "host", wantCount: 3},
+		{name: "raw", style: RawFormat, line: "payload", wantErr: true},
+		{name: "default without header", style: "default", line: "payload", wantErr: true},
+		{name: "csv without count", style: CSVFormat, line: "host=host level=info msg=payload", wantErr: true},
+		{name: "json of another style", style: JSONFormat, line: "goloader seq - host - 0000000003 - payload", wantErr: true},
+		{name: "count out of range", style: "default", line: "goloader seq - host - 99999999999999999999 - payload", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, count, err := ParseLog(tt.style, tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if host != tt.wantHost || count != tt.wantCount {
+				t.Errorf("got host %q and count %d, want %q and %d", host, count, tt.wantHost, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestParseLogOfFormatLog(t *testing.T) {
+	for _, style := range []Format{"default", CRIOFormat, CSVFormat, JSONFormat} {
+		t.Run(string(style), func(t *testing.T) {
+			formatted, err := FormatLog(style, "host-3", 123, `payload with "quotes"`, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			host, count, err := ParseLog(style, formatted)
+			if err != nil || host != "host-3" || count != 123 {
+				t.Errorf("got host %q, count %d, error %v from %q", host, count, err, formatted)
+			}
+		})
+	}
+}
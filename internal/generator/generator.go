@@ -2,22 +2,34 @@ package generator
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/ViaQ/cluster-logging-load-client/internal/clients"
 
-	"github.com/elastic/go-elasticsearch/v6"
 	"github.com/elastic/go-elasticsearch/v6/esutil"
-	promtail "github.com/grafana/loki/clients/pkg/promtail/client"
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/loki/pkg/logproto"
 	log "github.com/sirupsen/logrus"
 )
 
+// unknownWorker labels errors of logs which can not be traced back to the worker which
+// wrote them
+const unknownWorker = "unknown"
+
+// minFailureRatioDocuments is the number of documents done before the failure ratio
+// is checked while running, a few early failures would fail the run otherwise
+const minFailureRatioDocuments = 1000
+
 // ClientType describes the type of client to use for querying logs
 type ClientType string
 
@@ -25,11 +37,32 @@ const (
 	// FileClientType uses a file to write logs to
 	FileClientType ClientType = "file"
 
-	// LokiClientType uses a Promtail client to forward logs
+	// LokiClientType uses a Loki push client to forward logs
 	LokiClientType ClientType = "loki"
 
+	// OTLPClientType uses an OpenTelemetry protocol client to forward logs
+	OTLPClientType ClientType = "otlp"
+
+	// SyslogClientType uses a syslog client to forward logs
+	SyslogClientType ClientType = "syslog"
+
+	// ForwardClientType uses a Fluent forward protocol client to forward logs
+	ForwardClientType ClientType = "forward"
+
+	// KafkaClientType uses a Kafka producer to forward logs
+	KafkaClientType ClientType = "kafka"
+
+	// SplunkClientType uses a Splunk HTTP Event Collector client to forward logs
+	SplunkClientType ClientType = "splunk"
+
+	// HTTPClientType uses a generic HTTP client to forward logs
+	HTTPClientType ClientType = "http"
+
 	// ElasticsearchClientType uses an Elasticsearch client to forward logs
 	ElasticsearchClientType ClientType = "elasticsearch"
+
+	// PodsClientType writes logs into the pod log directories of a simulated node
+	PodsClientType ClientType = "pods"
 )
 
 // Options describes the settings that can be modified for the log generator
@@ -40,44 +73,307 @@ type Options struct {
 	ClientURL string
 	// FileName is the name of the file to create and write to
 	FileName string
+	// PodsDirectory is the root of the pod log directories written to
+	PodsDirectory string
+	// PodsNamespaces, PodsPerNamespace and PodsContainers are the number of namespaces,
+	// of pods per namespace and of containers per pod simulated
+	PodsNamespaces   int
+	PodsPerNamespace int
+	PodsContainers   int
+	// PodsChurnInterval is the interval at which a pod is replaced, 0 disables churn
+	PodsChurnInterval string
+	// PodsRestartInterval is the interval at which a container restarts, 0 disables restarts
+	PodsRestartInterval string
 	// Tenant is identification to use for Loki
 	Tenant string
 	// DisableSecurityCheck deactivates the TLS checks
 	DisableSecurityCheck bool
 	// LogsPerSecond is the number of logs to write per second
-	LogsPerSecond int
+	LogsPerSecond float64
+	// PacingJitter is the fraction by which the interval between two logs varies randomly
+	PacingJitter float64
+	// LoadProfile is a specification of phases varying the rate over time
+	LoadProfile string
+	// LoadProfileFile is a file defining phases varying the rate over time
+	LoadProfileFile string
 
 	LogType              string
 	LogFormat            string
 	LabelType            string
 	SyntheticPayloadSize int
 	UseRandomHostname    bool
+	// MultilineFrameDepth is the number of frames of the stack traces of multiline logs
+	MultilineFrameDepth int
+	// CRIOPartialSize is the size at which CRI-O formatted lines are split into partial
+	// records, 0 writes full records only. The sequence header must fit into the first
+	// record for the lines to be traced without joining the records.
+	CRIOPartialSize int
+
+	// BatchSize is the number of bytes after which a batch is sent
+	BatchSize int
+	// BatchWait is the maximum time a log waits in a batch before it is sent
+	BatchWait string
+	// MaxRetries is the number of attempts to send a batch, 0 retries forever
+	MaxRetries int
+	// MinBackoff and MaxBackoff bound the delay between two attempts to send a batch
+	MinBackoff string
+	MaxBackoff string
+	// Compression is the content encoding applied to request bodies
+	Compression string
+	// LokiEncoding is the encoding of Loki push requests
+	LokiEncoding string
+	// OTLPProtocol is the transport and encoding of OTLP export requests
+	OTLPProtocol string
+	// SyslogFormat is the message format of syslog messages
+	SyslogFormat string
+	// SyslogFraming delimits syslog messages on TCP and TLS connections
+	SyslogFraming string
+	// SyslogFacility is the facility of syslog messages
+	SyslogFacility string
+	// TLSCAFile, TLSCertFile and TLSKeyFile configure TLS connections
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+	// ForwardMode is the way events are packed into forward protocol messages
+	ForwardMode string
+	// ForwardRequireAck requests an acknowledgement of every forward protocol message
+	ForwardRequireAck bool
+	// ForwardAckTimeout is the time to wait for acknowledgements before messages are resent
+	ForwardAckTimeout string
+	// ForwardSharedKey authenticates the client in the forward protocol handshake
+	ForwardSharedKey string
+	// KafkaTopic is the topic logs are produced to
+	KafkaTopic string
+	// KafkaPartitioner distributes logs over the partitions of the topic
+	KafkaPartitioner string
+	// KafkaAcks is the number of acknowledgements required for a log
+	KafkaAcks string
+	// SplunkToken is the HTTP Event Collector token to authenticate with
+	SplunkToken string
+	// SplunkEndpoint is the HTTP Event Collector endpoint logs are posted to
+	SplunkEndpoint string
+	// SplunkIndex, SplunkSourceType and SplunkSource are set on every event
+	SplunkIndex      string
+	SplunkSourceType string
+	SplunkSource     string
+	// SplunkChannel identifies the client, a random channel is used if empty
+	SplunkChannel string
+	// SplunkAck polls the indexer acknowledgement of every batch
+	SplunkAck bool
+	// SplunkAckTimeout is the time after which batches which are not acknowledged count as failed
+	SplunkAckTimeout string
+	// HTTPMethod is the method of requests sent by the generic HTTP client
+	HTTPMethod string
+	// HTTPHeaders are "Name: value" headers added to every request of the generic HTTP client
+	HTTPHeaders []string
+	// HTTPEncoding is the encoding of request bodies sent by the generic HTTP client
+	HTTPEncoding string
+	// HTTPRetryStatusCodes is a comma separated list of status codes on which requests are retried
+	HTTPRetryStatusCodes string
+	// LokiStructuredMetadata is a comma separated list of key=value pairs attached to every log
+	LokiStructuredMetadata string
+	// ElasticsearchBackend is the backend of the Elasticsearch cluster, detected if "auto"
+	ElasticsearchBackend string
+	// ElasticsearchUsername and ElasticsearchPassword are used for basic authentication
+	ElasticsearchUsername string
+	ElasticsearchPassword string
+	// ElasticsearchAPIKey is the base64 encoded API key to authenticate with
+	ElasticsearchAPIKey string
+	// ElasticsearchIndex is the index, index prefix, data stream or alias logs are written to
+	ElasticsearchIndex string
+	// ElasticsearchIndexStrategy is the strategy naming the indices logs are written to
+	ElasticsearchIndexStrategy string
+	// ElasticsearchRecreateIndex deletes the indices matching the strategy on start
+	ElasticsearchRecreateIndex bool
+	// ElasticsearchTemplateFile is a JSON index template applied on start
+	ElasticsearchTemplateFile string
+	// ElasticsearchMappingsFile is the JSON body of the indices created on start
+	ElasticsearchMappingsFile string
+	// ElasticsearchMaxFailureRatio fails the run once the fraction of documents
+	// Elasticsearch failed to write exceeds it
+	ElasticsearchMaxFailureRatio float64
+
+	// Workers is the number of goroutines the rate is split across
+	Workers int
+
+	// MaxLines stops the log generator after writing the number of lines, if set
+	MaxLines int64
+	// MaxBytes stops the log generator after writing the number of bytes, if set
+	MaxBytes int64
+
+	// RunID is prepended to every log line to identify the lines of a single run
+	RunID string
+	// Observer is notified about every log line written, if set
+	Observer Observer
+}
+
+// Observer describes an object which is notified about every log line written. Lines
+// are observed before they are written, as they may be read back before the write
+// returns. A line failing to be written is observed again when its write is retried.
+type Observer interface {
+	// Observe records a line about to be written
+	Observe(host string, messageCount int64, at time.Time)
+	// Forget drops an observed line which was never written
+	Forget(host string, messageCount int64)
+}
+
+// Summary describes the outcome of a log generator run
+type Summary struct {
+	Destination     string           `json:"destination"`
+	Lines           int64            `json:"lines"`
+	Bytes           int64            `json:"bytes"`
+	DurationSeconds float64          `json:"duration_seconds"`
+	AchievedRate    float64          `json:"achieved_rate"`
+	Errors          map[string]int64 `json:"errors"`
 }
 
 // LogGenerator describes an object which generates logs
 type LogGenerator struct {
-	elasticsearchClient      *elasticsearch.Client
-	elasticsearchBulkIndexer esutil.BulkIndexer
+	elasticsearchClient      *clients.ElasticsearchClient
+	elasticsearchBulkIndexer *clients.ElasticsearchBulkIndexer
+	elasticsearchIndex       clients.ElasticsearchIndexConfig
 	file                     *os.File
-	promtailClient           promtail.Client
-	rate                     int
-	writeToDestination       func(string, string, LabelSetOptions) error
+	podLogClient             *clients.PodLogClient
+	lokiClient               *clients.LokiClient
+	structuredMetadata       []logproto.LabelAdapter
+	otlpClient               *clients.OTLPClient
+	syslogClient             *clients.SyslogClient
+	syslogFacility           int
+	forwardClient            *clients.ForwardClient
+	kafkaClient              *clients.KafkaClient
+	splunkClient             *clients.SplunkClient
+	httpClient               *clients.HTTPSinkClient
+	workers                  []*worker
+	writeToDestination       func(string, string, string, LabelSetOptions) error
 	deferClose               func()
-	logCount                 prometheus.Counter
+	done                     chan struct{}
+	errCh                    chan<- error
+	failOnce                 sync.Once
+	destination              string
+	reserved                 atomic.Int64
+	lines                    atomic.Int64
+	bytes                    atomic.Int64
+	errors                   atomic.Int64
+	summary                  Summary
+	logCount                 *prometheus.CounterVec
+	byteCount                *prometheus.CounterVec
+	errorCount               *prometheus.CounterVec
+	batchCount               *prometheus.CounterVec
+	batchDuration            *prometheus.HistogramVec
+	achievedRate             *prometheus.GaugeVec
+	scheduleLag              *prometheus.HistogramVec
+	ackLatency               prometheus.Histogram
+	produceLatency           prometheus.Histogram
+	responseCount            *prometheus.CounterVec
+	failureCount             *prometheus.CounterVec
 	opts                     Options
 }
 
 func NewLogGenerator(opts Options, registry *prometheus.Registry) (*LogGenerator, error) {
+	profile, err := NewProfile(opts.LoadProfile, opts.LoadProfileFile, opts.LogsPerSecond)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Workers < 1 {
+		return nil, fmt.Errorf("invalid number of workers %d: must be at least 1", opts.Workers)
+	}
+	if opts.CRIOPartialSize < 0 {
+		return nil, fmt.Errorf("invalid crio partial size %d: must not be negative", opts.CRIOPartialSize)
+	}
+	if opts.CRIOPartialSize > 0 && Format(opts.LogFormat) == CRIOFormat {
+		if err := validatePartialSize(opts.CRIOPartialSize, opts.Workers, opts.UseRandomHostname); err != nil {
+			return nil, err
+		}
+	}
+
+	destination := string(opts.Client)
+	if destination == "" {
+		destination = "stdout"
+	}
+
 	generator := LogGenerator{
-		opts: opts,
-		rate: opts.LogsPerSecond,
-		logCount: prometheus.NewCounter(prometheus.CounterOpts{
+		opts:        opts,
+		done:        make(chan struct{}),
+		destination: destination,
+		logCount: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "log_generator_messages_produced_total",
 			Help: "Total number of messages produced by the log generator",
+		}, []string{"worker"}),
+		byteCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "log_generator_bytes_produced_total",
+			Help: "Total number of bytes produced by the log generator",
+		}, []string{"worker"}),
+		errorCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "log_generator_errors_total",
+			Help: "Total number of messages the log generator failed to write",
+		}, []string{"destination", "worker"}),
+		batchCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "log_generator_batches_total",
+			Help: "Total number of batches sent by the log generator",
+		}, []string{"destination", "status"}),
+		batchDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "log_generator_batch_duration_seconds",
+			Help:    "Time spent sending a batch including retries",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"destination"}),
+		achievedRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "log_generator_achieved_rate",
+			Help: "Number of messages produced by the log generator per second",
+		}, []string{"worker"}),
+		scheduleLag: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "log_generator_schedule_lag_seconds",
+			Help:    "Time messages were produced behind their schedule",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"worker"}),
+		ackLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "log_generator_forward_ack_latency_seconds",
+			Help:    "Time between sending a forward protocol message and receiving its acknowledgement",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+		}),
+		produceLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "log_generator_kafka_produce_latency_seconds",
+			Help:    "Time between producing a Kafka record and its acknowledgement by the broker",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
 		}),
+		responseCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "log_generator_http_responses_total",
+			Help: "Total number of responses received by the generic HTTP client by status code class",
+		}, []string{"code"}),
+		failureCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "log_generator_elasticsearch_failures_total",
+			Help: "Total number of documents Elasticsearch failed to write by error type",
+		}, []string{"error_type"}),
 	}
+
+	// Every worker produces an equal share of the rate
+	share := ScaleProfile(profile, 1/float64(opts.Workers))
+	for i := 0; i < opts.Workers; i++ {
+		pacer, err := NewPacer(share, opts.PacingJitter)
+		if err != nil {
+			return nil, err
+		}
+		generator.workers = append(generator.workers, &worker{
+			id:    strconv.Itoa(i),
+			pacer: pacer,
+		})
+	}
+
 	registry.MustRegister(
 		generator.logCount,
+		generator.byteCount,
+		generator.errorCount,
+		generator.batchCount,
+		generator.batchDuration,
+		generator.achievedRate,
+		generator.scheduleLag,
+		generator.ackLatency,
+		generator.produceLatency,
+		generator.responseCount,
+		generator.failureCount,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "log_generator_target_rate",
+			Help: "Number of messages per second the log generator currently aims to produce",
+		}, generator.targetRate),
 	)
 
 	switch opts.Client {
@@ -92,35 +388,334 @@ func NewLogGenerator(opts Options, registry *prometheus.Registry) (*LogGenerator
 		generator.deferClose = func() {
 			fmt.Println("done")
 		}
+	case "pods":
+		if Format(opts.LogFormat) != CRIOFormat {
+			return nil, fmt.Errorf("Unable to initialize pod log client: log format must be %s, got %s", CRIOFormat, opts.LogFormat)
+		}
+		churnInterval, err := time.ParseDuration(opts.PodsChurnInterval)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize pod log client: invalid churn interval %q: %s", opts.PodsChurnInterval, err)
+		}
+		restartInterval, err := time.ParseDuration(opts.PodsRestartInterval)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize pod log client: invalid restart interval %q: %s", opts.PodsRestartInterval, err)
+		}
+
+		client, err := clients.NewPodLogClient(clients.PodLogConfig{
+			Directory:       opts.PodsDirectory,
+			Namespaces:      opts.PodsNamespaces,
+			Pods:            opts.PodsPerNamespace,
+			Containers:      opts.PodsContainers,
+			ChurnInterval:   churnInterval,
+			RestartInterval: restartInterval,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize pod log client %v", err)
+		}
+
+		generator.podLogClient = client
+		generator.writeToDestination = generator.writePodLog
+		generator.deferClose = func() {
+			generator.podLogClient.Close()
+		}
 	case "loki":
-		client, err := clients.NewPromtailClient(opts.ClientURL, opts.Tenant, opts.DisableSecurityCheck)
+		batchConfig, err := newBatchConfig(opts)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize loki client %v", err)
+		}
+		metadata, err := parseKeyValues(opts.LokiStructuredMetadata)
 		if err != nil {
-			return nil, fmt.Errorf("Unable to initialize promtail client %v", err)
+			return nil, fmt.Errorf("Unable to initialize loki client %v", err)
+		}
+		for name, value := range metadata {
+			generator.structuredMetadata = append(generator.structuredMetadata, logproto.LabelAdapter{Name: name, Value: value})
 		}
 
-		generator.promtailClient = client
+		client, err := clients.NewLokiClient(clients.LokiConfig{
+			URL:                  opts.ClientURL,
+			TenantID:             opts.Tenant,
+			DisableSecurityCheck: opts.DisableSecurityCheck,
+			Encoding:             clients.LokiEncoding(opts.LokiEncoding),
+			Compression:          opts.Compression,
+			Batch:                batchConfig,
+			OnBatch:              generator.observeBatch,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize loki client %v", err)
+		}
+
+		generator.lokiClient = client
 		generator.writeToDestination = generator.sendLokiLog
 		generator.deferClose = func() {
-			generator.promtailClient.Stop()
+			generator.lokiClient.Stop()
+		}
+	case "otlp":
+		batchConfig, err := newBatchConfig(opts)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize otlp client %v", err)
+		}
+
+		client, err := clients.NewOTLPClient(clients.OTLPConfig{
+			URL:                  opts.ClientURL,
+			DisableSecurityCheck: opts.DisableSecurityCheck,
+			Protocol:             clients.OTLPProtocol(opts.OTLPProtocol),
+			Compression:          opts.Compression,
+			Batch:                batchConfig,
+			OnBatch:              generator.observeBatch,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize otlp client %v", err)
+		}
+
+		generator.otlpClient = client
+		generator.writeToDestination = generator.sendOTLPLog
+		generator.deferClose = func() {
+			generator.otlpClient.Stop()
+		}
+	case "syslog":
+		facility, ok := syslogFacilities[opts.SyslogFacility]
+		if !ok {
+			return nil, fmt.Errorf("Unable to initialize syslog client: unknown facility %s", opts.SyslogFacility)
+		}
+
+		client, err := clients.NewSyslogClient(clients.SyslogConfig{
+			URL:     opts.ClientURL,
+			Format:  clients.SyslogFormat(opts.SyslogFormat),
+			Framing: clients.SyslogFraming(opts.SyslogFraming),
+			TLS: clients.TLSConfig{
+				CAFile:             opts.TLSCAFile,
+				CertFile:           opts.TLSCertFile,
+				KeyFile:            opts.TLSKeyFile,
+				InsecureSkipVerify: opts.DisableSecurityCheck,
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize syslog client %v", err)
+		}
+
+		generator.syslogClient = client
+		generator.syslogFacility = facility
+		generator.writeToDestination = generator.sendSyslogLog
+		generator.deferClose = func() {
+			generator.syslogClient.Close()
+		}
+	case "forward":
+		batchConfig, err := newBatchConfig(opts)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize forward client %v", err)
+		}
+		ackTimeout, err := time.ParseDuration(opts.ForwardAckTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize forward client: invalid ack timeout %q: %s", opts.ForwardAckTimeout, err)
+		}
+
+		client, err := clients.NewForwardClient(clients.ForwardConfig{
+			URL:         opts.ClientURL,
+			Mode:        clients.ForwardMode(opts.ForwardMode),
+			Compression: opts.Compression,
+			RequireAck:  opts.ForwardRequireAck,
+			AckTimeout:  ackTimeout,
+			SharedKey:   opts.ForwardSharedKey,
+			TLS: clients.TLSConfig{
+				CAFile:             opts.TLSCAFile,
+				CertFile:           opts.TLSCertFile,
+				KeyFile:            opts.TLSKeyFile,
+				InsecureSkipVerify: opts.DisableSecurityCheck,
+			},
+			Batch:   batchConfig,
+			OnBatch: generator.observeBatch,
+			OnAck: func(latency time.Duration) {
+				generator.ackLatency.Observe(latency.Seconds())
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize forward client %v", err)
+		}
+		registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "log_generator_forward_unacked_chunks",
+			Help: "Number of forward protocol messages waiting for their acknowledgement",
+		}, func() float64 {
+			return float64(client.Unacked())
+		}))
+
+		generator.forwardClient = client
+		generator.writeToDestination = generator.sendForwardLog
+		generator.deferClose = func() {
+			generator.forwardClient.Stop()
+		}
+	case "kafka":
+		batchConfig, err := newBatchConfig(opts)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize kafka client %v", err)
+		}
+
+		client, err := clients.NewKafkaClient(clients.KafkaConfig{
+			Brokers:     opts.ClientURL,
+			Topic:       opts.KafkaTopic,
+			Partitioner: clients.KafkaPartitioner(opts.KafkaPartitioner),
+			Compression: opts.Compression,
+			Acks:        opts.KafkaAcks,
+			BatchSize:   batchConfig.Size,
+			Linger:      batchConfig.Wait,
+			Backoff:     batchConfig.Backoff,
+			OnProduce:   generator.observeProduce,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize kafka client %v", err)
+		}
+
+		generator.kafkaClient = client
+		generator.writeToDestination = generator.sendKafkaLog
+		generator.deferClose = func() {
+			generator.kafkaClient.Stop()
+		}
+	case "splunk":
+		batchConfig, err := newBatchConfig(opts)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize splunk client %v", err)
+		}
+		ackTimeout, err := time.ParseDuration(opts.SplunkAckTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize splunk client: invalid ack timeout %q: %s", opts.SplunkAckTimeout, err)
+		}
+
+		client, err := clients.NewSplunkClient(clients.SplunkConfig{
+			URL:                  opts.ClientURL,
+			Token:                opts.SplunkToken,
+			DisableSecurityCheck: opts.DisableSecurityCheck,
+			Endpoint:             clients.SplunkEndpoint(opts.SplunkEndpoint),
+			Index:                opts.SplunkIndex,
+			SourceType:           opts.SplunkSourceType,
+			Source:               opts.SplunkSource,
+			Channel:              opts.SplunkChannel,
+			Ack:                  opts.SplunkAck,
+			AckTimeout:           ackTimeout,
+			Compression:          opts.Compression,
+			Batch:                batchConfig,
+			OnBatch:              generator.observeBatch,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize splunk client %v", err)
+		}
+
+		generator.splunkClient = client
+		generator.writeToDestination = generator.sendSplunkLog
+		generator.deferClose = func() {
+			generator.splunkClient.Stop()
+		}
+	case "http":
+		batchConfig, err := newBatchConfig(opts)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize http client %v", err)
+		}
+		headers := map[string]string{}
+		for _, header := range opts.HTTPHeaders {
+			name, value, ok := strings.Cut(header, ":")
+			if !ok {
+				return nil, fmt.Errorf("Unable to initialize http client: invalid header %q, expected \"Name: value\"", header)
+			}
+			headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+		var retryStatusCodes []int
+		for _, code := range strings.Split(opts.HTTPRetryStatusCodes, ",") {
+			if code = strings.TrimSpace(code); code == "" {
+				continue
+			}
+			c, err := strconv.Atoi(code)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to initialize http client: invalid retry status code %q", code)
+			}
+			retryStatusCodes = append(retryStatusCodes, c)
+		}
+
+		client, err := clients.NewHTTPSinkClient(clients.HTTPSinkConfig{
+			URL:                  opts.ClientURL,
+			Method:               opts.HTTPMethod,
+			Headers:              headers,
+			DisableSecurityCheck: opts.DisableSecurityCheck,
+			Encoding:             clients.HTTPEncoding(opts.HTTPEncoding),
+			Compression:          opts.Compression,
+			RetryStatusCodes:     retryStatusCodes,
+			Batch:                batchConfig,
+			OnBatch:              generator.observeBatch,
+			OnResponse: func(code int) {
+				class := "error"
+				if code > 0 {
+					class = fmt.Sprintf("%dxx", code/100)
+				}
+				generator.responseCount.WithLabelValues(class).Inc()
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize http client %v", err)
+		}
+
+		generator.httpClient = client
+		generator.writeToDestination = generator.sendHTTPLog
+		generator.deferClose = func() {
+			generator.httpClient.Stop()
 		}
 	case "elasticsearch":
-		client, err := clients.NewElasticsearchClient(opts.ClientURL)
+		client, err := clients.NewElasticsearchClient(clients.ElasticsearchConfig{
+			URL:                  opts.ClientURL,
+			Backend:              clients.ElasticsearchBackend(opts.ElasticsearchBackend),
+			Username:             opts.ElasticsearchUsername,
+			Password:             opts.ElasticsearchPassword,
+			APIKey:               opts.ElasticsearchAPIKey,
+			DisableSecurityCheck: opts.DisableSecurityCheck,
+			TLS: clients.TLSConfig{
+				CAFile:   opts.TLSCAFile,
+				CertFile: opts.TLSCertFile,
+				KeyFile:  opts.TLSKeyFile,
+			},
+		})
 		if err != nil {
 			return nil, fmt.Errorf("Unable to initialize elasticsearch client %v", err)
 		}
-		indexer, err := clients.NewElasticsearchBulkIndexer(client)
-		if err != nil {
+		index := clients.ElasticsearchIndexConfig{
+			Name:         opts.ElasticsearchIndex,
+			Strategy:     clients.IndexStrategy(opts.ElasticsearchIndexStrategy),
+			Recreate:     opts.ElasticsearchRecreateIndex,
+			TemplateFile: opts.ElasticsearchTemplateFile,
+			MappingsFile: opts.ElasticsearchMappingsFile,
+		}
+		if err = clients.SetupElasticsearchIndex(client, index); err != nil {
 			return nil, fmt.Errorf("Unable to initialize elasticsearch client %v", err)
 		}
-		if err = clients.RecreateElasticsearchIndex(client, clients.IndexName); err != nil {
+		indexer, err := clients.NewElasticsearchBulkIndexer(client, index.Name, clients.ElasticsearchBulkObserver{
+			OnFlush:   generator.observeElasticsearchFlush,
+			OnFailure: generator.observeElasticsearchFailure,
+		})
+		if err != nil {
 			return nil, fmt.Errorf("Unable to initialize elasticsearch client %v", err)
 		}
 
+		stats := map[string]func(esutil.BulkIndexerStats) uint64{
+			"added":   func(s esutil.BulkIndexerStats) uint64 { return s.NumAdded },
+			"flushed": func(s esutil.BulkIndexerStats) uint64 { return s.NumFlushed },
+			"failed":  func(s esutil.BulkIndexerStats) uint64 { return s.NumFailed },
+			"indexed": func(s esutil.BulkIndexerStats) uint64 { return s.NumIndexed },
+			"created": func(s esutil.BulkIndexerStats) uint64 { return s.NumCreated },
+		}
+		for status, stat := range stats {
+			registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "log_generator_elasticsearch_documents_total",
+				Help:        "Total number of documents handled by the Elasticsearch bulk indexer by status",
+				ConstLabels: prometheus.Labels{"status": status},
+			}, func() float64 { return float64(stat(indexer.Stats())) }))
+		}
+		registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "log_generator_elasticsearch_bulk_requests_total",
+			Help: "Total number of bulk requests sent by the Elasticsearch bulk indexer",
+		}, func() float64 { return float64(indexer.Stats().NumRequests) }))
+
 		generator.elasticsearchClient = client
+		generator.elasticsearchIndex = index
 		generator.elasticsearchBulkIndexer = indexer
 		generator.writeToDestination = generator.sendElasticsearchLog
 		generator.deferClose = func() {
 			_ = generator.elasticsearchBulkIndexer.Close(context.Background())
+			generator.checkFailureRatio(1)
 		}
 	default:
 		generator.writeToDestination = generator.writeLogToStdout
@@ -132,69 +727,223 @@ func NewLogGenerator(opts Options, registry *prometheus.Registry) (*LogGenerator
 	return &generator, nil
 }
 
-func (g *LogGenerator) Start(ctx context.Context, wg *sync.WaitGroup, _ chan<- error) {
+func (g *LogGenerator) Start(ctx context.Context, wg *sync.WaitGroup, errCh chan<- error) {
+	g.errCh = errCh
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer close(g.done)
 		g.generateLogs(ctx)
+		g.printSummary()
 	}()
 }
 
-func (g *LogGenerator) generateLogs(ctx context.Context) {
+// Done returns a channel which is closed once the log generator stopped, either
+// because the context is done or because a limit was reached.
+func (g *LogGenerator) Done() <-chan struct{} {
+	return g.done
+}
+
+// Hostnames returns the hostnames the given number of workers write logs with, the
+// hostname of the machine with the worker number appended if there are several workers
+func Hostnames(workers int) ([]string, error) {
 	host, err := os.Hostname()
 	if err != nil {
-		log.Fatalf("error getting hostname: %s", err)
+		return nil, fmt.Errorf("error getting hostname: %s", err)
+	}
+	if workers == 1 {
+		return []string{host}, nil
 	}
-	defer g.deferClose()
 
-	var lineCount int64 = 0
+	hostnames := make([]string, workers)
+	for i := range hostnames {
+		hostnames[i] = fmt.Sprintf("%s-%d", host, i)
+	}
+	return hostnames, nil
+}
 
-	logHostname := host
-	if g.opts.UseRandomHostname {
-		logHostname = fmt.Sprintf("%s.%032X", host, rand.Uint64())
+// validatePartialSize checks that the sequence header of the longest hostname of the
+// workers fits into a partial record
+func validatePartialSize(partialSize, workers int, randomHostname bool) error {
+	hostnames, err := Hostnames(workers)
+	if err != nil {
+		return err
+	}
+	host := hostnames[len(hostnames)-1]
+	if randomHostname {
+		host = randomHostnameOf(host)
 	}
 
-	for {
-		if err := ctx.Err(); err != nil {
-			log.Debug("Shutting down log generator...")
-			break
+	if header := len(sequenceHeader(host, 0)); partialSize < header {
+		return fmt.Errorf("invalid crio partial size %d: must be at least the %d bytes of the sequence header", partialSize, header)
+	}
+	return nil
+}
+
+// randomHostnameOf appends a random suffix to the hostname
+func randomHostnameOf(host string) string {
+	return fmt.Sprintf("%s.%032X", host, rand.Uint64())
+}
+
+func (g *LogGenerator) generateLogs(ctx context.Context) {
+	hostnames, err := Hostnames(len(g.workers))
+	if err != nil {
+		log.Fatal(err)
+	}
+	start := time.Now()
+	wg := &sync.WaitGroup{}
+	for i, w := range g.workers {
+		w.host = hostnames[i]
+
+		w.logHostname = w.host
+		if g.opts.UseRandomHostname {
+			w.logHostname = randomHostnameOf(w.host)
 		}
 
-		next := time.Now().UTC().Add(1 * time.Second)
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			g.runWorker(ctx, w)
+		}(w)
+	}
+	wg.Wait()
+	duration := time.Since(start).Seconds()
 
-		for i := 0; i < g.rate; i++ {
-			logLine, err := RandomLog(LogType(g.opts.LogType), g.opts.SyntheticPayloadSize)
-			if err != nil {
-				log.Fatalf("error creating log: %s", err)
-			}
+	// Flush the batches left in the clients before counting their errors
+	g.deferClose()
 
-			formattedLogLine, err := FormatLog(Format(g.opts.LogFormat), logHostname, lineCount, logLine)
-			if err != nil {
-				log.Fatalf("error formating log: %s", err)
-			}
+	g.summary = Summary{
+		Destination:     g.destination,
+		Lines:           g.lines.Load(),
+		Bytes:           g.bytes.Load(),
+		DurationSeconds: duration,
+		Errors:          map[string]int64{g.destination: g.errors.Load()},
+	}
+	if duration > 0 {
+		g.summary.AchievedRate = float64(g.summary.Lines) / duration
+	}
+}
 
-			err = g.writeToDestination(host, formattedLogLine, LabelSetOptions(g.opts.LabelType))
-			if err != nil {
-				log.Fatalf("error writing log: %s", err)
-			}
+func (g *LogGenerator) targetRate() float64 {
+	var rate float64
+	for _, w := range g.workers {
+		rate += w.pacer.Rate()
+	}
+	return rate
+}
+
+// observeBatch records the outcome of a batch sent asynchronously by a client
+func (g *LogGenerator) observeBatch(result clients.BatchResult) {
+	g.batchDuration.WithLabelValues(g.destination).Observe(result.Duration.Seconds())
+	if result.Err != nil {
+		log.Errorf("error sending batch of %d logs: %s", result.Entries, result.Err)
+		g.batchCount.WithLabelValues(g.destination, "failure").Inc()
+		for worker, entries := range result.Workers {
+			g.errorCount.WithLabelValues(g.destination, worker).Add(float64(entries))
+		}
+		g.errors.Add(int64(result.Entries))
+		return
+	}
+	if result.Rejected > 0 {
+		log.Errorf("receiver rejected %d of %d logs", result.Rejected, result.Entries)
+		g.errorCount.WithLabelValues(g.destination, rejectedWorker(result.Workers)).Add(float64(result.Rejected))
+		g.errors.Add(int64(result.Rejected))
+	}
+	g.batchCount.WithLabelValues(g.destination, "success").Inc()
+}
 
-			g.logCount.Inc()
-			lineCount++
+// rejectedWorker returns the worker the logs rejected from a batch are counted for.
+// Receivers do not tell which logs they rejected, which leaves the worker unknown if
+// the batch holds logs of several workers.
+func rejectedWorker(workers map[string]int) string {
+	if len(workers) == 1 {
+		for worker := range workers {
+			return worker
 		}
+	}
+	return unknownWorker
+}
+
+// observeElasticsearchFlush records the outcome of a bulk request and fails the run
+// if too many documents failed. The failed documents are counted as they are reported.
+func (g *LogGenerator) observeElasticsearchFlush(result clients.BatchResult) {
+	g.batchDuration.WithLabelValues(g.destination).Observe(result.Duration.Seconds())
+	switch {
+	case result.Err != nil:
+		log.Errorf("error sending bulk request: %s", result.Err)
+		g.batchCount.WithLabelValues(g.destination, "failure").Inc()
+	case result.Rejected > 0:
+		log.Errorf("elasticsearch failed to write %d of %d documents", result.Rejected, result.Entries)
+		fallthrough
+	default:
+		g.batchCount.WithLabelValues(g.destination, "success").Inc()
+	}
+	g.checkFailureRatio(minFailureRatioDocuments)
+}
+
+// observeElasticsearchFailure counts the documents Elasticsearch failed to write
+func (g *LogGenerator) observeElasticsearchFailure(worker, errorType string, documents int) {
+	if worker == "" {
+		worker = unknownWorker
+	}
+	g.failureCount.WithLabelValues(errorType).Add(float64(documents))
+	g.errorCount.WithLabelValues(g.destination, worker).Add(float64(documents))
+	g.errors.Add(int64(documents))
+}
+
+// checkFailureRatio fails the run once the fraction of documents Elasticsearch
+// failed to write exceeds the maximum failure ratio, as soon as the given number of
+// documents is done
+func (g *LogGenerator) checkFailureRatio(minDocuments uint64) {
+	stats := g.elasticsearchBulkIndexer.Stats()
+	done := stats.NumFlushed + stats.NumFailed
+	if done == 0 || done < minDocuments {
+		return
+	}
+
+	ratio := float64(stats.NumFailed) / float64(done)
+	if ratio > g.opts.ElasticsearchMaxFailureRatio {
+		g.fail(fmt.Errorf("elasticsearch failed to write %d of %d documents, failure ratio %.3f exceeds %.3f",
+			stats.NumFailed, done, ratio, g.opts.ElasticsearchMaxFailureRatio))
+	}
+}
 
-		current := time.Now().UTC()
-		if current.Before(next) {
-			time.Sleep(next.Sub(current))
+// fail stops the run with an error, only the first error is reported
+func (g *LogGenerator) fail(err error) {
+	g.failOnce.Do(func() {
+		if g.errCh != nil {
+			g.errCh <- err
 		}
+	})
+}
+
+// observeProduce records the outcome of a record produced asynchronously
+func (g *LogGenerator) observeProduce(worker string, latency time.Duration, err error) {
+	if err != nil {
+		log.Errorf("error producing log: %s", err)
+		g.errorCount.WithLabelValues(g.destination, worker).Inc()
+		g.errors.Add(1)
+		return
+	}
+	g.produceLatency.Observe(latency.Seconds())
+}
+
+func (g *LogGenerator) printSummary() {
+	data, err := json.Marshal(g.summary)
+	if err != nil {
+		log.Errorf("error encoding log generator summary: %s", err)
+		return
 	}
+	// Stdout may carry the generated logs
+	fmt.Fprintln(os.Stderr, string(data))
 }
 
-func (g *LogGenerator) writeLogToStdout(host, logLine string, labelOpts LabelSetOptions) error {
+func (g *LogGenerator) writeLogToStdout(worker, host, logLine string, labelOpts LabelSetOptions) error {
 	fmt.Printf("%s", logLine)
 	return nil
 }
 
-func (g *LogGenerator) writeLogToFile(host, logLine string, labelOpts LabelSetOptions) error {
+func (g *LogGenerator) writeLogToFile(worker, host, logLine string, labelOpts LabelSetOptions) error {
 	_, err := fmt.Fprintf(g.file, "%s", logLine)
 	if err != nil {
 		return err
@@ -202,16 +951,150 @@ func (g *LogGenerator) writeLogToFile(host, logLine string, labelOpts LabelSetOp
 	return nil
 }
 
-func (g *LogGenerator) sendLokiLog(host, logLine string, labelOpts LabelSetOptions) error {
-	labels := LogLabelSet(host, LabelSetOptions(labelOpts))
-	clients.SendLogWithPromtail(g.promtailClient, logLine, labels)
+func (g *LogGenerator) writePodLog(worker, host, logLine string, labelOpts LabelSetOptions) error {
+	return g.podLogClient.Write(logLine)
+}
+
+func (g *LogGenerator) sendLokiLog(worker, host, logLine string, labelOpts LabelSetOptions) error {
+	g.lokiClient.Send(worker, clients.LokiEntry{
+		Labels: LogLabelSet(host, LabelSetOptions(labelOpts)),
+		Entry: logproto.Entry{
+			Timestamp:          time.Now(),
+			Line:               logLine,
+			StructuredMetadata: g.structuredMetadata,
+		},
+	})
+	return nil
+}
+
+func (g *LogGenerator) sendOTLPLog(worker, host, logLine string, labelOpts LabelSetOptions) error {
+	// The label type only applies to Loki, the client label is not an attribute of the
+	// resource which emitted the log
+	labels := LogLabelSet(host, AllLabelsOption)
+	g.otlpClient.Send(worker, clients.OTLPEntry{
+		Resource: map[string]string{
+			"host.name":    string(labels["hostname"]),
+			"service.name": string(labels["service"]),
+			"component":    string(labels["component"]),
+		},
+		Timestamp:    time.Now(),
+		SeverityText: string(labels["level"]),
+		Severity:     LevelSeverity(labels["level"]),
+		Body:         logLine,
+	})
 	return nil
 }
 
-func (g *LogGenerator) sendElasticsearchLog(host, logLine string, labelOpts LabelSetOptions) error {
+func (g *LogGenerator) sendSyslogLog(worker, host, logLine string, labelOpts LabelSetOptions) error {
+	labels := LogLabelSet(host, AllLabelsOption)
+	return g.syslogClient.Send(clients.SyslogMessage{
+		Facility:  g.syslogFacility,
+		Severity:  LevelSyslogSeverity(labels["level"]),
+		Timestamp: time.Now(),
+		Hostname:  host,
+		AppName:   string(labels["service"]),
+		Message:   logLine,
+	})
+}
+
+func (g *LogGenerator) sendForwardLog(worker, host, logLine string, labelOpts LabelSetOptions) error {
+	labels := LogLabelSet(host, AllLabelsOption)
+	g.forwardClient.Send(worker, clients.ForwardEntry{
+		Tag:  fmt.Sprintf("%s.%s", labels["service"], labels["component"]),
+		Time: time.Now(),
+		Record: map[string]string{
+			"message":   logLine,
+			"hostname":  host,
+			"level":     string(labels["level"]),
+			"service":   string(labels["service"]),
+			"component": string(labels["component"]),
+		},
+	})
+	return nil
+}
+
+func (g *LogGenerator) sendKafkaLog(worker, host, logLine string, labelOpts LabelSetOptions) error {
+	g.kafkaClient.Send(worker, host, strings.TrimSuffix(logLine, "\n"))
+	return nil
+}
+
+func (g *LogGenerator) sendSplunkLog(worker, host, logLine string, labelOpts LabelSetOptions) error {
 	content, err := NewElasticsearchLogContent(host, logLine)
 	if err != nil {
 		return err
 	}
-	return clients.SendLogWithElasticsearch(g.elasticsearchBulkIndexer, content)
+
+	g.splunkClient.Send(worker, clients.SplunkEvent{
+		Time:  time.Now(),
+		Host:  host,
+		Event: content,
+	})
+	return nil
+}
+
+func (g *LogGenerator) sendHTTPLog(worker, host, logLine string, labelOpts LabelSetOptions) error {
+	if clients.HTTPEncoding(g.opts.HTTPEncoding) == clients.TextEncoding {
+		g.httpClient.Send(worker, []byte(logLine))
+		return nil
+	}
+
+	content, err := NewElasticsearchLogContent(host, logLine)
+	if err != nil {
+		return err
+	}
+	g.httpClient.Send(worker, content)
+	return nil
+}
+
+func (g *LogGenerator) sendElasticsearchLog(worker, host, logLine string, labelOpts LabelSetOptions) error {
+	content := newElasticsearchLogContent(host, logLine)
+	data, err := content.Encode()
+	if err != nil {
+		return err
+	}
+
+	// The service of a log stands in for its namespace
+	index := g.elasticsearchIndex.Target(content.CreatedAt, content.Service)
+	return clients.SendLogWithElasticsearch(g.elasticsearchBulkIndexer, worker, index, g.elasticsearchIndex.Action(), data)
+}
+
+func newBatchConfig(opts Options) (clients.BatchConfig, error) {
+	cfg := clients.BatchConfig{
+		Size: opts.BatchSize,
+		Backoff: backoff.Config{
+			MaxRetries: opts.MaxRetries,
+		},
+	}
+
+	var err error
+	if cfg.Wait, err = time.ParseDuration(opts.BatchWait); err != nil {
+		return cfg, fmt.Errorf("invalid batch wait %q: %s", opts.BatchWait, err)
+	}
+	if cfg.Backoff.MinBackoff, err = time.ParseDuration(opts.MinBackoff); err != nil {
+		return cfg, fmt.Errorf("invalid min backoff %q: %s", opts.MinBackoff, err)
+	}
+	if cfg.Backoff.MaxBackoff, err = time.ParseDuration(opts.MaxBackoff); err != nil {
+		return cfg, fmt.Errorf("invalid max backoff %q: %s", opts.MaxBackoff, err)
+	}
+	if cfg.Wait <= 0 || cfg.Size <= 0 {
+		return cfg, fmt.Errorf("batch size and wait must be positive")
+	}
+	return cfg, nil
+}
+
+// parseKeyValues parses a comma separated list of key=value pairs
+func parseKeyValues(s string) (map[string]string, error) {
+	values := map[string]string{}
+	if s == "" {
+		return values, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid key=value pair %q", pair)
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return values, nil
 }
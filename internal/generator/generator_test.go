@@ -0,0 +1,258 @@
+package generator
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
+)
+
+func TestKafkaProduceErrors(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "logs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+
+	// The broker rejects every record with an error which is not retried
+	cluster.ControlKey(kmsg.Produce.Int16(), func(req kmsg.Request) (kmsg.Response, error, bool) {
+		cluster.KeepControl()
+		produce := req.(*kmsg.ProduceRequest)
+		res := produce.ResponseKind().(*kmsg.ProduceResponse)
+		for _, topic := range produce.Topics {
+			rt := kmsg.NewProduceResponseTopic()
+			rt.Topic = topic.Topic
+			for _, partition := range topic.Partitions {
+				rp := kmsg.NewProduceResponseTopicPartition()
+				rp.Partition = partition.Partition
+				rp.ErrorCode = kerr.InvalidRecord.Code
+				rt.Partitions = append(rt.Partitions, rp)
+			}
+			res.Topics = append(res.Topics, rt)
+		}
+		return res, nil, true
+	})
+
+	g, err := NewLogGenerator(Options{
+		Client:           KafkaClientType,
+		ClientURL:        strings.Join(cluster.ListenAddrs(), ","),
+		LogsPerSecond:    1,
+		Workers:          1,
+		KafkaTopic:       "logs",
+		KafkaPartitioner: "round-robin",
+		KafkaAcks:        "all",
+		BatchSize:        1 << 20,
+		BatchWait:        "1ms",
+		MaxRetries:       3,
+		MinBackoff:       "1ms",
+		MaxBackoff:       "10ms",
+	}, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, worker := range []string{"0", "1", "1"} {
+		if err := g.writeToDestination(worker, "host", "line\n", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Stopping waits for the records to be produced
+	g.deferClose()
+
+	for worker, want := range map[string]float64{"0": 1, "1": 2} {
+		if got := testutil.ToFloat64(g.errorCount.WithLabelValues("kafka", worker)); got != want {
+			t.Errorf("got %g errors of worker %s, want %g", got, worker, want)
+		}
+	}
+	if got := g.errors.Load(); got != 3 {
+		t.Errorf("got %d errors in the summary, want 3", got)
+	}
+}
+
+func TestOTLPLogs(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []plogotlp.ExportRequest
+	)
+	// The receiver rejects one record of every request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req := plogotlp.NewExportRequest()
+		if err := req.UnmarshalProto(data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+
+		res := plogotlp.NewExportResponse()
+		res.PartialSuccess().SetRejectedLogRecords(1)
+		data, _ = res.MarshalProto()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	g, err := NewLogGenerator(Options{
+		Client:               OTLPClientType,
+		ClientURL:            server.URL + "/v1/logs",
+		DisableSecurityCheck: true,
+		OTLPProtocol:         "http/protobuf",
+		LogsPerSecond:        1,
+		Workers:              1,
+		BatchSize:            1 << 20,
+		BatchWait:            "1h",
+		MaxRetries:           3,
+		MinBackoff:           "1ms",
+		MaxBackoff:           "10ms",
+	}, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The Loki label type does not apply to OTLP
+	if err := g.writeToDestination("0", "host", "line", ClientOnlyOption); err != nil {
+		t.Fatal(err)
+	}
+	g.deferClose()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 || requests[0].Logs().LogRecordCount() != 1 {
+		t.Fatalf("got %d requests, want 1 request with 1 record", len(requests))
+	}
+	rl := requests[0].Logs().ResourceLogs().At(0)
+	attributes := rl.Resource().Attributes().AsRaw()
+	if attributes["host.name"] != "host" || attributes["service.name"] == "" || attributes["component"] == "" {
+		t.Errorf("got resource attributes %v, want host.name, service.name and component", attributes)
+	}
+	if _, ok := attributes["client"]; ok {
+		t.Errorf("got client resource attribute in %v", attributes)
+	}
+	record := rl.ScopeLogs().At(0).LogRecords().At(0)
+	if record.SeverityText() == "" || record.SeverityNumber() != LevelSeverity(model.LabelValue(record.SeverityText())) {
+		t.Errorf("got severity %q %s, want the severity of the level", record.SeverityText(), record.SeverityNumber())
+	}
+
+	if got := testutil.ToFloat64(g.errorCount.WithLabelValues("otlp", "0")); got != 1 {
+		t.Errorf("got %g errors of worker 0, want 1 rejected log", got)
+	}
+	if got := g.errors.Load(); got != 1 {
+		t.Errorf("got %d errors in the summary, want 1", got)
+	}
+}
+
+func TestHTTPClient(t *testing.T) {
+	tests := []struct {
+		name         string
+		headers      []string
+		retryCodes   string
+		statuses     []int
+		wantErr      bool
+		wantResponse map[string]float64
+	}{
+		{
+			name:         "retried status",
+			headers:      []string{"X-Scope-OrgID:  tenant ", "Authorization: Bearer a:b"},
+			retryCodes:   "429, 503",
+			statuses:     []int{503},
+			wantResponse: map[string]float64{"2xx": 1, "5xx": 1},
+		},
+		{
+			name:         "status not retried",
+			headers:      []string{"X-Scope-OrgID: tenant", "Authorization: Bearer a:b"},
+			retryCodes:   "429",
+			statuses:     []int{503, 400},
+			wantResponse: map[string]float64{"5xx": 1},
+		},
+		{name: "header without colon", headers: []string{"X-Scope-OrgID tenant"}, wantErr: true},
+		{name: "invalid retry status code", retryCodes: "429,5xx", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				requests int
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if got := r.Header.Get("X-Scope-OrgID"); got != "tenant" {
+					t.Errorf("got header X-Scope-OrgID %q, want tenant", got)
+				}
+				if got := r.Header.Get("Authorization"); got != "Bearer a:b" {
+					t.Errorf("got header Authorization %q, want Bearer a:b", got)
+				}
+				status := http.StatusOK
+				if requests < len(tt.statuses) {
+					status = tt.statuses[requests]
+				}
+				requests++
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			g, err := NewLogGenerator(Options{
+				Client:               "http",
+				ClientURL:            server.URL,
+				DisableSecurityCheck: true,
+				HTTPMethod:           http.MethodPost,
+				HTTPEncoding:         "ndjson",
+				HTTPHeaders:          tt.headers,
+				HTTPRetryStatusCodes: tt.retryCodes,
+				LogsPerSecond:        1,
+				Workers:              1,
+				BatchSize:            1,
+				BatchWait:            "1h",
+				MaxRetries:           3,
+				MinBackoff:           "1ms",
+				MaxBackoff:           "1ms",
+			}, prometheus.NewRegistry())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			// The batch size of one byte flushes every log at once, batches are not
+			// retried anymore once the client stops
+			if err := g.writeToDestination("0", "host", "line", ""); err != nil {
+				t.Fatal(err)
+			}
+			for deadline := time.Now().Add(5 * time.Second); testutil.CollectAndCount(g.batchCount) == 0; {
+				if time.Now().After(deadline) {
+					t.Fatal("timed out waiting for the batch")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			g.deferClose()
+
+			for class, want := range tt.wantResponse {
+				if got := testutil.ToFloat64(g.responseCount.WithLabelValues(class)); got != want {
+					t.Errorf("got %g %s responses, want %g", got, class, want)
+				}
+			}
+			if got := testutil.CollectAndCount(g.responseCount); got != len(tt.wantResponse) {
+				t.Errorf("got %d response classes, want %d", got, len(tt.wantResponse))
+			}
+		})
+	}
+}
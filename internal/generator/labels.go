@@ -4,6 +4,7 @@ import (
 	"math/rand"
 
 	"github.com/prometheus/common/model"
+	"go.opentelemetry.io/collector/pdata/plog"
 )
 
 // LabelSetOptions describes which labels to include
@@ -15,6 +16,10 @@ const (
 
 	// ClientHostOnlyOption creates a label set with only the client and host label
 	ClientHostOnlyOption LabelSetOptions = "client-host"
+
+	// AllLabelsOption creates a label set with the client, host, service, level and
+	// component labels
+	AllLabelsOption LabelSetOptions = "none"
 )
 
 var (
@@ -33,6 +38,29 @@ var (
 		"error",
 	}
 
+	// severities maps levels to OpenTelemetry severity numbers
+	severities = map[model.LabelValue]plog.SeverityNumber{
+		"debug": plog.SeverityNumberDebug,
+		"info":  plog.SeverityNumberInfo,
+		"warn":  plog.SeverityNumberWarn,
+		"error": plog.SeverityNumberError,
+	}
+
+	// syslogSeverities maps levels to syslog severities
+	syslogSeverities = map[model.LabelValue]int{
+		"error": 3,
+		"warn":  4,
+		"info":  6,
+		"debug": 7,
+	}
+
+	// syslogFacilities maps facility names to syslog facility codes
+	syslogFacilities = map[string]int{
+		"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+		"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "local0": 16, "local1": 17, "local2": 18,
+		"local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+	}
+
 	services = []model.LabelValue{
 		"potatoes-cart",
 		"phishing",
@@ -64,22 +92,35 @@ func LogLabelSet(host string, options LabelSetOptions) model.LabelSet {
 		return model.LabelSet{
 			"client":    "promtail",
 			"hostname":  model.LabelValue(host),
-			"service":   randService(),
-			"level":     randLevel(),
-			"component": randComponent(),
+			"service":   RandomService(),
+			"level":     RandomLevel(),
+			"component": RandomComponent(),
 		}
 	}
 }
 
-func randLevel() model.LabelValue {
+// LevelSeverity returns the OpenTelemetry severity number of a level
+func LevelSeverity(level model.LabelValue) plog.SeverityNumber {
+	return severities[level]
+}
+
+// LevelSyslogSeverity returns the syslog severity of a level
+func LevelSyslogSeverity(level model.LabelValue) int {
+	return syslogSeverities[level]
+}
+
+// RandomLevel picks one of the levels logs are labeled with at random
+func RandomLevel() model.LabelValue {
 	return levels[rand.Intn(len(levels))]
 }
 
-func randComponent() model.LabelValue {
+// RandomComponent picks one of the components logs are labeled with at random
+func RandomComponent() model.LabelValue {
 	return components[rand.Intn(len(components))]
 }
 
-func randService() model.LabelValue {
+// RandomService picks one of the services logs are labeled with at random
+func RandomService() model.LabelValue {
 	return services[rand.Intn(len(services))]
 }
 
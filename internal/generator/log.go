@@ -23,6 +23,10 @@ const (
 	// SyntheticLogType represents a log that is composed of random
 	// alphabetical characters of a certain size.
 	SyntheticLogType LogType = "synthetic"
+
+	// MultilineLogType represents a multi-line stack trace of a Java, Go, Python
+	// or Node application with a certain number of frames.
+	MultilineLogType LogType = "multiline"
 )
 
 // ElasticsearchLogContent describes the json content for logs for Elasticsearch
@@ -33,6 +37,8 @@ type ElasticsearchLogContent struct {
 	Component string    `json:"component"`
 	Body      string    `json:"body"`
 	CreatedAt time.Time `json:"created_at"`
+	// Timestamp duplicates CreatedAt in the field required by data streams
+	Timestamp time.Time `json:"@timestamp"`
 }
 
 const (
@@ -104,8 +110,9 @@ var (
 	auditSamples = strings.Split(strings.TrimSpace(auditSamplesRaw), "\n")
 )
 
-// RandomLog returns a log of a given type from the requested sample set.
-func RandomLog(logType LogType, logSize int) (string, error) {
+// RandomLog returns a log of a given type from the requested sample set. The size
+// applies to synthetic logs, the frame depth to multiline logs.
+func RandomLog(logType LogType, logSize, frameDepth int) (string, error) {
 	switch logType {
 	case ApplicationLogType:
 		index := rand.Intn(len(applicationSamples))
@@ -118,6 +125,11 @@ func RandomLog(logType LogType, logSize int) (string, error) {
 			return "", fmt.Errorf("invalid size for sythentic log")
 		}
 		return generateSyntheticLog(logSize), nil
+	case MultilineLogType:
+		if frameDepth < 1 {
+			return "", fmt.Errorf("invalid frame depth for multiline log")
+		}
+		return randomStackTrace(frameDepth), nil
 	default:
 		index := rand.Intn(len(simpleSamples))
 		return simpleSamples[index], nil
@@ -127,18 +139,27 @@ func RandomLog(logType LogType, logSize int) (string, error) {
 // NewElasticsearchLogContent returns a byte array representing the json content for
 // a log to be consumed by Elasticsearch.
 func NewElasticsearchLogContent(host, logLine string) ([]byte, error) {
-	content := ElasticsearchLogContent{
+	return newElasticsearchLogContent(host, logLine).Encode()
+}
+
+func newElasticsearchLogContent(host, logLine string) ElasticsearchLogContent {
+	now := time.Now().Round(time.Second).UTC()
+	return ElasticsearchLogContent{
 		Hostname:  host,
-		Service:   string(randService()),
-		Level:     string(randLevel()),
-		Component: string(randComponent()),
+		Service:   string(RandomService()),
+		Level:     string(RandomLevel()),
+		Component: string(RandomComponent()),
 		Body:      logLine,
-		CreatedAt: time.Now().Round(time.Second).UTC(),
+		CreatedAt: now,
+		Timestamp: now,
 	}
+}
 
-	data, err := json.Marshal(content)
+// Encode returns the json content of the log
+func (c ElasticsearchLogContent) Encode() ([]byte, error) {
+	data, err := json.Marshal(c)
 	if err != nil {
-		return nil, fmt.Errorf("error encoding elasticsearch log (%s): %s", logLine, err)
+		return nil, fmt.Errorf("error encoding elasticsearch log (%s): %s", c.Body, err)
 	}
 	return data, nil
 }
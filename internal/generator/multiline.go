@@ -0,0 +1,124 @@
+package generator
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+var (
+	// multilineGenerators create a stack trace of a runtime with the given number of frames
+	multilineGenerators = []func(depth int) string{
+		javaStackTrace,
+		goPanic,
+		pythonTraceback,
+		nodeError,
+	}
+
+	stackModules = []string{
+		"cart",
+		"checkout",
+		"inventory",
+		"payment",
+		"session",
+		"storage",
+	}
+
+	stackFunctions = []string{
+		"handle",
+		"process",
+		"validate",
+		"load",
+		"save",
+		"dispatch",
+		"resolve",
+	}
+
+	stackMessages = []string{
+		"connection reset by peer",
+		"index out of range",
+		"unexpected end of input",
+		"value must not be null",
+		"timeout waiting for lock",
+	}
+)
+
+// randomStackTrace returns a multi-line stack trace of a random runtime with the given
+// number of frames
+func randomStackTrace(depth int) string {
+	return multilineGenerators[rand.Intn(len(multilineGenerators))](depth)
+}
+
+func randomFrame() (string, string) {
+	return stackModules[rand.Intn(len(stackModules))], stackFunctions[rand.Intn(len(stackFunctions))]
+}
+
+func capitalize(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func randomStackMessage() string {
+	return stackMessages[rand.Intn(len(stackMessages))]
+}
+
+// javaStackTrace returns an exception with a cause, the frames are split between both
+func javaStackTrace(depth int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Exception in thread \"main\" java.lang.IllegalStateException: %s", randomStackMessage())
+
+	causeDepth := depth / 2
+	for i := 0; i < depth-causeDepth; i++ {
+		module, function := randomFrame()
+		fmt.Fprintf(&b, "\n\tat com.example.%s.%sService.%s(%sService.java:%d)",
+			module, capitalize(module), function, capitalize(module), rand.Intn(500)+1)
+	}
+	if causeDepth == 0 {
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "\nCaused by: java.io.IOException: %s", randomStackMessage())
+	for i := 0; i < causeDepth; i++ {
+		module, function := randomFrame()
+		fmt.Fprintf(&b, "\n\tat com.example.%s.%sRepository.%s(%sRepository.java:%d)",
+			module, capitalize(module), function, capitalize(module), rand.Intn(500)+1)
+	}
+	fmt.Fprintf(&b, "\n\t... %d more", depth-causeDepth)
+	return b.String()
+}
+
+// goPanic returns a panic with the dump of the panicking goroutine
+func goPanic(depth int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "panic: %s\n\ngoroutine %d [running]:", randomStackMessage(), rand.Intn(1000)+1)
+	for i := 0; i < depth; i++ {
+		module, function := randomFrame()
+		fmt.Fprintf(&b, "\ngithub.com/example/app/%s.%s(0xc%09x)\n\t/app/%s/%s.go:%d +0x%x",
+			module, function, rand.Uint32(), module, module, rand.Intn(500)+1, rand.Intn(0x200))
+	}
+	b.WriteString("\nexit status 2")
+	return b.String()
+}
+
+// pythonTraceback returns a traceback, most recent call last
+func pythonTraceback(depth int) string {
+	var b strings.Builder
+	b.WriteString("Traceback (most recent call last):")
+	for i := 0; i < depth; i++ {
+		module, function := randomFrame()
+		fmt.Fprintf(&b, "\n  File \"/app/%s/%s.py\", line %d, in %s\n    return %s(request)",
+			module, module, rand.Intn(500)+1, function, function)
+	}
+	fmt.Fprintf(&b, "\nValueError: %s", randomStackMessage())
+	return b.String()
+}
+
+// nodeError returns an error with its stack
+func nodeError(depth int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "TypeError: Cannot read properties of undefined (reading '%s')", stackFunctions[rand.Intn(len(stackFunctions))])
+	for i := 0; i < depth; i++ {
+		module, function := randomFrame()
+		fmt.Fprintf(&b, "\n    at %s (/app/src/%s.js:%d:%d)", function, module, rand.Intn(500)+1, rand.Intn(80)+1)
+	}
+	return b.String()
+}
@@ -0,0 +1,103 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// maxBurst is the amount of time a pacer catches up in a single burst after falling
+	// behind schedule, i.e. the capacity of the token bucket.
+	maxBurst = 1 * time.Second
+
+	// maxSleep is the longest a pacer sleeps before consulting its profile again
+	maxSleep = 1 * time.Second
+)
+
+// Pacer spaces emissions evenly over time to sustain the target rate of a load
+// profile. It behaves like a token bucket refilled at the target rate which holds at
+// most one second worth of tokens. Fractional rates below one emission per second
+// are supported.
+type Pacer struct {
+	profile Profile
+	jitter  float64
+	start   time.Time
+	last    time.Time
+	factor  float64
+
+	mu   sync.Mutex
+	rate float64
+}
+
+// NewPacer creates a pacer for the given load profile. The jitter is the fraction by
+// which the interval between two emissions varies randomly, 0 spaces them evenly.
+func NewPacer(profile Profile, jitter float64) (*Pacer, error) {
+	if jitter < 0 || jitter > 1 {
+		return nil, fmt.Errorf("invalid jitter %v: must be between 0 and 1", jitter)
+	}
+
+	return &Pacer{
+		profile: profile,
+		jitter:  jitter,
+		factor:  1,
+	}, nil
+}
+
+// Rate returns the target rate the pacer currently paces emissions at
+func (p *Pacer) Rate() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.rate
+}
+
+// Wait blocks until the next emission is due and returns how far behind schedule it
+// started. It returns an error if the context is done before.
+func (p *Pacer) Wait(ctx context.Context) (time.Duration, error) {
+	now := time.Now()
+	if p.start.IsZero() {
+		p.start = now
+	}
+
+	for {
+		rate := p.profile.Rate(now.Sub(p.start))
+		p.mu.Lock()
+		p.rate = rate
+		p.mu.Unlock()
+
+		sleep := maxSleep
+		if rate > 0 {
+			next := now
+			if !p.last.IsZero() {
+				next = p.last.Add(time.Duration(p.factor * float64(time.Second) / rate))
+			}
+
+			if wait := next.Sub(now); wait <= 0 {
+				p.last = next
+				if earliest := now.Add(-maxBurst); p.last.Before(earliest) {
+					p.last = earliest
+				}
+				if p.jitter > 0 {
+					p.factor = 1 + p.jitter*(2*rand.Float64()-1)
+				}
+				return -wait, nil
+			} else if wait < sleep {
+				sleep = wait
+			}
+		} else {
+			// Nothing to emit, resume evenly once the profile raises the rate again
+			p.last = time.Time{}
+		}
+
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, ctx.Err()
+		case now = <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// profileFunc is a load profile computing the rate from the elapsed time
+type profileFunc func(elapsed time.Duration) float64
+
+func (f profileFunc) Rate(elapsed time.Duration) float64 {
+	return f(elapsed)
+}
+
+func constantRate(rate float64) Profile {
+	return profileFunc(func(time.Duration) float64 { return rate })
+}
+
+// pacerSlack is how late an emission may arrive on a busy machine
+const pacerSlack = 50 * time.Millisecond
+
+func TestPacerWait(t *testing.T) {
+	tests := []struct {
+		name      string
+		profile   Profile
+		jitter    float64
+		emissions int
+		timeout   time.Duration
+		// behind moves the last emission back in time after the first one, as if the
+		// caller was too slow to keep up
+		behind  time.Duration
+		wantErr bool
+		// want returns the earliest and latest arrival of an emission after the first
+		want func(i int) (time.Duration, time.Duration)
+	}{
+		{
+			name:      "even spacing",
+			profile:   constantRate(200),
+			emissions: 20,
+			want: func(i int) (time.Duration, time.Duration) {
+				at := time.Duration(i) * 5 * time.Millisecond
+				return at, at + pacerSlack
+			},
+		},
+		{
+			name:      "fractional rate",
+			profile:   constantRate(0.2),
+			emissions: 2,
+			timeout:   500 * time.Millisecond,
+			wantErr:   true,
+			want: func(i int) (time.Duration, time.Duration) {
+				return 0, pacerSlack
+			},
+		},
+		{
+			name:      "jitter",
+			profile:   constantRate(100),
+			jitter:    0.5,
+			emissions: 20,
+			want: func(i int) (time.Duration, time.Duration) {
+				at := time.Duration(i) * 10 * time.Millisecond
+				return at / 2, at*3/2 + pacerSlack
+			},
+		},
+		{
+			name:      "burst clamped to one second",
+			profile:   constantRate(100),
+			emissions: 121,
+			behind:    5 * time.Second,
+			want: func(i int) (time.Duration, time.Duration) {
+				// One emission catches up, the bucket holds one second worth of the others
+				at := time.Duration(max(0, i-101)) * 10 * time.Millisecond
+				return at, at + pacerSlack
+			},
+		},
+		{
+			name: "pause at rate 0",
+			profile: profileFunc(func(elapsed time.Duration) float64 {
+				if elapsed >= 45*time.Millisecond && elapsed < 300*time.Millisecond {
+					return 0
+				}
+				return 100
+			}),
+			emissions: 8,
+			want: func(i int) (time.Duration, time.Duration) {
+				if i < 5 {
+					at := time.Duration(i) * 10 * time.Millisecond
+					return at, at + pacerSlack
+				}
+				// The pacer checks the profile again after sleeping at most a second and
+				// resumes evenly instead of catching up on the pause
+				at := time.Duration(i-5) * 10 * time.Millisecond
+				return 300*time.Millisecond + at, maxSleep + 100*time.Millisecond + at
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			p, err := NewPacer(tt.profile, tt.jitter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				start    time.Time
+				arrivals []time.Duration
+			)
+			for i := 0; i < tt.emissions; i++ {
+				if _, err = p.Wait(ctx); err != nil {
+					break
+				}
+				if i == 0 {
+					start = time.Now()
+					p.last = p.last.Add(-tt.behind)
+				}
+				arrivals = append(arrivals, time.Since(start))
+			}
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("got error %v after %d emissions, want error %t", err, len(arrivals), tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+			}
+
+			for i, at := range arrivals {
+				earliest, latest := tt.want(i)
+				// Timers fire late but never early
+				if at < earliest-time.Millisecond || at > latest {
+					t.Errorf("emission %d arrived after %s, want between %s and %s", i, at, earliest, latest)
+				}
+			}
+		})
+	}
+}
+
+func TestPacerLag(t *testing.T) {
+	p, err := NewPacer(constantRate(100), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if rate := p.Rate(); rate != 100 {
+		t.Errorf("got rate %g, want 100", rate)
+	}
+
+	p.last = p.last.Add(-5 * time.Second)
+	lag, err := p.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lag < 4*time.Second || lag > 5*time.Second {
+		t.Errorf("got lag %s, want the emission to start about 5s behind schedule", lag)
+	}
+}
@@ -0,0 +1,251 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ProfileType describes the shape of the rate over the course of a load profile phase
+type ProfileType string
+
+const (
+	// ConstantProfileType keeps the rate at a fixed value
+	ConstantProfileType ProfileType = "constant"
+
+	// RampProfileType changes the rate linearly from one value to another
+	RampProfileType ProfileType = "ramp"
+
+	// StepProfileType increases the rate by a fixed amount in regular intervals
+	StepProfileType ProfileType = "step"
+
+	// SineProfileType oscillates the rate around a mean value
+	SineProfileType ProfileType = "sine"
+
+	// BurstProfileType raises the rate to a burst rate periodically
+	BurstProfileType ProfileType = "burst"
+)
+
+// Profile describes the target rate of logs per second over the course of a run
+type Profile interface {
+	// Rate returns the target rate at the given time since the start of the run
+	Rate(elapsed time.Duration) float64
+}
+
+// ProfilePhase describes a single phase of a load profile
+type ProfilePhase struct {
+	Type ProfileType `yaml:"type"`
+	// Duration is the length of the phase. A phase without duration lasts forever.
+	Duration time.Duration `yaml:"duration"`
+	// Rate is the rate of constant phases, the initial rate of step phases and
+	// the base rate of sine and burst phases
+	Rate float64 `yaml:"rate"`
+	// From and To are the initial and final rates of ramp phases. To also caps
+	// the rate of step phases if set.
+	From float64 `yaml:"from"`
+	To   float64 `yaml:"to"`
+	// Step is the amount the rate increases by every interval in step phases
+	Step float64 `yaml:"step"`
+	// Interval is the time between two steps or two bursts
+	Interval time.Duration `yaml:"interval"`
+	// Amplitude and Period describe the oscillation of sine phases
+	Amplitude float64       `yaml:"amplitude"`
+	Period    time.Duration `yaml:"period"`
+	// BurstRate and BurstDuration describe the bursts of burst phases
+	BurstRate     float64       `yaml:"burstRate"`
+	BurstDuration time.Duration `yaml:"burstDuration"`
+}
+
+// ProfileFile describes the content of a load profile file
+type ProfileFile struct {
+	Phases []ProfilePhase `yaml:"phases"`
+}
+
+// phasedProfile runs the phases of a load profile one after another. The final
+// rate of the last phase is kept once all phases are complete.
+type phasedProfile []ProfilePhase
+
+// scaledProfile multiplies the rate of another profile by a constant factor
+type scaledProfile struct {
+	profile Profile
+	factor  float64
+}
+
+// NewProfile creates a load profile from a profile file or a profile specification.
+// A specification is a semicolon separated list of phases in the form
+// "type:key=value,...", e.g. "ramp:from=100,to=10000,duration=30m". Phases in a
+// specification default to the given rate. Without either a constant profile at
+// the given rate is returned.
+func NewProfile(spec, file string, rate float64) (Profile, error) {
+	if rate < 0 {
+		return nil, fmt.Errorf("invalid rate %g: must not be negative", rate)
+	}
+
+	var phases []ProfilePhase
+
+	switch {
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading load profile file %s: %s", file, err)
+		}
+
+		var pf ProfileFile
+		if err := yaml.UnmarshalStrict(data, &pf); err != nil {
+			return nil, fmt.Errorf("error parsing load profile file %s: %s", file, err)
+		}
+		phases = pf.Phases
+	case spec != "":
+		for _, s := range strings.Split(spec, ";") {
+			phase, err := parseProfilePhase(s, rate)
+			if err != nil {
+				return nil, err
+			}
+			phases = append(phases, phase)
+		}
+	default:
+		phases = []ProfilePhase{{Type: ConstantProfileType, Rate: rate}}
+	}
+
+	if len(phases) == 0 {
+		return nil, fmt.Errorf("load profile has no phases")
+	}
+	for i, phase := range phases {
+		if err := phase.validate(); err != nil {
+			return nil, fmt.Errorf("invalid load profile phase %d: %s", i+1, err)
+		}
+	}
+
+	return phasedProfile(phases), nil
+}
+
+// ScaleProfile returns a profile with the rate of the given profile multiplied by factor
+func ScaleProfile(profile Profile, factor float64) Profile {
+	return scaledProfile{profile: profile, factor: factor}
+}
+
+func (p scaledProfile) Rate(elapsed time.Duration) float64 {
+	return p.profile.Rate(elapsed) * p.factor
+}
+
+func (p phasedProfile) Rate(elapsed time.Duration) float64 {
+	for i, phase := range p {
+		if phase.Duration == 0 || elapsed < phase.Duration || i == len(p)-1 {
+			if phase.Duration > 0 && elapsed > phase.Duration {
+				elapsed = phase.Duration
+			}
+			return math.Max(0, phase.rate(elapsed))
+		}
+		elapsed -= phase.Duration
+	}
+	return 0
+}
+
+func (p ProfilePhase) rate(elapsed time.Duration) float64 {
+	switch p.Type {
+	case RampProfileType:
+		progress := math.Min(1, float64(elapsed)/float64(p.Duration))
+		return p.From + (p.To-p.From)*progress
+	case StepProfileType:
+		rate := p.Rate + p.Step*math.Floor(float64(elapsed)/float64(p.Interval))
+		if p.To > 0 && rate > p.To {
+			rate = p.To
+		}
+		return rate
+	case SineProfileType:
+		return p.Rate + p.Amplitude*math.Sin(2*math.Pi*float64(elapsed)/float64(p.Period))
+	case BurstProfileType:
+		if elapsed%p.Interval < p.BurstDuration {
+			return p.BurstRate
+		}
+		return p.Rate
+	default:
+		return p.Rate
+	}
+}
+
+func (p ProfilePhase) validate() error {
+	if p.Duration < 0 {
+		return fmt.Errorf("duration must not be negative")
+	}
+	// Sine phases may dip below zero, the rate is clamped to zero then
+	if p.Rate < 0 || p.From < 0 || p.To < 0 || p.BurstRate < 0 {
+		return fmt.Errorf("rates must not be negative")
+	}
+
+	switch p.Type {
+	case ConstantProfileType:
+	case RampProfileType:
+		if p.Duration == 0 {
+			return fmt.Errorf("ramp requires a duration")
+		}
+	case StepProfileType:
+		if p.Interval <= 0 {
+			return fmt.Errorf("step requires an interval")
+		}
+	case SineProfileType:
+		if p.Period <= 0 {
+			return fmt.Errorf("sine requires a period")
+		}
+	case BurstProfileType:
+		if p.Interval <= 0 || p.BurstDuration <= 0 {
+			return fmt.Errorf("burst requires an interval and a burst duration")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", p.Type)
+	}
+	return nil
+}
+
+func parseProfilePhase(spec string, rate float64) (ProfilePhase, error) {
+	kind, args, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	phase := ProfilePhase{
+		Type: ProfileType(kind),
+		Rate: rate,
+	}
+	if args == "" {
+		return phase, nil
+	}
+
+	for _, arg := range strings.Split(args, ",") {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return phase, fmt.Errorf("invalid load profile argument %q: expected key=value", arg)
+		}
+
+		var err error
+		switch strings.TrimSpace(key) {
+		case "duration":
+			phase.Duration, err = time.ParseDuration(value)
+		case "rate":
+			phase.Rate, err = strconv.ParseFloat(value, 64)
+		case "from":
+			phase.From, err = strconv.ParseFloat(value, 64)
+		case "to":
+			phase.To, err = strconv.ParseFloat(value, 64)
+		case "step":
+			phase.Step, err = strconv.ParseFloat(value, 64)
+		case "interval":
+			phase.Interval, err = time.ParseDuration(value)
+		case "amplitude":
+			phase.Amplitude, err = strconv.ParseFloat(value, 64)
+		case "period":
+			phase.Period, err = time.ParseDuration(value)
+		case "burstRate":
+			phase.BurstRate, err = strconv.ParseFloat(value, 64)
+		case "burstDuration":
+			phase.BurstDuration, err = time.ParseDuration(value)
+		default:
+			return phase, fmt.Errorf("unknown load profile argument %q", key)
+		}
+		if err != nil {
+			return phase, fmt.Errorf("invalid load profile argument %q: %s", arg, err)
+		}
+	}
+	return phase, nil
+}
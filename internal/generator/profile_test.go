@@ -0,0 +1,58 @@
+package generator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		rate    float64
+		wantErr bool
+		// rates maps the elapsed time to the rate expected then
+		rates map[time.Duration]float64
+	}{
+		{
+			name:  "constant",
+			rate:  10,
+			rates: map[time.Duration]float64{0: 10, time.Hour: 10},
+		},
+		{
+			name:  "ramp then constant",
+			spec:  "ramp:from=0,to=100,duration=10s;constant:rate=50",
+			rates: map[time.Duration]float64{0: 0, 5 * time.Second: 50, 10 * time.Second: 50, time.Hour: 50},
+		},
+		{
+			name:  "sine clamped to zero",
+			spec:  "sine:rate=10,amplitude=20,period=4s",
+			rates: map[time.Duration]float64{0: 10, 3 * time.Second: 0},
+		},
+		{name: "negative rate", rate: -1, wantErr: true},
+		{name: "negative phase rate", spec: "constant:rate=-5", wantErr: true},
+		{name: "ramp without duration", spec: "ramp:from=0,to=100", wantErr: true},
+		{name: "unknown type", spec: "square:rate=1", wantErr: true},
+		{name: "invalid argument", spec: "constant:rate", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := NewProfile(tt.spec, "", tt.rate)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for elapsed, want := range tt.rates {
+				if got := profile.Rate(elapsed); got != want {
+					t.Errorf("rate after %s: got %g, want %g", elapsed, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// worker generates a share of the logs with its own sequence and hostname
+type worker struct {
+	id          string
+	pacer       *Pacer
+	host        string
+	logHostname string
+}
+
+func (g *LogGenerator) runWorker(ctx context.Context, w *worker) {
+	var (
+		lineCount    int64
+		windowCount  int
+		windowStart  = time.Now()
+		logCount     = g.logCount.WithLabelValues(w.id)
+		byteCount    = g.byteCount.WithLabelValues(w.id)
+		errorCount   = g.errorCount.WithLabelValues(g.destination, w.id)
+		achievedRate = g.achievedRate.WithLabelValues(w.id)
+		scheduleLag  = g.scheduleLag.WithLabelValues(w.id)
+		// unwritten is set while the observed line failed to be written
+		unwritten bool
+	)
+	defer func() {
+		if unwritten {
+			g.opts.Observer.Forget(w.logHostname, lineCount)
+		}
+	}()
+
+	for {
+		if g.opts.MaxBytes > 0 && g.bytes.Load() >= g.opts.MaxBytes {
+			log.Debugf("Stopping log generator worker %s after %d bytes", w.id, g.bytes.Load())
+			return
+		}
+
+		lag, err := w.pacer.Wait(ctx)
+		if err != nil {
+			log.Debugf("Shutting down log generator worker %s...", w.id)
+			return
+		}
+		scheduleLag.Observe(lag.Seconds())
+
+		// Reserve the line up front to not exceed the limit across workers
+		if g.opts.MaxLines > 0 && g.reserved.Add(1) > g.opts.MaxLines {
+			log.Debugf("Stopping log generator worker %s after %d lines", w.id, g.opts.MaxLines)
+			return
+		}
+
+		logLine, err := RandomLog(LogType(g.opts.LogType), g.opts.SyntheticPayloadSize, g.opts.MultilineFrameDepth)
+		if err != nil {
+			log.Fatalf("error creating log: %s", err)
+		}
+		if g.opts.RunID != "" {
+			logLine = fmt.Sprintf("%s %s", g.opts.RunID, logLine)
+		}
+
+		formattedLogLine, err := FormatLog(Format(g.opts.LogFormat), w.logHostname, lineCount, logLine, g.opts.CRIOPartialSize)
+		if err != nil {
+			log.Fatalf("error formating log: %s", err)
+		}
+
+		if g.opts.Observer != nil {
+			g.opts.Observer.Observe(w.logHostname, lineCount, time.Now())
+		}
+
+		err = g.writeToDestination(w.id, w.host, formattedLogLine, LabelSetOptions(g.opts.LabelType))
+		if err != nil {
+			log.Errorf("error writing log: %s", err)
+			errorCount.Inc()
+			g.errors.Add(1)
+			g.reserved.Add(-1)
+			// The line is retried with the same message count
+			unwritten = g.opts.Observer != nil
+			continue
+		}
+		unwritten = false
+
+		logCount.Inc()
+		byteCount.Add(float64(len(formattedLogLine)))
+		g.lines.Add(1)
+		g.bytes.Add(int64(len(formattedLogLine)))
+		lineCount++
+
+		windowCount++
+		if elapsed := time.Since(windowStart); elapsed >= time.Second {
+			achievedRate.Set(float64(windowCount) / elapsed.Seconds())
+			windowStart = time.Now()
+			windowCount = 0
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package querier
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/grafana/loki/pkg/logproto"
+	"gopkg.in/yaml.v2"
+)
+
+// QueryOrder describes the order in which the queries of a query set are run
+type QueryOrder string
+
+const (
+	// RoundRobinQueryOrder runs the queries in turn, every query as often as its weight
+	// relative to the others, interleaved as evenly as possible
+	RoundRobinQueryOrder QueryOrder = "round-robin"
+
+	// WeightedRandomQueryOrder picks a random query for every run with a probability
+	// proportional to its weight
+	WeightedRandomQueryOrder QueryOrder = "weighted-random"
+)
+
+// QueryKind describes the Loki endpoint a query is sent to or the way an Elasticsearch
+// search is run
+type QueryKind string
+
+const (
+	// RangeQueryKind runs a log or metric query over the time window of the query
+	RangeQueryKind QueryKind = "range"
+
+	// InstantQueryKind runs a metric query at the end of the time window of the query
+	InstantQueryKind QueryKind = "instant"
+
+	// SeriesQueryKind lists the streams matching a stream selector
+	SeriesQueryKind QueryKind = "series"
+
+	// LabelsQueryKind lists the label names
+	LabelsQueryKind QueryKind = "labels"
+
+	// LabelValuesQueryKind lists the values of a label
+	LabelValuesQueryKind QueryKind = "label-values"
+
+	// TailQueryKind keeps a tail session open and measures the streaming latency
+	TailQueryKind QueryKind = "tail"
+
+	// ScrollQueryKind pages through the results of an Elasticsearch search with a scroll
+	ScrollQueryKind QueryKind = "scroll"
+
+	// SearchAfterQueryKind pages through the results of an Elasticsearch search with
+	// search_after
+	SearchAfterQueryKind QueryKind = "search-after"
+
+	// AggregationQueryKind runs the aggregations of an Elasticsearch search
+	AggregationQueryKind QueryKind = "aggregation"
+)
+
+const (
+	// defaultTailDuration is how long tail sessions stay open by default
+	defaultTailDuration = 1 * time.Minute
+
+	// defaultPageSize is the number of documents read per page by default
+	defaultPageSize = 1000
+
+	// defaultKeepAlive is how long the search context of a scroll is kept by default
+	defaultKeepAlive = 1 * time.Minute
+)
+
+var (
+	// lokiQueryKinds are the kinds of queries supported by Loki
+	lokiQueryKinds = map[QueryKind]bool{
+		RangeQueryKind:       true,
+		InstantQueryKind:     true,
+		SeriesQueryKind:      true,
+		LabelsQueryKind:      true,
+		LabelValuesQueryKind: true,
+		TailQueryKind:        true,
+	}
+
+	// elasticsearchQueryKinds are the kinds of queries supported by Elasticsearch
+	elasticsearchQueryKinds = map[QueryKind]bool{
+		RangeQueryKind:       true,
+		ScrollQueryKind:      true,
+		SearchAfterQueryKind: true,
+		AggregationQueryKind: true,
+	}
+)
+
+// Query describes a single query of a query set
+type Query struct {
+	// Name identifies the query in metrics, defaults to query-<position>
+	Name string `yaml:"name"`
+	// Query is the LogQL or Elasticsearch query
+	Query string `yaml:"query"`
+	// Weight is the share of runs of the query relative to the others, defaults to 1
+	Weight float64 `yaml:"weight"`
+	// Range is the length of the time window Loki queries cover, defaults to the
+	// query range of the querier
+	Range time.Duration `yaml:"range"`
+	// Offset is how long before now the time window ends, either a duration or a range
+	// of durations an offset is picked from at random for every run, e.g. 0-1h
+	Offset string `yaml:"offset"`
+	// Kind is the Loki endpoint the query is sent to or the way an Elasticsearch search
+	// is run, defaults to range
+	Kind QueryKind `yaml:"kind"`
+	// Label is the label whose values are listed by label-values queries
+	Label string `yaml:"label"`
+	// Limit and Direction override the limit and the order of the log lines returned
+	// by range, instant and tail queries. Limit also caps the documents read by scroll
+	// and search-after queries.
+	Limit     int    `yaml:"limit"`
+	Direction string `yaml:"direction"`
+	// Step is the resolution of range metric queries, Interval the spacing of the log
+	// lines returned by range log queries or the interval of the date histogram of
+	// aggregation queries
+	Step     time.Duration `yaml:"step"`
+	Interval time.Duration `yaml:"interval"`
+	// PageSize is the number of documents read per page by scroll and search-after
+	// queries, which read up to the limit, defaults to 1000
+	PageSize int `yaml:"pageSize"`
+	// KeepAlive is how long the search context of scroll queries and the point in time
+	// of search-after queries are kept, defaults to 1m
+	KeepAlive time.Duration `yaml:"keepAlive"`
+	// Terms are the fields of the nested terms aggregations of aggregation queries
+	// defining no aggregations of their own
+	Terms []string `yaml:"terms"`
+	// Duration is how long tail sessions stay open, defaults to 1m
+	Duration time.Duration `yaml:"duration"`
+	// Expect describes the checks run on the results of the query
+	Expect Expectation `yaml:"expect"`
+
+	template *template.Template
+	window   window
+}
+
+// UnmarshalYAML accepts queries given as plain strings as well as mappings
+func (q *Query) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var query string
+	if err := unmarshal(&query); err == nil {
+		*q = Query{Query: query}
+		return nil
+	}
+
+	type plain Query
+	return unmarshal((*plain)(q))
+}
+
+// QuerySet picks the next query to run from a list of queries
+type QuerySet struct {
+	queries []Query
+	order   QueryOrder
+	total   float64
+
+	mu      sync.Mutex
+	current []float64
+}
+
+// LoadQueries reads a YAML list of queries, each either a plain query string or a
+// mapping with the fields of a query
+func LoadQueries(file string) ([]Query, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read query file: %s", err)
+	}
+
+	var queries []Query
+	if err := yaml.UnmarshalStrict(data, &queries); err != nil {
+		return nil, fmt.Errorf("invalid query file %s: %s", file, err)
+	}
+	return queries, nil
+}
+
+// NewQuerySet validates the queries, fills in the defaults and creates a query set
+// running them in the given order
+func NewQuerySet(queries []Query, order QueryOrder) (*QuerySet, error) {
+	switch order {
+	case RoundRobinQueryOrder, WeightedRandomQueryOrder:
+	default:
+		return nil, fmt.Errorf("unknown query order: %s", order)
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("no queries given")
+	}
+
+	s := &QuerySet{
+		order:   order,
+		current: make([]float64, len(queries)),
+	}
+	names := map[string]bool{}
+	for i, q := range queries {
+		if q.Name == "" {
+			q.Name = fmt.Sprintf("query-%d", i)
+		}
+		if names[q.Name] {
+			return nil, fmt.Errorf("duplicate query name %q", q.Name)
+		}
+		names[q.Name] = true
+
+		if err := q.validate(); err != nil {
+			return nil, err
+		}
+		if err := q.parseTemplate(); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case q.Weight < 0:
+			return nil, fmt.Errorf("invalid weight %g of query %q: must not be negative", q.Weight, q.Name)
+		case q.Weight == 0:
+			q.Weight = 1
+		}
+		s.queries = append(s.queries, q)
+		s.total += q.Weight
+	}
+	return s, nil
+}
+
+// validate checks the kind and the settings of the query and fills in the defaults
+func (q *Query) validate() error {
+	if q.Kind == "" {
+		q.Kind = RangeQueryKind
+	}
+	if !lokiQueryKinds[q.Kind] && !elasticsearchQueryKinds[q.Kind] {
+		return fmt.Errorf("unknown kind %s of query %s", q.Kind, q.Name)
+	}
+	if q.Kind == LabelValuesQueryKind && q.Label == "" {
+		return fmt.Errorf("query %s lists label values but sets no label", q.Name)
+	}
+
+	if q.Query == "" && q.Kind != LabelsQueryKind && q.Kind != LabelValuesQueryKind {
+		return fmt.Errorf("query %s is empty", q.Name)
+	}
+	if q.Limit < 0 || q.Step < 0 || q.Interval < 0 || q.Duration < 0 || q.PageSize < 0 || q.KeepAlive < 0 {
+		return fmt.Errorf("invalid query %s: limit, step, interval, duration, page size and keep alive must not be negative", q.Name)
+	}
+	if q.Direction != "" {
+		if _, err := parseDirection(q.Direction); err != nil {
+			return fmt.Errorf("invalid query %s: %s", q.Name, err)
+		}
+	}
+	if q.Expect.MinResults != nil && q.Expect.Results != nil {
+		return fmt.Errorf("query %s expects both a minimum and an exact number of results", q.Name)
+	}
+	if q.Kind == TailQueryKind && q.Duration == 0 {
+		q.Duration = defaultTailDuration
+	}
+	if q.PageSize == 0 {
+		q.PageSize = defaultPageSize
+	}
+	if q.KeepAlive == 0 {
+		q.KeepAlive = defaultKeepAlive
+	}
+	return nil
+}
+
+// parseDirection parses the order log lines are returned in, forward or backward
+func parseDirection(direction string) (logproto.Direction, error) {
+	switch strings.ToLower(direction) {
+	case "forward":
+		return logproto.FORWARD, nil
+	case "backward":
+		return logproto.BACKWARD, nil
+	default:
+		return logproto.FORWARD, fmt.Errorf("unknown direction: %s", direction)
+	}
+}
+
+// Queries returns the queries of the set
+func (s *QuerySet) Queries() []Query {
+	return s.queries
+}
+
+// Next returns the next query to run
+func (s *QuerySet) Next() Query {
+	if s.order == WeightedRandomQueryOrder {
+		pick := rand.Float64() * s.total
+		for _, q := range s.queries {
+			if pick < q.Weight {
+				return q
+			}
+			pick -= q.Weight
+		}
+		return s.queries[len(s.queries)-1]
+	}
+
+	// Smooth weighted round-robin: every query gains its weight, the query ahead
+	// runs and falls back by the total weight
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	best := 0
+	for i, q := range s.queries {
+		s.current[i] += q.Weight
+		if s.current[i] > s.current[best] {
+			best = i
+		}
+	}
+	s.current[best] -= s.total
+	return s.queries[best]
+}
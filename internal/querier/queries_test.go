@@ -0,0 +1,149 @@
+package querier
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuerySetNext(t *testing.T) {
+	tests := []struct {
+		name    string
+		order   QueryOrder
+		weights map[string]float64
+		runs    int
+		// want is the sequence of queries run in round-robin order
+		want []string
+	}{
+		{
+			name:    "round-robin equal weights",
+			order:   RoundRobinQueryOrder,
+			weights: map[string]float64{"a": 1, "b": 1, "c": 1},
+			runs:    6,
+			want:    []string{"a", "b", "c", "a", "b", "c"},
+		},
+		{
+			name:    "round-robin interleaves weights",
+			order:   RoundRobinQueryOrder,
+			weights: map[string]float64{"a": 2, "b": 1},
+			runs:    6,
+			want:    []string{"a", "b", "a", "a", "b", "a"},
+		},
+		{
+			name:    "round-robin default weight",
+			order:   RoundRobinQueryOrder,
+			weights: map[string]float64{"a": 0, "b": 3},
+			runs:    4,
+			want:    []string{"b", "a", "b", "b"},
+		},
+		{
+			name:    "weighted random",
+			order:   WeightedRandomQueryOrder,
+			weights: map[string]float64{"a": 3, "b": 1},
+			runs:    10000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queries []Query
+			for _, name := range []string{"a", "b", "c"} {
+				if weight, ok := tt.weights[name]; ok {
+					queries = append(queries, Query{Name: name, Query: `{client="promtail"}`, Weight: weight})
+				}
+			}
+			s, err := NewQuerySet(queries, tt.order)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			counts := map[string]int{}
+			for i := 0; i < tt.runs; i++ {
+				name := s.Next().Name
+				got = append(got, name)
+				counts[name]++
+			}
+
+			if tt.want != nil {
+				if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+					t.Errorf("got queries %v, want %v", got, tt.want)
+				}
+				return
+			}
+			var total float64
+			for _, weight := range tt.weights {
+				total += weight
+			}
+			for name, weight := range tt.weights {
+				share := float64(counts[name]) / float64(tt.runs)
+				if math.Abs(share-weight/total) > 0.05 {
+					t.Errorf("query %s ran %d of %d times, want a share of %g", name, counts[name], tt.runs, weight/total)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryValidate(t *testing.T) {
+	one := 1
+	tests := []struct {
+		name    string
+		query   Query
+		want    Query
+		wantErr bool
+	}{
+		{
+			name:  "defaults",
+			query: Query{Query: `{client="promtail"}`},
+			want:  Query{Query: `{client="promtail"}`, Kind: RangeQueryKind, PageSize: defaultPageSize, KeepAlive: defaultKeepAlive},
+		},
+		{
+			name:  "tail duration",
+			query: Query{Query: `{client="promtail"}`, Kind: TailQueryKind},
+			want: Query{Query: `{client="promtail"}`, Kind: TailQueryKind, Duration: defaultTailDuration,
+				PageSize: defaultPageSize, KeepAlive: defaultKeepAlive},
+		},
+		{
+			name:  "settings kept",
+			query: Query{Query: `{"query":{}}`, Kind: ScrollQueryKind, PageSize: 10, KeepAlive: time.Second},
+			want:  Query{Query: `{"query":{}}`, Kind: ScrollQueryKind, PageSize: 10, KeepAlive: time.Second},
+		},
+		{
+			name:  "labels without query",
+			query: Query{Kind: LabelsQueryKind},
+			want:  Query{Kind: LabelsQueryKind, PageSize: defaultPageSize, KeepAlive: defaultKeepAlive},
+		},
+		{name: "unknown kind", query: Query{Query: "q", Kind: "stream"}, wantErr: true},
+		{name: "label values without label", query: Query{Kind: LabelValuesQueryKind}, wantErr: true},
+		{name: "empty query", query: Query{Kind: RangeQueryKind}, wantErr: true},
+		{name: "negative limit", query: Query{Query: "q", Limit: -1}, wantErr: true},
+		{name: "negative page size", query: Query{Query: "q", PageSize: -1}, wantErr: true},
+		{name: "unknown direction", query: Query{Query: "q", Direction: "sideways"}, wantErr: true},
+		{
+			name:    "minimum and exact results",
+			query:   Query{Query: "q", Expect: Expectation{MinResults: &one, Results: &one}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.query.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := tt.query
+			if got.Kind != tt.want.Kind || got.Duration != tt.want.Duration ||
+				got.PageSize != tt.want.PageSize || got.KeepAlive != tt.want.KeepAlive {
+				t.Errorf("got kind %s, duration %s, page size %d, keep alive %s, want %s, %s, %d, %s",
+					got.Kind, got.Duration, got.PageSize, got.KeepAlive,
+					tt.want.Kind, tt.want.Duration, tt.want.PageSize, tt.want.KeepAlive)
+			}
+		})
+	}
+}
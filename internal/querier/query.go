@@ -1,13 +1,22 @@
 package querier
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/ViaQ/cluster-logging-load-client/internal/clients"
+	"github.com/ViaQ/cluster-logging-load-client/internal/generator"
 
-	"github.com/elastic/go-elasticsearch/v6"
 	logcli "github.com/grafana/loki/pkg/logcli/client"
+	"github.com/grafana/loki/pkg/loghttp"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -34,35 +43,253 @@ type Options struct {
 	DisableSecurityCheck bool
 	// QueriesPerMinute is the number of queries to launch per minute
 	QueriesPerMinute int
+	// Workers is the number of queries run concurrently at most
+	Workers int
 	// QueryRange is the range over which LogCLI will query against
 	QueryRange string
+	// Queries are the queries run once the querier is started
+	Queries *QuerySet
+	// Hostnames are the hostnames logs are written with, the {{.Hostname}} of query
+	// templates is picked from them at random
+	Hostnames []string
+	// Limit and Direction are the limit and the order of the log lines returned by
+	// Loki queries which set none of their own, Limit also caps the documents read by
+	// paged Elasticsearch queries
+	Limit     int
+	Direction string
+	// LogFormat is the format the log lines were written in
+	LogFormat string
+	// ValidateResults checks the format, the timestamps and the labels of the log lines
+	// returned by all queries
+	ValidateResults bool
+	// FailOnInvalidResults stops the querier with an error once a check fails
+	FailOnInvalidResults bool
+	// ElasticsearchBackend is the backend of the Elasticsearch cluster, detected if "auto"
+	ElasticsearchBackend string
+	// ElasticsearchUsername and ElasticsearchPassword are used for basic authentication
+	ElasticsearchUsername string
+	ElasticsearchPassword string
+	// ElasticsearchAPIKey is the base64 encoded API key to authenticate with
+	ElasticsearchAPIKey string
+	// ElasticsearchIndex is the index, index prefix, data stream or alias logs are queried from
+	ElasticsearchIndex string
+	// ElasticsearchIndexStrategy is the strategy naming the indices logs are queried from
+	ElasticsearchIndexStrategy string
+	// TLSCAFile, TLSCertFile and TLSKeyFile configure the TLS connections to Elasticsearch
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
+// Entry describes a single log line returned by a query
+type Entry struct {
+	// ID identifies the stream or document the entry belongs to
+	ID string
+	// Labels are the stream labels or the label fields of the document
+	Labels    map[string]string
+	Timestamp time.Time
+	Line      string
 }
 
 // LogQuerier describes an object which queries for logs
 type LogQuerier struct {
-	elasticsearchClient *elasticsearch.Client
+	elasticsearchClient *clients.ElasticsearchClient
+	elasticsearchIndex  string
 	logCLIClient        *logcli.DefaultClient
 	rate                int
-	queryFrom           func(string) error
+	workers             int
+	queries             *QuerySet
+	hostnames           []string
+	limit               int
+	direction           logproto.Direction
+	queryFrom           func(context.Context, request) (clients.QueryResult, error)
+	fetchFrom           func(string, time.Time, time.Time, int) ([]Entry, error)
 	queryRange          time.Duration
+	queryCount          *prometheus.CounterVec
+	queryDuration       *prometheus.HistogramVec
+	execDuration        *prometheus.HistogramVec
+	resultCount         *prometheus.CounterVec
+	bytesProcessed      *prometheus.CounterVec
+	linesProcessed      *prometheus.CounterVec
+	errorCount          *prometheus.CounterVec
+	scheduleLag         prometheus.Histogram
+	inFlight            prometheus.Gauge
+	tailLatency         *prometheus.HistogramVec
+	pageDuration        *prometheus.HistogramVec
+	documentsRead       *prometheus.CounterVec
+	logFormat           generator.Format
+	validateResults     bool
+	failOnInvalid       bool
+	validationFailures  *prometheus.CounterVec
+	windowless          map[string]bool
+	errCh               chan<- error
+	failOnce            sync.Once
+}
+
+// queryJob is a query due at a scheduled time
+type queryJob struct {
+	query     Query
+	scheduled time.Time
+}
+
+// request is a query rendered for a single run
+type request struct {
+	Query
+	// text is the rendered query, start and end the time window it covers
+	text  string
+	start time.Time
+	end   time.Time
 }
 
 // NewLogQuerier creates a new querier object
-func NewLogQuerier(opts Options) (*LogQuerier, error) {
+func NewLogQuerier(opts Options, registry *prometheus.Registry) (*LogQuerier, error) {
+	if opts.Queries != nil {
+		if opts.QueriesPerMinute <= 0 {
+			return nil, fmt.Errorf("invalid queries per minute %d: must be positive", opts.QueriesPerMinute)
+		}
+		if opts.Workers <= 0 {
+			return nil, fmt.Errorf("invalid number of query workers %d: must be positive", opts.Workers)
+		}
+	}
+	direction, err := parseDirection(opts.Direction)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Queries != nil && len(opts.Hostnames) == 0 {
+		return nil, fmt.Errorf("no hostnames to render queries with")
+	}
+	if opts.Queries != nil && generator.Format(opts.LogFormat) == generator.RawFormat {
+		for _, query := range opts.Queries.Queries() {
+			if opts.ValidateResults || query.Expect.Format {
+				return nil, fmt.Errorf("the format of raw formatted logs can not be validated")
+			}
+		}
+	}
+
 	querier := LogQuerier{
-		rate: opts.QueriesPerMinute,
+		rate:      opts.QueriesPerMinute,
+		workers:   opts.Workers,
+		queries:   opts.Queries,
+		hostnames: opts.Hostnames,
+		limit:     opts.Limit,
+		direction: direction,
+
+		windowless:      map[string]bool{},
+		logFormat:       generator.Format(opts.LogFormat),
+		validateResults: opts.ValidateResults,
+		failOnInvalid:   opts.FailOnInvalidResults,
+		queryCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "log_querier_queries_total",
+			Help: "Total number of queries run by the log querier",
+		}, []string{"query"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "log_querier_query_duration_seconds",
+			Help:    "Time spent running a query as seen by the log querier",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+		}, []string{"query"}),
+		execDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "log_querier_exec_duration_seconds",
+			Help:    "Time the backend reports to have spent executing a query",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+		}, []string{"query"}),
+		resultCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "log_querier_results_total",
+			Help: "Total number of log lines, samples or documents returned by queries",
+		}, []string{"query"}),
+		bytesProcessed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "log_querier_bytes_processed_total",
+			Help: "Total number of bytes the backend reports to have scanned for queries",
+		}, []string{"query"}),
+		linesProcessed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "log_querier_lines_processed_total",
+			Help: "Total number of lines the backend reports to have scanned for queries",
+		}, []string{"query"}),
+		errorCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "log_querier_errors_total",
+			Help: "Total number of failed queries by status code class",
+		}, []string{"query", "status"}),
+		scheduleLag: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "log_querier_schedule_lag_seconds",
+			Help:    "Time queries started behind their schedule",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+		}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "log_querier_queries_in_flight",
+			Help: "Number of queries currently running",
+		}),
+		tailLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "log_querier_tail_latency_seconds",
+			Help:    "Time between the timestamp of a log line and its arrival in a tail session",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+		}, []string{"query"}),
+		pageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "log_querier_page_duration_seconds",
+			Help:    "Time spent reading a page of an Elasticsearch search",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+		}, []string{"query"}),
+		documentsRead: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "log_querier_documents_read_total",
+			Help: "Total number of documents read from Elasticsearch",
+		}, []string{"query"}),
+		validationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "log_querier_validation_failures_total",
+			Help: "Total number of query results failing a check by check",
+		}, []string{"query", "check"}),
 	}
 
+	registry.MustRegister(
+		querier.queryCount,
+		querier.queryDuration,
+		querier.execDuration,
+		querier.resultCount,
+		querier.bytesProcessed,
+		querier.linesProcessed,
+		querier.errorCount,
+		querier.scheduleLag,
+		querier.inFlight,
+		querier.tailLatency,
+		querier.pageDuration,
+		querier.documentsRead,
+		querier.validationFailures,
+	)
+
 	switch opts.Client {
 	case ElasticsearchClientType:
-		client, err := clients.NewElasticsearchClient(opts.ClientURL)
+		client, err := clients.NewElasticsearchClient(clients.ElasticsearchConfig{
+			URL:                  opts.ClientURL,
+			Backend:              clients.ElasticsearchBackend(opts.ElasticsearchBackend),
+			Username:             opts.ElasticsearchUsername,
+			Password:             opts.ElasticsearchPassword,
+			APIKey:               opts.ElasticsearchAPIKey,
+			DisableSecurityCheck: opts.DisableSecurityCheck,
+			TLS: clients.TLSConfig{
+				CAFile:   opts.TLSCAFile,
+				CertFile: opts.TLSCertFile,
+				KeyFile:  opts.TLSKeyFile,
+			},
+		})
 		if err != nil {
 			return nil, err
 		}
+		index := clients.ElasticsearchIndexConfig{
+			Name:     opts.ElasticsearchIndex,
+			Strategy: clients.IndexStrategy(opts.ElasticsearchIndexStrategy),
+		}
+		if err := index.Validate(); err != nil {
+			return nil, err
+		}
+		if err := checkQueryKinds(opts.Queries, elasticsearchQueryKinds, opts.Client); err != nil {
+			return nil, err
+		}
 
 		querier.elasticsearchClient = client
+		querier.elasticsearchIndex = index.Pattern()
 		querier.queryFrom = querier.queryElasticSearch
+		querier.fetchFrom = querier.fetchElasticSearch
 	case LokiClientType:
+		if err := checkQueryKinds(opts.Queries, lokiQueryKinds, opts.Client); err != nil {
+			return nil, err
+		}
 		client, err := clients.NewLogCLIClient(opts.ClientURL, opts.Tenant, opts.DisableSecurityCheck)
 		if err != nil {
 			return nil, err
@@ -72,39 +299,329 @@ func NewLogQuerier(opts Options) (*LogQuerier, error) {
 		if err != nil {
 			return nil, err
 		}
+		// The range reaches back from now, negative ranges are accepted for compatibility
+		if rangeDuration < 0 {
+			rangeDuration = -rangeDuration
+		}
 
 		querier.logCLIClient = client
 		querier.queryFrom = querier.queryLoki
+		querier.fetchFrom = querier.fetchLoki
 		querier.queryRange = rangeDuration
 	default:
 		return nil, fmt.Errorf("error client type: %s", opts.Client)
 	}
 
+	if opts.Queries != nil {
+		for _, query := range opts.Queries.Queries() {
+			if coversWindow(query, opts.Client) {
+				continue
+			}
+			if query.Expect.Window {
+				return nil, fmt.Errorf("query %s expects its log lines inside its time window but covers none", query.Name)
+			}
+			if opts.ValidateResults {
+				log.Warnf("skipping the window check of query %s, which covers no time window", query.Name)
+			}
+			querier.windowless[query.Name] = true
+		}
+	}
+
 	return &querier, nil
 }
 
-// QueryLogs indefinitely queries logs using the configured client
-func (q *LogQuerier) QueryLogs(query string) {
-	for {
-		next := time.Now().UTC().Add(1 * time.Minute)
+// coversWindow tells whether the log lines returned by the query fall inside its time
+// window, i.e. it is a range query and, for Elasticsearch, its template refers to the
+// window
+func coversWindow(query Query, client ClientType) bool {
+	if query.Kind != RangeQueryKind {
+		return false
+	}
+	return client != ElasticsearchClientType || query.refersToWindow()
+}
+
+// checkQueryKinds checks that the client supports the kinds of the queries
+func checkQueryKinds(queries *QuerySet, kinds map[QueryKind]bool, client ClientType) error {
+	if queries == nil {
+		return nil
+	}
+	for _, query := range queries.Queries() {
+		if !kinds[query.Kind] {
+			return fmt.Errorf("query kind %s of query %s is not supported by %s", query.Kind, query.Name, client)
+		}
+	}
+	return nil
+}
+
+// Start runs the queries of the querier until the context is done. Queries are
+// scheduled at a fixed rate regardless of how long previous queries take and run by a
+// pool of workers, which caps the number of queries in flight.
+func (q *LogQuerier) Start(ctx context.Context, wg *sync.WaitGroup, errCh chan<- error) {
+	q.errCh = errCh
+	jobs := make(chan queryJob, q.workers)
 
-		for i := 0; i < q.rate; i++ {
-			if err := q.queryFrom(query); err != nil {
-				log.Fatalf("error querying: %s", err)
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				q.runQuery(ctx, job)
 			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		q.schedule(ctx, jobs)
+		log.Debug("Shutting down log querier...")
+	}()
+}
+
+// schedule hands the queries to the workers at their scheduled time. Queries due while
+// all workers are busy are handed over as soon as a worker is available, keeping their
+// original schedule, so the time they started late is accounted for.
+func (q *LogQuerier) schedule(ctx context.Context, jobs chan<- queryJob) {
+	interval := time.Minute / time.Duration(q.rate)
+	next := time.Now()
+
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
 		}
 
-		current := time.Now().UTC()
-		if current.Before(next) {
-			time.Sleep(next.Sub(current))
+		select {
+		case <-ctx.Done():
+			return
+		case jobs <- queryJob{query: q.queries.Next(), scheduled: next}:
 		}
+		next = next.Add(interval)
 	}
 }
 
-func (q *LogQuerier) queryLoki(query string) error {
-	return clients.QueryLogsWithLogCLI(q.logCLIClient, query, q.queryRange)
+// runQuery runs a single query and records its outcome
+func (q *LogQuerier) runQuery(ctx context.Context, job queryJob) {
+	query := job.query
+	start := time.Now()
+	q.scheduleLag.Observe(start.Sub(job.scheduled).Seconds())
+
+	hostname := q.hostnames[rand.Intn(len(q.hostnames))]
+	text, from, to, err := query.render(start, q.queryRange, hostname)
+	if err != nil {
+		log.Errorf("error running query %s: %s", query.Name, err)
+		q.errorCount.WithLabelValues(query.Name, "error").Inc()
+		return
+	}
+
+	q.inFlight.Inc()
+	r := request{Query: query, text: text, start: from, end: to}
+	result, err := q.queryFrom(ctx, r)
+	duration := time.Since(start)
+	q.inFlight.Dec()
+
+	q.queryCount.WithLabelValues(query.Name).Inc()
+	// The duration of tail sessions is fixed, their latency is observed per log line
+	if query.Kind != TailQueryKind {
+		q.queryDuration.WithLabelValues(query.Name).Observe(duration.Seconds())
+	}
+	if err != nil {
+		status := "error"
+		var queryErr *clients.QueryError
+		if errors.As(err, &queryErr) && queryErr.StatusCode != 0 {
+			status = fmt.Sprintf("%dxx", queryErr.StatusCode/100)
+		}
+		log.Errorf("error running query %s (%s): %s", query.Name, status, err)
+		q.errorCount.WithLabelValues(query.Name, status).Inc()
+		return
+	}
+
+	q.resultCount.WithLabelValues(query.Name).Add(float64(result.Results))
+	q.bytesProcessed.WithLabelValues(query.Name).Add(float64(result.BytesProcessed))
+	q.linesProcessed.WithLabelValues(query.Name).Add(float64(result.LinesProcessed))
+	if result.ExecTime > 0 {
+		q.execDuration.WithLabelValues(query.Name).Observe(result.ExecTime.Seconds())
+	}
+
+	expect := query.Expect
+	if q.validateResults {
+		expect.Format, expect.Window, expect.Labels = true, !q.windowless[query.Name], true
+	}
+	if !expect.enabled() {
+		return
+	}
+
+	failures := q.validate(r, expect, result)
+	for _, f := range failures {
+		log.Errorf("invalid result of query %s: %s", query.Name, f)
+		q.validationFailures.WithLabelValues(query.Name, f.check).Inc()
+	}
+	if len(failures) > 0 && q.failOnInvalid {
+		q.fail(fmt.Errorf("invalid result of query %s: %s", query.Name, failures[0]))
+	}
+}
+
+// fail stops the run with an error, only the first error is reported
+func (q *LogQuerier) fail(err error) {
+	q.failOnce.Do(func() {
+		if q.errCh != nil {
+			q.errCh <- err
+		}
+	})
+}
+
+// queryLoki sends the query to the Loki endpoint of its kind
+func (q *LogQuerier) queryLoki(ctx context.Context, r request) (clients.QueryResult, error) {
+	query := clients.LokiQuery{
+		Query:     r.text,
+		Start:     r.start,
+		End:       r.end,
+		Limit:     q.limit,
+		Direction: q.direction,
+		Step:      r.Step,
+		Interval:  r.Interval,
+	}
+	if r.Limit > 0 {
+		query.Limit = r.Limit
+	}
+	if r.Direction != "" {
+		query.Direction, _ = parseDirection(r.Direction)
+	}
+
+	switch r.Kind {
+	case InstantQueryKind:
+		return clients.InstantQueryWithLogCLI(q.logCLIClient, query)
+	case SeriesQueryKind:
+		return clients.SeriesWithLogCLI(q.logCLIClient, query)
+	case LabelsQueryKind:
+		return clients.LabelsWithLogCLI(q.logCLIClient, query)
+	case LabelValuesQueryKind:
+		return clients.LabelValuesWithLogCLI(q.logCLIClient, r.Label, query)
+	case TailQueryKind:
+		latency := q.tailLatency.WithLabelValues(r.Name)
+		return clients.TailLogsWithLogCLI(ctx, q.logCLIClient, query, r.Duration, func(d time.Duration) {
+			latency.Observe(d.Seconds())
+		})
+	default:
+		return clients.QueryLogsWithLogCLI(q.logCLIClient, query)
+	}
+}
+
+// queryElasticSearch runs a search of the kind of the query. Searches carry their own
+// time range, query templates can refer to the time window of the query instead.
+func (q *LogQuerier) queryElasticSearch(ctx context.Context, r request) (clients.QueryResult, error) {
+	var (
+		pageDuration  = q.pageDuration.WithLabelValues(r.Name)
+		documentsRead = q.documentsRead.WithLabelValues(r.Name)
+	)
+	observe := func(duration time.Duration, documents int) {
+		pageDuration.Observe(duration.Seconds())
+		documentsRead.Add(float64(documents))
+	}
+
+	paging := clients.ElasticsearchPaging{
+		PageSize:  r.PageSize,
+		Limit:     q.limit,
+		KeepAlive: r.KeepAlive,
+	}
+	if r.Limit > 0 {
+		paging.Limit = r.Limit
+	}
+
+	switch r.Kind {
+	case ScrollQueryKind:
+		return clients.ScrollWithElasticsearch(ctx, q.elasticsearchClient, q.elasticsearchIndex, r.text, paging, observe)
+	case SearchAfterQueryKind:
+		return clients.SearchAfterWithElasticsearch(ctx, q.elasticsearchClient, q.elasticsearchIndex, r.text, paging, observe)
+	case AggregationQueryKind:
+		return clients.AggregateWithElasticsearch(q.elasticsearchClient, q.elasticsearchIndex, r.text, clients.ElasticsearchAggregation{
+			Terms:    r.Terms,
+			Interval: r.Interval,
+		})
+	default:
+		start := time.Now()
+		result, err := clients.QueryLogsWithElasticsearch(q.elasticsearchClient, q.elasticsearchIndex, r.text)
+		if err == nil {
+			observe(time.Since(start), len(result.Hits))
+		}
+		return result, err
+	}
+}
+
+// FetchLogs returns up to limit log lines matching the query. The time range is only
+// applied to Loki queries, Elasticsearch queries are expected to carry their own range
+// and are paged through with search_after in pages of limit documents, which returns all
+// documents matching. The created_at of documents is rounded to the second, paging by
+// time would not get past a second holding more documents than a page.
+func (q *LogQuerier) FetchLogs(query string, start, end time.Time, limit int) ([]Entry, error) {
+	return q.fetchFrom(query, start, end, limit)
+}
+
+func (q *LogQuerier) fetchLoki(query string, start, end time.Time, limit int) ([]Entry, error) {
+	streams, err := clients.FetchLogsWithLogCLI(q.logCLIClient, query, start, end, limit)
+	if err != nil {
+		return nil, err
+	}
+	return streamEntries(streams), nil
+}
+
+func (q *LogQuerier) fetchElasticSearch(query string, _, _ time.Time, limit int) ([]Entry, error) {
+	paging := clients.ElasticsearchPaging{
+		PageSize:  limit,
+		Limit:     math.MaxInt,
+		KeepAlive: defaultKeepAlive,
+	}
+	res, err := clients.SearchAfterWithElasticsearch(context.Background(), q.elasticsearchClient, q.elasticsearchIndex,
+		query, paging, func(time.Duration, int) {})
+	if err != nil {
+		return nil, err
+	}
+	return hitEntries(res.Hits)
 }
 
-func (q *LogQuerier) queryElasticSearch(query string) error {
-	return clients.QueryLogsWithElasticsearch(q.elasticsearchClient, clients.IndexName, query)
+// streamEntries returns the log lines of Loki streams
+func streamEntries(streams loghttp.Streams) []Entry {
+	var entries []Entry
+	for _, stream := range streams {
+		id := stream.Labels.String()
+		for _, e := range stream.Entries {
+			entries = append(entries, Entry{
+				ID:        id,
+				Labels:    stream.Labels,
+				Timestamp: e.Timestamp,
+				Line:      e.Line,
+			})
+		}
+	}
+	return entries
+}
+
+// hitEntries returns the log lines of Elasticsearch documents
+func hitEntries(hits []clients.SearchHit) ([]Entry, error) {
+	entries := make([]Entry, 0, len(hits))
+	for _, hit := range hits {
+		var content generator.ElasticsearchLogContent
+		if err := json.Unmarshal(hit.Source, &content); err != nil {
+			return nil, fmt.Errorf("error parsing document %s: %s", hit.ID, err)
+		}
+		entries = append(entries, Entry{
+			ID: hit.ID,
+			Labels: map[string]string{
+				"hostname":  content.Hostname,
+				"service":   content.Service,
+				"level":     content.Level,
+				"component": content.Component,
+			},
+			Timestamp: content.CreatedAt,
+			Line:      content.Body,
+		})
+	}
+	return entries, nil
 }
@@ -0,0 +1,250 @@
+package querier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ViaQ/cluster-logging-load-client/internal/clients"
+
+	"github.com/grafana/loki/pkg/loghttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// slowBackend answers queries after a delay and records when they started and how
+// many ran at once
+type slowBackend struct {
+	delay time.Duration
+
+	mu          sync.Mutex
+	starts      []time.Time
+	inFlight    int
+	maxInFlight int
+}
+
+func (b *slowBackend) query(ctx context.Context, _ request) (clients.QueryResult, error) {
+	b.mu.Lock()
+	b.starts = append(b.starts, time.Now())
+	b.inFlight++
+	b.maxInFlight = max(b.maxInFlight, b.inFlight)
+	b.mu.Unlock()
+
+	time.Sleep(b.delay)
+
+	b.mu.Lock()
+	b.inFlight--
+	b.mu.Unlock()
+	return clients.QueryResult{}, nil
+}
+
+func TestLogQuerierSchedule(t *testing.T) {
+	const (
+		// Ten queries per second are due every 100ms for half a second
+		queriesPerMinute = 600
+		run              = 550 * time.Millisecond
+		slack            = 50 * time.Millisecond
+	)
+
+	tests := []struct {
+		name    string
+		workers int
+		delay   time.Duration
+		// wantMaxInFlight is the number of queries expected to run at once at most,
+		// wantOnSchedule whether every query starts on its schedule and wantMinLag the
+		// sum of the schedule lags otherwise
+		wantMaxInFlight int
+		wantOnSchedule  bool
+		wantMinLag      time.Duration
+	}{
+		{
+			name:            "enough workers",
+			workers:         4,
+			delay:           250 * time.Millisecond,
+			wantMaxInFlight: 3,
+			wantOnSchedule:  true,
+		},
+		{
+			name:            "workers busy",
+			workers:         2,
+			delay:           250 * time.Millisecond,
+			wantMaxInFlight: 2,
+			// The queries due at 200ms, 300ms and 400ms start 50ms, 50ms and 100ms late
+			wantMinLag: 200 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries, err := NewQuerySet([]Query{{Name: "all", Query: `{client="promtail"}`}}, RoundRobinQueryOrder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			registry := prometheus.NewRegistry()
+			q, err := NewLogQuerier(Options{
+				Client:           LokiClientType,
+				ClientURL:        "http://localhost:3100",
+				QueriesPerMinute: queriesPerMinute,
+				Workers:          tt.workers,
+				QueryRange:       "1m",
+				Queries:          queries,
+				Hostnames:        []string{"host"},
+				Direction:        "backward",
+			}, registry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			backend := &slowBackend{delay: tt.delay}
+			q.queryFrom = backend.query
+
+			ctx, cancel := context.WithTimeout(context.Background(), run)
+			defer cancel()
+			var wg sync.WaitGroup
+			start := time.Now()
+			q.Start(ctx, &wg, make(chan error, 1))
+			wg.Wait()
+
+			backend.mu.Lock()
+			defer backend.mu.Unlock()
+			if backend.maxInFlight != tt.wantMaxInFlight {
+				t.Errorf("got %d queries in flight at most, want %d", backend.maxInFlight, tt.wantMaxInFlight)
+			}
+
+			var late int
+			for i, at := range backend.starts {
+				scheduled := time.Duration(i) * time.Minute / queriesPerMinute
+				if elapsed := at.Sub(start); elapsed < scheduled || elapsed > scheduled+slack {
+					late++
+				}
+			}
+			if tt.wantOnSchedule && late > 0 {
+				t.Errorf("got %d of %d queries off schedule, want all on schedule", late, len(backend.starts))
+			}
+			if !tt.wantOnSchedule && late == 0 {
+				t.Errorf("got all %d queries on schedule, want queries waiting for workers", len(backend.starts))
+			}
+
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, family := range families {
+				if family.GetName() != "log_querier_schedule_lag_seconds" {
+					continue
+				}
+				histogram := family.GetMetric()[0].GetHistogram()
+				if got := int(histogram.GetSampleCount()); got != len(backend.starts) {
+					t.Errorf("got %d schedule lags, want one per query started (%d)", got, len(backend.starts))
+				}
+				lag := time.Duration(histogram.GetSampleSum() * float64(time.Second))
+				if tt.wantOnSchedule && lag > slack || !tt.wantOnSchedule && lag < tt.wantMinLag {
+					t.Errorf("got schedule lags summing up to %s, want on schedule %t", lag, tt.wantOnSchedule)
+				}
+			}
+		})
+	}
+}
+
+func TestLogQuerierWindowCheck(t *testing.T) {
+	// The log line of the results is a day old, outside of the window of every query
+	old := time.Now().Add(-24 * time.Hour)
+	streams := loghttp.Streams{{
+		Labels:  loghttp.LabelSet{"client": "promtail"},
+		Entries: []loghttp.Entry{{Timestamp: old, Line: "line"}},
+	}}
+	hits := []clients.SearchHit{{ID: "doc", Source: json.RawMessage(fmt.Sprintf(`{"created_at":%q}`, old.Format(time.RFC3339)))}}
+
+	tests := []struct {
+		name     string
+		client   ClientType
+		query    Query
+		validate bool
+		wantErr  bool
+		// wantFailures is the number of failed window checks
+		wantFailures float64
+	}{
+		{
+			name:         "loki range",
+			client:       LokiClientType,
+			query:        Query{Query: `{client="promtail"}`, Expect: Expectation{Window: true}},
+			wantFailures: 1,
+		},
+		{
+			name:    "loki instant",
+			client:  LokiClientType,
+			query:   Query{Query: `count_over_time({client="promtail"}[1m])`, Kind: InstantQueryKind, Expect: Expectation{Window: true}},
+			wantErr: true,
+		},
+		{
+			name:         "elasticsearch referring to the window",
+			client:       ElasticsearchClientType,
+			query:        Query{Query: `{"query":{"range":{"created_at":{"gte":"{{.Start}}","lt":"{{.End}}"}}}}`, Range: time.Minute, Expect: Expectation{Window: true}},
+			wantFailures: 1,
+		},
+		{
+			name:    "elasticsearch without window",
+			client:  ElasticsearchClientType,
+			query:   Query{Query: `{"query":{"match_all":{}}}`, Expect: Expectation{Window: true}},
+			wantErr: true,
+		},
+		{
+			name:     "elasticsearch without window validated",
+			client:   ElasticsearchClientType,
+			query:    Query{Query: `{"query":{"match_all":{}}}`},
+			validate: true,
+		},
+		{
+			name:         "loki range validated",
+			client:       LokiClientType,
+			query:        Query{Query: `{client="promtail"}`},
+			validate:     true,
+			wantFailures: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Name = "query"
+			queries, err := NewQuerySet([]Query{tt.query}, RoundRobinQueryOrder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := NewLogQuerier(Options{
+				Client:                     tt.client,
+				ClientURL:                  "http://localhost:9200",
+				QueriesPerMinute:           1,
+				Workers:                    1,
+				QueryRange:                 "1m",
+				Queries:                    queries,
+				Hostnames:                  []string{"host"},
+				Direction:                  "backward",
+				LogFormat:                  "default",
+				ValidateResults:            tt.validate,
+				ElasticsearchBackend:       "elasticsearch7",
+				ElasticsearchIndex:         "logs",
+				ElasticsearchIndexStrategy: "static",
+			}, prometheus.NewRegistry())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			q.queryFrom = func(context.Context, request) (clients.QueryResult, error) {
+				if tt.client == LokiClientType {
+					return clients.QueryResult{Results: 1, Streams: streams}, nil
+				}
+				return clients.QueryResult{Results: 1, Hits: hits}, nil
+			}
+			q.runQuery(context.Background(), queryJob{query: queries.Next(), scheduled: time.Now()})
+
+			if got := testutil.ToFloat64(q.validationFailures.WithLabelValues("query", windowCheck)); got != tt.wantFailures {
+				t.Errorf("got %g failed window checks, want %g", got, tt.wantFailures)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package querier
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ViaQ/cluster-logging-load-client/internal/generator"
+)
+
+// timestamp prints as RFC 3339 in query templates, the methods of time.Time such as
+// Unix or Format remain available, e.g. {{.Now.UnixNano}}
+type timestamp struct {
+	time.Time
+}
+
+func (t timestamp) String() string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// templateData are the variables available to query templates. The labels and the
+// hostname are picked at random from the values logs are written with.
+type templateData struct {
+	Service   string
+	Level     string
+	Component string
+	Hostname  string
+	// Now is the time the query runs, Start and End the time window it covers
+	Now   timestamp
+	Start timestamp
+	End   timestamp
+}
+
+// window describes the time window a query covers relative to the time it runs
+type window struct {
+	// length is the length of the window, the default range is used if 0
+	length time.Duration
+	// minOffset and maxOffset bound the random offset the window ends before now
+	minOffset time.Duration
+	maxOffset time.Duration
+}
+
+// bounds returns the start and end of the window for a query running at now
+func (w window) bounds(now time.Time, defaultRange time.Duration) (time.Time, time.Time) {
+	length := w.length
+	if length == 0 {
+		length = defaultRange
+	}
+
+	offset := w.minOffset
+	if w.maxOffset > w.minOffset {
+		offset += time.Duration(rand.Int63n(int64(w.maxOffset - w.minOffset)))
+	}
+
+	end := now.Add(-offset)
+	return end.Add(-length), end
+}
+
+// parseOffset parses an offset given as a duration, e.g. 10m, or as a range of
+// durations an offset is picked from at random, e.g. 0-1h
+func parseOffset(offset string) (time.Duration, time.Duration, error) {
+	if offset == "" {
+		return 0, 0, nil
+	}
+
+	from, to, isRange := strings.Cut(offset, "-")
+	min, err := time.ParseDuration(strings.TrimSpace(from))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid offset %q: %s", offset, err)
+	}
+	max := min
+	if isRange {
+		if max, err = time.ParseDuration(strings.TrimSpace(to)); err != nil {
+			return 0, 0, fmt.Errorf("invalid offset %q: %s", offset, err)
+		}
+	}
+	if min < 0 || max < min {
+		return 0, 0, fmt.Errorf("invalid offset %q: must be positive and ascending", offset)
+	}
+	return min, max, nil
+}
+
+// render fills in the template of the query with the given hostname and returns it
+// along with the time window it covers
+func (q Query) render(now time.Time, defaultRange time.Duration, hostname string) (string, time.Time, time.Time, error) {
+	start, end := q.window.bounds(now, defaultRange)
+
+	var query strings.Builder
+	err := q.template.Execute(&query, templateData{
+		Service:   string(generator.RandomService()),
+		Level:     string(generator.RandomLevel()),
+		Component: string(generator.RandomComponent()),
+		Hostname:  hostname,
+		Now:       timestamp{now},
+		Start:     timestamp{start},
+		End:       timestamp{end},
+	})
+	if err != nil {
+		return "", start, end, fmt.Errorf("error rendering query %s: %s", q.Name, err)
+	}
+	return query.String(), start, end, nil
+}
+
+// refersToWindow tells whether the rendered query depends on its time window
+func (q Query) refersToWindow() bool {
+	var outside, inside strings.Builder
+	now := timestamp{time.Unix(0, 0)}
+	_ = q.template.Execute(&outside, templateData{Now: now})
+	_ = q.template.Execute(&inside, templateData{Now: now, Start: now, End: now})
+	return outside.String() != inside.String()
+}
+
+// parseTemplate parses the query template and the time window of the query
+func (q *Query) parseTemplate() error {
+	tmpl, err := template.New(q.Name).Parse(q.Query)
+	if err == nil {
+		// Unknown variables only surface once the template is executed
+		err = tmpl.Execute(io.Discard, templateData{})
+	}
+	if err != nil {
+		return fmt.Errorf("invalid query template %s: %s", q.Name, err)
+	}
+	if q.Range < 0 {
+		return fmt.Errorf("invalid range %s of query %s: must not be negative", q.Range, q.Name)
+	}
+
+	min, max, err := parseOffset(q.Offset)
+	if err != nil {
+		return fmt.Errorf("invalid query %s: %s", q.Name, err)
+	}
+
+	q.template = tmpl
+	q.window = window{length: q.Range, minOffset: min, maxOffset: max}
+	return nil
+}
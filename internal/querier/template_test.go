@@ -0,0 +1,75 @@
+package querier
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseOffset(t *testing.T) {
+	tests := []struct {
+		offset  string
+		wantMin time.Duration
+		wantMax time.Duration
+		wantErr bool
+	}{
+		{offset: ""},
+		{offset: "10m", wantMin: 10 * time.Minute, wantMax: 10 * time.Minute},
+		{offset: "0-1h", wantMax: time.Hour},
+		{offset: "5m - 15m", wantMin: 5 * time.Minute, wantMax: 15 * time.Minute},
+		{offset: "1h-1h", wantMin: time.Hour, wantMax: time.Hour},
+		{offset: "1h-5m", wantErr: true},
+		{offset: "-5m", wantErr: true},
+		{offset: "5m-", wantErr: true},
+		{offset: "ten minutes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.offset, func(t *testing.T) {
+			min, max, err := parseOffset(tt.offset)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if min != tt.wantMin || max != tt.wantMax {
+				t.Errorf("got offset %s to %s, want %s to %s", min, max, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestQueryRender(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		query     Query
+		want      string
+		wantStart time.Time
+	}{
+		{
+			name:      "hostname",
+			query:     Query{Name: "hostname", Query: `{hostname="{{.Hostname}}"}`},
+			want:      `{hostname="host-1"}`,
+			wantStart: now.Add(-time.Hour),
+		},
+		{
+			name:      "window",
+			query:     Query{Name: "window", Query: `{{.Start}} {{.End.Unix}}`, Range: 5 * time.Minute, Offset: "10m"},
+			want:      "2024-05-01T11:45:00Z 1714564200",
+			wantStart: now.Add(-15 * time.Minute),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.query.parseTemplate(); err != nil {
+				t.Fatal(err)
+			}
+			got, start, _, err := tt.query.render(now, time.Hour, "host-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || !start.Equal(tt.wantStart) {
+				t.Errorf("got %q from %s, want %q from %s", got, start, tt.want, tt.wantStart)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package querier
+
+import (
+	"fmt"
+
+	"github.com/ViaQ/cluster-logging-load-client/internal/clients"
+	"github.com/ViaQ/cluster-logging-load-client/internal/generator"
+
+	"github.com/grafana/loki/pkg/logql/syntax"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+const (
+	// resultCountCheck checks the number of results of a query
+	resultCountCheck = "results"
+
+	// formatCheck checks that the log lines parse in the log format written
+	formatCheck = "format"
+
+	// windowCheck checks that the log lines fall inside the time window of the query
+	windowCheck = "window"
+
+	// labelsCheck checks that the labels of the log lines match the stream selector
+	labelsCheck = "labels"
+)
+
+// Expectation describes the checks run on the results of a query
+type Expectation struct {
+	// MinResults and Results are the minimum and the exact number of results
+	MinResults *int `yaml:"minResults"`
+	Results    *int `yaml:"results"`
+	// Format checks that the log lines parse in the log format written
+	Format bool `yaml:"format"`
+	// Window checks that the log lines fall inside the time window of range queries,
+	// which Elasticsearch queries only cover if their template refers to it
+	Window bool `yaml:"window"`
+	// Labels checks that the stream labels of the log lines match the stream selector
+	// of Loki log queries
+	Labels bool `yaml:"labels"`
+}
+
+// enabled returns whether the expectation checks anything
+func (e Expectation) enabled() bool {
+	return e.MinResults != nil || e.Results != nil || e.Format || e.Window || e.Labels
+}
+
+// validationError describes a failed check of a query result
+type validationError struct {
+	check string
+	err   error
+}
+
+func (e validationError) Error() string {
+	return fmt.Sprintf("%s check failed: %s", e.check, e.err)
+}
+
+// validate runs the checks of the expectation on the result of the query and returns
+// the failed checks. Every check reports the first offending log line only.
+func (q *LogQuerier) validate(r request, expect Expectation, result clients.QueryResult) []validationError {
+	var failures []validationError
+	fail := func(check, format string, args ...interface{}) {
+		failures = append(failures, validationError{check: check, err: fmt.Errorf(format, args...)})
+	}
+
+	if expect.MinResults != nil && result.Results < *expect.MinResults {
+		fail(resultCountCheck, "got %d results, expected at least %d", result.Results, *expect.MinResults)
+	}
+	if expect.Results != nil && result.Results != *expect.Results {
+		fail(resultCountCheck, "got %d results, expected %d", result.Results, *expect.Results)
+	}
+	if !expect.Format && !expect.Window && !expect.Labels {
+		return failures
+	}
+
+	entries := streamEntries(result.Streams)
+	if result.Hits != nil {
+		var err error
+		if entries, err = hitEntries(result.Hits); err != nil {
+			fail(formatCheck, "%s", err)
+			return failures
+		}
+	}
+
+	var matchers []*labels.Matcher
+	if expect.Labels && result.Streams != nil {
+		selector, err := syntax.ParseLogSelector(r.text, true)
+		if err != nil {
+			fail(labelsCheck, "error parsing stream selector: %s", err)
+		} else {
+			matchers = selector.Matchers()
+		}
+	}
+	checkWindow := expect.Window
+
+	for _, e := range entries {
+		if expect.Format {
+			if _, _, err := generator.ParseLog(q.logFormat, e.Line); err != nil {
+				fail(formatCheck, "%s: %s", e.ID, err)
+				expect.Format = false
+			}
+		}
+		if checkWindow && (e.Timestamp.Before(r.start) || !e.Timestamp.Before(r.end)) {
+			fail(windowCheck, "%s: timestamp %s outside of [%s, %s)", e.ID, e.Timestamp, r.start, r.end)
+			checkWindow = false
+		}
+		for _, m := range matchers {
+			if !m.Matches(e.Labels[m.Name]) {
+				fail(labelsCheck, "%s: labels do not match %s", e.ID, m)
+				matchers = nil
+				break
+			}
+		}
+	}
+	return failures
+}
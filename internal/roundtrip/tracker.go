@@ -0,0 +1,218 @@
+package roundtrip
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result describes the outcome of reconciling a received log line
+type Result int
+
+const (
+	// Delivered is a line received for the first time
+	Delivered Result = iota
+	// DeliveredLate is a line received after it has been declared missing
+	DeliveredLate
+	// DeliveredOutOfOrder is a line received after a line with a higher sequence number
+	DeliveredOutOfOrder
+	// Duplicate is a line which has been received before
+	Duplicate
+)
+
+// Tracker reconciles the lines written by the generator with the lines read back
+// from storage. Lines are tracked per host using the message count as sequence number.
+type Tracker struct {
+	mu      sync.Mutex
+	streams map[string]*stream
+	// sentCount is the number of distinct lines sent
+	sentCount int64
+}
+
+type stream struct {
+	// sent holds the lines written but not received yet
+	sent map[int64]time.Time
+	// missing holds the lines which were not received in time
+	missing map[int64]time.Time
+	// done holds the received or missing sequence numbers above the watermark
+	done map[int64]struct{}
+	// watermark is the sequence number below which all lines are done
+	watermark int64
+	// highest is the highest sequence number received so far
+	highest int64
+}
+
+// NewTracker creates a new tracker
+func NewTracker() *Tracker {
+	return &Tracker{
+		streams: map[string]*stream{},
+	}
+}
+
+func (t *Tracker) stream(host string) *stream {
+	s, ok := t.streams[host]
+	if !ok {
+		s = &stream{
+			sent:    map[int64]time.Time{},
+			missing: map[int64]time.Time{},
+			done:    map[int64]struct{}{},
+			highest: -1,
+		}
+		t.streams[host] = s
+	}
+	return s
+}
+
+// Sent records a line written at the given time, a line sent again only updates the
+// time it was written
+func (t *Tracker) Sent(host string, seq int64, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.stream(host)
+	if _, ok := s.sent[seq]; !ok {
+		t.sentCount++
+	}
+	s.sent[seq] = at
+}
+
+// Forget drops a line recorded as sent which was never written
+func (t *Tracker) Forget(host string, seq int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.stream(host)
+	if _, ok := s.sent[seq]; ok {
+		delete(s.sent, seq)
+		t.sentCount--
+	}
+}
+
+// SentCount returns the number of distinct lines sent
+func (t *Tracker) SentCount() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.sentCount
+}
+
+// Received records a line read back at the given time. It returns the result of the
+// reconciliation and the latency between writing and reading the line, which is zero
+// if the line was not sent by this tracker.
+func (t *Tracker) Received(host string, seq int64, at time.Time) (Result, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.stream(host)
+	if sentAt, ok := s.missing[seq]; ok {
+		delete(s.missing, seq)
+		return DeliveredLate, at.Sub(sentAt)
+	}
+	if _, ok := s.done[seq]; ok || seq < s.watermark {
+		return Duplicate, 0
+	}
+
+	var latency time.Duration
+	if sentAt, ok := s.sent[seq]; ok {
+		delete(s.sent, seq)
+		latency = at.Sub(sentAt)
+	}
+	s.markDone(seq)
+
+	if seq < s.highest {
+		return DeliveredOutOfOrder, latency
+	}
+	s.highest = seq
+	return Delivered, latency
+}
+
+// Expire declares all lines written before the deadline as missing
+func (t *Tracker) Expire(deadline time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, s := range t.streams {
+		for seq, sentAt := range s.sent {
+			if sentAt.Before(deadline) {
+				delete(s.sent, seq)
+				s.missing[seq] = sentAt
+				s.markDone(seq)
+			}
+		}
+	}
+}
+
+// Pending returns the number of lines written but neither received nor missing
+func (t *Tracker) Pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, s := range t.streams {
+		n += len(s.sent)
+	}
+	return n
+}
+
+// MissingCount returns the number of lines not received in time
+func (t *Tracker) MissingCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, s := range t.streams {
+		n += len(s.missing)
+	}
+	return n
+}
+
+// Missing returns the sequence numbers of the missing lines per host as ranges
+func (t *Tracker) Missing() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	missing := map[string]string{}
+	for host, s := range t.streams {
+		if len(s.missing) == 0 {
+			continue
+		}
+
+		seqs := make([]int64, 0, len(s.missing))
+		for seq := range s.missing {
+			seqs = append(seqs, seq)
+		}
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+		missing[host] = formatRanges(seqs)
+	}
+	return missing
+}
+
+func (s *stream) markDone(seq int64) {
+	s.done[seq] = struct{}{}
+	for {
+		if _, ok := s.done[s.watermark]; !ok {
+			return
+		}
+		delete(s.done, s.watermark)
+		s.watermark++
+	}
+}
+
+func formatRanges(seqs []int64) string {
+	var ranges []string
+	for i := 0; i < len(seqs); {
+		j := i
+		for j+1 < len(seqs) && seqs[j+1] == seqs[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, fmt.Sprintf("%d", seqs[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", seqs[i], seqs[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
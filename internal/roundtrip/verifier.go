@@ -0,0 +1,386 @@
+package roundtrip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ViaQ/cluster-logging-load-client/internal/generator"
+	"github.com/ViaQ/cluster-logging-load-client/internal/querier"
+)
+
+const (
+	// queryLimit is the maximum number of lines requested per query
+	queryLimit = 5000
+
+	// DefaultLokiSelector is the stream selector matching logs pushed by the log generator
+	DefaultLokiSelector = `{client="promtail"}`
+)
+
+// Options describes the settings that can be modified for the roundtrip verifier
+type Options struct {
+	// RunID identifies the lines written during this run
+	RunID string
+	// Client describes the storage the lines are read back from
+	Client querier.ClientType
+	// LogFormat is the format the lines were written in
+	LogFormat generator.Format
+	// Selector is the Loki stream selector the lines are expected in
+	Selector string
+	// Interval is the time between two queries for the run's lines
+	Interval string
+	// Lookback is the time a line has to become queryable before it is reported missing
+	Lookback string
+}
+
+// Summary describes the outcome of a roundtrip run
+type Summary struct {
+	RunID            string            `json:"run_id"`
+	Sent             int64             `json:"sent"`
+	Received         int64             `json:"received"`
+	Missing          int64             `json:"missing"`
+	Duplicates       int64             `json:"duplicates"`
+	OutOfOrder       int64             `json:"out_of_order"`
+	Unparsable       int64             `json:"unparsable"`
+	QueryErrors      int64             `json:"query_errors"`
+	MinLatency       float64           `json:"min_latency_seconds"`
+	AvgLatency       float64           `json:"avg_latency_seconds"`
+	MaxLatency       float64           `json:"max_latency_seconds"`
+	MissingSequences map[string]string `json:"missing_sequences,omitempty"`
+}
+
+// Verifier reads the lines written by the log generator back from storage and
+// reconciles them to detect loss, duplication and out-of-order delivery.
+type Verifier struct {
+	opts     Options
+	querier  *querier.LogQuerier
+	tracker  *Tracker
+	interval time.Duration
+	lookback time.Duration
+	start    time.Time
+	seen     map[string]time.Time
+
+	mu           sync.Mutex
+	summary      Summary
+	latencyCount int64
+
+	sentCount       prometheus.CounterFunc
+	receivedCount   prometheus.Counter
+	duplicateCount  prometheus.Counter
+	outOfOrderCount prometheus.Counter
+	unparsableCount prometheus.Counter
+	queryErrorCount prometheus.Counter
+	missingGauge    prometheus.Gauge
+	pendingGauge    prometheus.Gauge
+	latency         prometheus.Histogram
+}
+
+// NewRunID returns a random identifier for a roundtrip run
+func NewRunID() string {
+	return fmt.Sprintf("roundtrip-%016x", rand.Uint64())
+}
+
+// NewVerifier creates a new verifier reading lines back with the given querier
+func NewVerifier(opts Options, q *querier.LogQuerier, registry *prometheus.Registry) (*Verifier, error) {
+	if opts.LogFormat == generator.RawFormat {
+		return nil, fmt.Errorf("log format %q does not carry a message count", opts.LogFormat)
+	}
+
+	interval, err := time.ParseDuration(opts.Interval)
+	if err != nil {
+		return nil, err
+	}
+	lookback, err := time.ParseDuration(opts.Lookback)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Selector == "" {
+		opts.Selector = DefaultLokiSelector
+	}
+
+	v := &Verifier{
+		opts:     opts,
+		querier:  q,
+		tracker:  NewTracker(),
+		interval: interval,
+		lookback: lookback,
+		seen:     map[string]time.Time{},
+		summary: Summary{
+			RunID: opts.RunID,
+		},
+		receivedCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "log_roundtrip_lines_received_total",
+			Help: "Total number of distinct lines read back from storage",
+		}),
+		duplicateCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "log_roundtrip_lines_duplicate_total",
+			Help: "Total number of lines read back from storage more than once",
+		}),
+		outOfOrderCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "log_roundtrip_lines_out_of_order_total",
+			Help: "Total number of lines read back after a line with a higher sequence number",
+		}),
+		unparsableCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "log_roundtrip_lines_unparsable_total",
+			Help: "Total number of lines read back without a sequence number",
+		}),
+		queryErrorCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "log_roundtrip_query_errors_total",
+			Help: "Total number of failed queries for the run's lines",
+		}),
+		missingGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "log_roundtrip_lines_missing",
+			Help: "Number of lines not read back within the lookback period",
+		}),
+		pendingGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "log_roundtrip_lines_pending",
+			Help: "Number of lines written but not read back yet",
+		}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "log_roundtrip_latency_seconds",
+			Help:    "Time between writing a line and the query reading it back from storage",
+			Buckets: prometheus.ExponentialBuckets(0.25, 2, 12),
+		}),
+	}
+	v.sentCount = prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "log_roundtrip_lines_sent_total",
+		Help: "Total number of lines written by the log generator",
+	}, func() float64 { return float64(v.tracker.SentCount()) })
+	registry.MustRegister(
+		v.sentCount,
+		v.receivedCount,
+		v.duplicateCount,
+		v.outOfOrderCount,
+		v.unparsableCount,
+		v.queryErrorCount,
+		v.missingGauge,
+		v.pendingGauge,
+		v.latency,
+	)
+
+	return v, nil
+}
+
+// Observe records a line about to be written by the log generator
+func (v *Verifier) Observe(host string, messageCount int64, at time.Time) {
+	v.tracker.Sent(host, messageCount, at)
+}
+
+// Forget drops a line the log generator failed to write
+func (v *Verifier) Forget(host string, messageCount int64) {
+	v.tracker.Forget(host, messageCount)
+}
+
+func (v *Verifier) Start(ctx context.Context, wg *sync.WaitGroup, _ chan<- error) {
+	v.start = time.Now().Truncate(time.Second)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		v.verifyLogs(ctx)
+	}()
+}
+
+func (v *Verifier) verifyLogs(ctx context.Context) {
+	ticker := time.NewTicker(v.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debug("Shutting down roundtrip verifier...")
+
+			v.drain()
+			v.printSummary()
+			return
+		case now := <-ticker.C:
+			v.poll(now)
+			v.tracker.Expire(now.Add(-v.lookback))
+		}
+		v.updateGauges()
+	}
+}
+
+// drain keeps polling until all lines written are read back or the lookback passed,
+// lines still pending then are reported missing
+func (v *Verifier) drain() {
+	deadline := time.Now().Add(v.lookback)
+	if pending := v.tracker.Pending(); pending > 0 {
+		log.Infof("Waiting up to %s for %d pending roundtrip lines...", v.lookback, pending)
+	}
+
+	for v.tracker.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(v.interval)
+		now := time.Now()
+		v.poll(now)
+		v.tracker.Expire(now.Add(-v.lookback))
+		v.updateGauges()
+	}
+	v.tracker.Expire(time.Now())
+}
+
+func (v *Verifier) poll(now time.Time) {
+	start := now.Add(-v.lookback)
+	if start.Before(v.start) {
+		start = v.start
+	}
+
+	for key, ts := range v.seen {
+		if ts.Before(start) {
+			delete(v.seen, key)
+		}
+	}
+
+	for {
+		entries, err := v.querier.FetchLogs(v.query(start, now), start, now, queryLimit)
+		if err != nil {
+			log.Errorf("error querying roundtrip lines: %s", err)
+			v.queryErrorCount.Inc()
+			v.mu.Lock()
+			v.summary.QueryErrors++
+			v.mu.Unlock()
+			return
+		}
+
+		// Entries are grouped by stream, restore the order they were written in
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Timestamp.Before(entries[j].Timestamp)
+		})
+
+		last := start
+		for _, e := range entries {
+			if e.Timestamp.After(last) {
+				last = e.Timestamp
+			}
+			v.reconcile(e, now)
+		}
+
+		// Continue with the next page unless all lines share the same timestamp,
+		// Elasticsearch searches are paged through by the querier
+		if v.opts.Client == querier.ElasticsearchClientType || len(entries) < queryLimit || !last.After(start) {
+			return
+		}
+		start = last
+	}
+}
+
+func (v *Verifier) query(start, end time.Time) string {
+	if v.opts.Client == querier.ElasticsearchClientType {
+		query := map[string]interface{}{
+			"query": map[string]interface{}{
+				"bool": map[string]interface{}{
+					"filter": []interface{}{
+						map[string]interface{}{
+							"match_phrase": map[string]interface{}{"body": v.opts.RunID},
+						},
+						map[string]interface{}{
+							"range": map[string]interface{}{
+								"created_at": map[string]interface{}{
+									"gte": start.UTC().Format(time.RFC3339),
+									"lte": end.UTC().Format(time.RFC3339),
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+		data, _ := json.Marshal(query)
+		return string(data)
+	}
+	return fmt.Sprintf("%s |= %q", v.opts.Selector, v.opts.RunID)
+}
+
+func (v *Verifier) reconcile(e querier.Entry, now time.Time) {
+	// A line delivered twice to the same stream differs in its timestamp only
+	key := fmt.Sprintf("%s\x00%d\x00%s", e.ID, e.Timestamp.UnixNano(), e.Line)
+	if _, ok := v.seen[key]; ok {
+		return
+	}
+	v.seen[key] = e.Timestamp
+
+	if !strings.Contains(e.Line, v.opts.RunID) {
+		return
+	}
+
+	host, seq, err := generator.ParseLog(v.opts.LogFormat, e.Line)
+	if err != nil {
+		log.Debugf("error parsing roundtrip line: %s", err)
+		v.unparsableCount.Inc()
+		v.mu.Lock()
+		v.summary.Unparsable++
+		v.mu.Unlock()
+		return
+	}
+
+	result, latency := v.tracker.Received(host, seq, now)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	switch result {
+	case Duplicate:
+		v.duplicateCount.Inc()
+		v.summary.Duplicates++
+		return
+	case DeliveredOutOfOrder:
+		v.outOfOrderCount.Inc()
+		v.summary.OutOfOrder++
+	}
+
+	v.receivedCount.Inc()
+	v.summary.Received++
+	if latency > 0 {
+		v.latency.Observe(latency.Seconds())
+		v.observeLatency(latency.Seconds())
+	}
+}
+
+func (v *Verifier) observeLatency(seconds float64) {
+	s := &v.summary
+	if s.MinLatency == 0 || seconds < s.MinLatency {
+		s.MinLatency = seconds
+	}
+	if seconds > s.MaxLatency {
+		s.MaxLatency = seconds
+	}
+	v.latencyCount++
+	s.AvgLatency += (seconds - s.AvgLatency) / float64(v.latencyCount)
+}
+
+func (v *Verifier) updateGauges() {
+	missing := v.tracker.MissingCount()
+	v.missingGauge.Set(float64(missing))
+	v.pendingGauge.Set(float64(v.tracker.Pending()))
+
+	v.mu.Lock()
+	v.summary.Sent = v.tracker.SentCount()
+	v.summary.Missing = int64(missing)
+	v.mu.Unlock()
+}
+
+func (v *Verifier) printSummary() {
+	v.updateGauges()
+
+	v.mu.Lock()
+	summary := v.summary
+	summary.MissingSequences = v.tracker.Missing()
+	v.mu.Unlock()
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		log.Errorf("error encoding roundtrip summary: %s", err)
+		return
+	}
+	// Stdout may carry the generated logs
+	fmt.Fprintln(os.Stderr, string(data))
+}
@@ -0,0 +1,199 @@
+package roundtrip
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ViaQ/cluster-logging-load-client/internal/generator"
+	"github.com/ViaQ/cluster-logging-load-client/internal/querier"
+)
+
+func TestVerifierReconcile(t *testing.T) {
+	sent := time.Now()
+	stream := `{client="promtail"}`
+	entry := func(seq int64, offset time.Duration) querier.Entry {
+		return querier.Entry{
+			ID:        stream,
+			Timestamp: sent.Add(offset),
+			Line:      fmt.Sprintf("goloader seq - host - %010d - run-1 payload", seq),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		entries []querier.Entry
+		want    Summary
+	}{
+		{
+			name:    "in order",
+			entries: []querier.Entry{entry(0, 0), entry(1, 0), entry(2, 0)},
+			want:    Summary{Received: 3},
+		},
+		{
+			name:    "same entry read twice",
+			entries: []querier.Entry{entry(0, 0), entry(0, 0), entry(1, 0)},
+			want:    Summary{Received: 2},
+		},
+		{
+			name:    "line delivered twice to the same stream",
+			entries: []querier.Entry{entry(0, 0), entry(0, time.Millisecond), entry(1, 0)},
+			want:    Summary{Received: 2, Duplicates: 1},
+		},
+		{
+			name:    "out of order",
+			entries: []querier.Entry{entry(1, 0), entry(0, 0), entry(2, 0)},
+			want:    Summary{Received: 3, OutOfOrder: 1},
+		},
+		{
+			name: "other run and unparsable lines",
+			entries: []querier.Entry{
+				entry(0, 0),
+				{ID: stream, Timestamp: sent, Line: "goloader seq - host - 0000000001 - run-2 payload"},
+				{ID: stream, Timestamp: sent, Line: "run-1 without sequence"},
+			},
+			want: Summary{Received: 1, Unparsable: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewVerifier(Options{
+				RunID:     "run-1",
+				LogFormat: generator.Format("default"),
+				Interval:  "1s",
+				Lookback:  "1m",
+			}, nil, prometheus.NewRegistry())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for seq := int64(0); seq < 3; seq++ {
+				v.Observe("host", seq, sent)
+			}
+
+			for _, e := range tt.entries {
+				v.reconcile(e, sent.Add(time.Second))
+			}
+
+			got := v.summary
+			if got.Received != tt.want.Received || got.Duplicates != tt.want.Duplicates ||
+				got.OutOfOrder != tt.want.OutOfOrder || got.Unparsable != tt.want.Unparsable {
+				t.Errorf("got received %d, duplicates %d, out of order %d, unparsable %d, want %d, %d, %d, %d",
+					got.Received, got.Duplicates, got.OutOfOrder, got.Unparsable,
+					tt.want.Received, tt.want.Duplicates, tt.want.OutOfOrder, tt.want.Unparsable)
+			}
+		})
+	}
+}
+
+// elasticsearchStandIn serves documents sharing the same created_at to search_after
+// searches sorted by _id
+type elasticsearchStandIn struct {
+	t         *testing.T
+	createdAt time.Time
+	lines     []string
+}
+
+func (s *elasticsearchStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var search struct {
+		Size        int           `json:"size"`
+		SearchAfter []interface{} `json:"search_after"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&search); err != nil {
+		s.t.Errorf("error decoding search: %s", err)
+	}
+
+	from := 0
+	if len(search.SearchAfter) == 2 {
+		fmt.Sscanf(search.SearchAfter[1].(string), "doc-%08d", &from)
+		from++
+	}
+	var hits []string
+	for i := from; i < len(s.lines) && len(hits) < search.Size; i++ {
+		source, _ := json.Marshal(generator.ElasticsearchLogContent{Body: s.lines[i], CreatedAt: s.createdAt})
+		hits = append(hits, fmt.Sprintf(`{"_id":"doc-%08d","_source":%s,"sort":[%d,"doc-%08d"]}`,
+			i, source, s.createdAt.UnixMilli(), i))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"took":1,"hits":{"total":%d,"hits":[%s]}}`, len(s.lines), strings.Join(hits, ","))
+}
+
+func TestVerifierPollElasticsearch(t *testing.T) {
+	// More lines than a page share the second they were written in
+	const lines = 2*queryLimit + 100
+	sent := time.Now().Truncate(time.Second)
+	standIn := &elasticsearchStandIn{t: t, createdAt: sent}
+	for seq := 0; seq < lines; seq++ {
+		standIn.lines = append(standIn.lines, fmt.Sprintf("goloader seq - host - %010d - run-1 payload", seq))
+	}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	q, err := querier.NewLogQuerier(querier.Options{
+		Client:                     querier.ElasticsearchClientType,
+		ClientURL:                  server.URL,
+		ElasticsearchBackend:       "elasticsearch6",
+		ElasticsearchIndex:         "logs",
+		ElasticsearchIndexStrategy: "static",
+		Direction:                  "forward",
+	}, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVerifier(Options{
+		RunID:     "run-1",
+		Client:    querier.ElasticsearchClientType,
+		LogFormat: generator.Format("default"),
+		Interval:  "1s",
+		Lookback:  "1m",
+	}, q, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.start = sent
+	for seq := int64(0); seq < lines; seq++ {
+		v.Observe("host", seq, sent)
+	}
+
+	v.poll(sent.Add(time.Second))
+	v.tracker.Expire(sent.Add(time.Millisecond))
+	v.updateGauges()
+
+	if v.summary.Received != lines || v.summary.Missing != 0 || v.summary.Sent != lines {
+		t.Errorf("got sent %d, received %d, missing %d, want %d lines received", v.summary.Sent,
+			v.summary.Received, v.summary.Missing, lines)
+	}
+}
+
+func TestVerifierForget(t *testing.T) {
+	v, err := NewVerifier(Options{
+		RunID:     "run-1",
+		LogFormat: generator.Format("default"),
+		Interval:  "1s",
+		Lookback:  "1m",
+	}, nil, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := time.Now()
+	v.Observe("host", 0, sent)
+	// A failed write is retried with the same message count
+	v.Observe("host", 1, sent)
+	v.Observe("host", 1, sent)
+	// The last line failed to be written when the run ended
+	v.Observe("host", 2, sent)
+	v.Forget("host", 2)
+
+	v.tracker.Expire(sent.Add(time.Second))
+	v.updateGauges()
+	if v.summary.Sent != 2 || v.summary.Missing != 2 {
+		t.Errorf("got sent %d, missing %d, want 2 and 2", v.summary.Sent, v.summary.Missing)
+	}
+}
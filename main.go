@@ -8,6 +8,7 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/ViaQ/cluster-logging-load-client/internal/web"
 	"github.com/prometheus/client_golang/prometheus"
@@ -18,6 +19,7 @@ import (
 	"github.com/ViaQ/cluster-logging-load-client/internal"
 	"github.com/ViaQ/cluster-logging-load-client/internal/generator"
 	"github.com/ViaQ/cluster-logging-load-client/internal/querier"
+	"github.com/ViaQ/cluster-logging-load-client/internal/roundtrip"
 )
 
 var (
@@ -27,21 +29,94 @@ var (
 
 func init() {
 	pflag.StringVar(&logLevel, "log-level", "error", "Overwrite to control the level of logs emitted. Allowed values: debug, info, warning, error")
-	pflag.StringVar(&opts.Command, "command", "generate", "Overwrite to control if logs are generated or queried. Allowed values: generate, query.")
-	pflag.StringVar(&opts.Destination, "destination", "stdout", "Overwrite to control where logs are queried or written to. Allowed values: loki, elasticsearch, stdout, file.")
+	pflag.StringVar(&opts.Command, "command", "generate", "Overwrite to control if logs are generated or queried. Allowed values: generate, query, roundtrip.")
+	pflag.StringVar(&opts.Destination, "destination", "stdout", "Overwrite to control where logs are queried or written to. Allowed values: loki, otlp, syslog, forward, kafka, splunk, http, elasticsearch, stdout, file, pods.")
 	pflag.StringVar(&opts.OutputFile, "file", "output.txt", "The name of the file to write logs to. Only available for \"File\" destinations.")
-	pflag.StringVar(&opts.ClientURL, "url", "", "URL of Promtail, LogCLI, or Elasticsearch client.")
+	pflag.StringVar(&opts.PodsDirectory, "pods-directory", "/var/log/pods", "The directory the <namespace>_<pod>_<uid>/<container>/<restart count>.log files of \"pods\" destinations are written to.")
+	pflag.IntVar(&opts.PodsNamespaces, "pods-namespaces", 1, "The number of namespaces simulated by \"pods\" destinations.")
+	pflag.IntVar(&opts.PodsPerNamespace, "pods-per-namespace", 10, "The number of pods per namespace simulated by \"pods\" destinations.")
+	pflag.IntVar(&opts.PodsContainers, "pods-containers", 1, "The number of containers per pod simulated by \"pods\" destinations.")
+	pflag.StringVar(&opts.PodsChurnInterval, "pods-churn-interval", "0s", "Interval at which a random pod of \"pods\" destinations is deleted along with its logs and replaced by a new pod. Pods are never replaced with 0s.")
+	pflag.StringVar(&opts.PodsRestartInterval, "pods-restart-interval", "0s", "Interval at which a random container of \"pods\" destinations restarts and writes to its next log file. Containers never restart with 0s.")
+	pflag.StringVar(&opts.ClientURL, "url", "", "URL of HTTP receiver, Loki push API, OTLP endpoint, Splunk HTTP Event Collector, syslog or forward server, comma separated Kafka brokers, LogCLI, or Elasticsearch client.")
 	pflag.BoolVar(&opts.DisableSecurityCheck, "disable-security-check", false, "Disable security check in HTTPS client.")
-	pflag.IntVar(&opts.LogsPerSecond, "logs-per-second", 1, "The rate to generate logs. This rate may not always be achievable.")
-	pflag.StringVar(&opts.LogType, "log-type", "simple", "Overwrite to control the type of logs generated. Allowed values: application, audit, simple, synthetic.")
+	pflag.Float64Var(&opts.LogsPerSecond, "logs-per-second", 1, "The rate to generate logs. Fractional rates such as 0.2 are allowed. This rate may not always be achievable.")
+	pflag.Float64Var(&opts.PacingJitter, "pacing-jitter", 0, "Fraction between 0 and 1 by which the interval between two logs varies randomly. Logs are spread evenly across each second by default.")
+	pflag.IntVar(&opts.Workers, "workers", 1, "The number of concurrent workers the rate is split across. Every worker writes its own sequence with the worker number appended to the hostname.")
+	pflag.StringVar(&opts.LoadProfile, "load-profile", "", "Semicolon separated phases varying the rate over time, e.g. \"ramp:from=100,to=10000,duration=30m;constant:rate=10000\". Allowed types: constant, ramp, step, sine, burst.")
+	pflag.StringVar(&opts.LoadProfileFile, "load-profile-file", "", "YAML file defining phases varying the rate over time. Takes precedence over --load-profile.")
+	pflag.StringVar(&opts.LogType, "log-type", "simple", "Overwrite to control the type of logs generated. Allowed values: application, audit, multiline, simple, synthetic.")
 	pflag.StringVar(&opts.LogFormat, "log-format", "default", "Overwrite to control the format of logs generated. Allowed values: default, crio (mimic CRIO output), csv, json, raw")
 	pflag.StringVar(&opts.LabelType, "label-type", "none", "Overwrite to control what labels are included in Loki logs. Allowed values: none, client, client-host")
 	pflag.BoolVar(&opts.UseRandomHostname, "use-random-hostname", false, "Ensures that the hostname field is unique by adding a random integer to the end.")
 	pflag.IntVar(&opts.SyntheticPayloadSize, "synthetic-payload-size", 100, "Overwrite to control size of synthetic log line.")
+	pflag.IntVar(&opts.CRIOPartialSize, "crio-partial-size", 0, "Size in bytes at which crio formatted lines are split into partial (P) records followed by a full (F) record, e.g. 16384 like container runtimes. Must fit the sequence header. 0 writes full records only.")
+	pflag.IntVar(&opts.MultilineFrameDepth, "multiline-frame-depth", 10, "Overwrite to control the number of frames of the stack traces of multiline logs.")
+	pflag.Int64Var(&opts.MaxLines, "max-lines", 0, "Stop generating logs after writing this many lines. Unlimited by default.")
+	pflag.Int64Var(&opts.MaxBytes, "max-bytes", 0, "Stop generating logs after writing this many bytes. Unlimited by default.")
+	pflag.StringVar(&opts.Duration, "duration", "", "Stop generating or querying logs after this duration, e.g. 10m. Unlimited by default.")
 	pflag.StringVar(&opts.Tenant, "tenant", "test", "Loki tenant ID for writing logs.")
-	pflag.IntVar(&opts.QueriesPerMinute, "queries-per-minute", 1, "The rate to generate queries. This rate may not always be achievable.")
+	pflag.IntVar(&opts.BatchSize, "batch-size", 1024*1024, "The number of bytes after which a batch of logs is sent.")
+	pflag.StringVar(&opts.BatchWait, "batch-wait", "1s", "The maximum time a log waits in a batch before the batch is sent.")
+	pflag.IntVar(&opts.MaxRetries, "max-retries", 5, "The number of attempts to send a batch before it is dropped. Batches are retried until the logger shuts down with 0.")
+	pflag.StringVar(&opts.MinBackoff, "min-backoff", "1s", "The initial delay before retrying a failed batch.")
+	pflag.StringVar(&opts.MaxBackoff, "max-backoff", "5s", "The maximum delay before retrying a failed batch.")
+	pflag.StringVar(&opts.Compression, "compression", "none", "Overwrite to control the compression of request bodies. Allowed values: none, gzip. HTTP destinations also support zstd, Kafka also supports snappy, lz4 and zstd.")
+	pflag.StringVar(&opts.LokiEncoding, "loki-encoding", "protobuf", "Overwrite to control the encoding of Loki push requests. Allowed values: protobuf (snappy compressed), json.")
+	pflag.StringVar(&opts.OTLPProtocol, "otlp-protocol", "http/protobuf", "Overwrite to control the transport and encoding of OTLP export requests. Allowed values: http/protobuf, http/json, grpc.")
+	pflag.StringVar(&opts.ForwardMode, "forward-mode", "forward", "Overwrite to control how events are packed into forward protocol messages. Allowed values: message, forward, packed-forward.")
+	pflag.BoolVar(&opts.ForwardRequireAck, "forward-require-ack", false, "Request an acknowledgement of every forward protocol message and resend unacknowledged messages.")
+	pflag.StringVar(&opts.ForwardAckTimeout, "forward-ack-timeout", "30s", "The time to wait for forward protocol acknowledgements before messages are resent.")
+	pflag.StringVar(&opts.ForwardSharedKey, "forward-shared-key", "", "The shared key to authenticate with in the forward protocol handshake.")
+	pflag.StringVar(&opts.KafkaTopic, "kafka-topic", "logs", "The Kafka topic to produce logs to.")
+	pflag.StringVar(&opts.KafkaPartitioner, "kafka-partitioner", "random", "Overwrite to control how logs are distributed over the partitions of the Kafka topic. Allowed values: random, round-robin, hostname.")
+	pflag.StringVar(&opts.KafkaAcks, "kafka-acks", "all", "Overwrite to control the acknowledgements the Kafka brokers send for every log. Allowed values: all, leader, none.")
+	pflag.StringVar(&opts.SplunkToken, "splunk-token", "", "The HTTP Event Collector token to authenticate with.")
+	pflag.StringVar(&opts.SplunkEndpoint, "splunk-endpoint", "event", "Overwrite to control the HTTP Event Collector endpoint logs are posted to. Allowed values: event, raw.")
+	pflag.StringVar(&opts.SplunkIndex, "splunk-index", "", "The Splunk index to write logs to. Defaults to the default index of the token.")
+	pflag.StringVar(&opts.SplunkSourceType, "splunk-sourcetype", "", "The Splunk sourcetype of logs.")
+	pflag.StringVar(&opts.SplunkSource, "splunk-source", "", "The Splunk source of logs.")
+	pflag.StringVar(&opts.SplunkChannel, "splunk-channel", "", "The HTTP Event Collector channel ID. Defaults to a random channel.")
+	pflag.BoolVar(&opts.SplunkAck, "splunk-ack", false, "Poll the indexer acknowledgement of every batch. Requires a token with indexer acknowledgement enabled.")
+	pflag.StringVar(&opts.SplunkAckTimeout, "splunk-ack-timeout", "60s", "The time after which batches which are not acknowledged count as failed.")
+	pflag.StringVar(&opts.HTTPMethod, "http-method", "POST", "The method of requests sent to \"http\" destinations.")
+	pflag.StringArrayVar(&opts.HTTPHeaders, "http-header", nil, "A \"Name: value\" header added to requests sent to \"http\" destinations. Can be repeated.")
+	pflag.StringVar(&opts.HTTPEncoding, "http-encoding", "ndjson", "Overwrite to control the body of requests sent to \"http\" destinations. Allowed values: ndjson, json (array), text (formatted log lines).")
+	pflag.StringVar(&opts.HTTPRetryStatusCodes, "http-retry-status-codes", "429,500,502,503,504", "Comma separated response status codes on which requests to \"http\" destinations are retried.")
+	pflag.StringVar(&opts.SyslogFormat, "syslog-format", "rfc5424", "Overwrite to control the format of syslog messages. Allowed values: rfc5424, rfc3164.")
+	pflag.StringVar(&opts.SyslogFraming, "syslog-framing", "octet-counting", "Overwrite to control how syslog messages are delimited on TCP and TLS connections. Allowed values: octet-counting, non-transparent. Newlines within non-transparent framed messages are escaped.")
+	pflag.StringVar(&opts.SyslogFacility, "syslog-facility", "user", "The facility of syslog messages, e.g. user, daemon or local0.")
+	pflag.StringVar(&opts.TLSCAFile, "tls-ca-file", "", "The CA bundle to verify the server with. Only available for \"syslog\", \"forward\" and \"elasticsearch\" destinations.")
+	pflag.StringVar(&opts.TLSCertFile, "tls-cert-file", "", "The client certificate to authenticate with. Only available for \"syslog\", \"forward\" and \"elasticsearch\" destinations.")
+	pflag.StringVar(&opts.TLSKeyFile, "tls-key-file", "", "The key of the client certificate. Only available for \"syslog\", \"forward\" and \"elasticsearch\" destinations.")
+	pflag.StringVar(&opts.ESBackend, "es-backend", "auto", "Overwrite to control the Elasticsearch API version used for writing and querying logs. Allowed values: auto (detected from the cluster version), elasticsearch6, elasticsearch7, elasticsearch8, opensearch.")
+	pflag.StringVar(&opts.ESUsername, "es-username", "", "The username to authenticate with against Elasticsearch.")
+	pflag.StringVar(&opts.ESPassword, "es-password", "", "The password to authenticate with against Elasticsearch.")
+	pflag.StringVar(&opts.ESAPIKey, "es-api-key", "", "The base64 encoded API key to authenticate with against Elasticsearch. Takes precedence over --es-username and --es-password.")
+	pflag.StringVar(&opts.ESIndex, "es-index", "logger", "The Elasticsearch index, index prefix, data stream or write alias logs are written to and queried from, depending on --es-index-strategy.")
+	pflag.StringVar(&opts.ESIndexStrategy, "es-index-strategy", "static", "Overwrite to control the Elasticsearch indices logs are written to. Allowed values: static (<index>), daily (<index>-YYYY.MM.DD), namespace (<index>-<namespace>), data-stream (<index>), alias (<index>, bootstrapped with <index>-000001).")
+	pflag.BoolVar(&opts.ESRecreateIndex, "es-recreate-index", false, "Delete the Elasticsearch indices or data stream matching --es-index and --es-index-strategy before writing logs.")
+	pflag.StringVar(&opts.ESTemplateFile, "es-index-template-file", "", "JSON index template named after --es-index which is applied before writing logs. Composable templates are used, except for Elasticsearch 6.")
+	pflag.StringVar(&opts.ESMappingsFile, "es-mappings-file", "", "JSON settings and mappings of the indices created before writing logs with the static and alias strategies.")
+	pflag.Float64Var(&opts.ESMaxFailureRatio, "es-max-failure-ratio", 1, "Fail the run once the fraction of documents Elasticsearch failed to write exceeds this ratio, e.g. 0.01. Never fails with 1.")
+	pflag.StringVar(&opts.LokiMetadata, "loki-structured-metadata", "", "Comma separated key=value pairs attached to every log as Loki structured metadata.")
+	pflag.IntVar(&opts.QueriesPerMinute, "queries-per-minute", 1, "The rate to generate queries. Queries are launched on schedule even while previous queries are still running.")
+	pflag.IntVar(&opts.QueryWorkers, "query-workers", 1, "Number of queries to run concurrently at most. Queries due while all workers are busy start late.")
+	pflag.StringSliceVar(&opts.QueryHostnames, "query-hostnames", nil, "Comma separated hostnames the {{.Hostname}} of query templates is picked from. Defaults to the hostnames the --workers of this logger write with, <hostname> or <hostname>-<worker>.")
 	pflag.StringVar(&opts.Query, "query", "", "Query to use to get logs from storage.")
+	pflag.StringVar(&opts.QueryFile, "query-file", "", "YAML list of queries to run instead of --query, e.g. config/loki_queries.yaml. Every entry is a query string or a mapping with name, query and weight.")
+	pflag.StringVar(&opts.QueryKind, "query-kind", "range", "Overwrite to control the Loki endpoint --query is sent to. Allowed values: range, instant, series, labels, label-values (--query is the label name), tail.")
+	pflag.IntVar(&opts.QueryLimit, "query-limit", 4000, "Maximum number of log lines returned by Loki queries and of documents read by Elasticsearch scroll and search-after queries which set no limit of their own.")
+	pflag.StringVar(&opts.QueryDirection, "query-direction", "forward", "Order of the log lines returned by Loki queries which set no direction of their own. Allowed values: forward, backward.")
+	pflag.BoolVar(&opts.QueryValidate, "query-validate", false, "Check that the log lines returned by queries parse in --log-format, fall inside the time window of the query and match its stream selector.")
+	pflag.BoolVar(&opts.QueryFailOnInvalid, "query-fail-on-invalid", false, "Stop the querier with an error once a query result fails a check.")
+	pflag.StringVar(&opts.QueryOrder, "query-order", "round-robin", "Overwrite to control the order in which the queries of --query-file run. Allowed values: round-robin (in turn, proportionally to the weights), weighted-random.")
 	pflag.StringVar(&opts.QueryRange, "query-range", "1s", "Duration of time period to query for logs (Loki only).")
+	pflag.StringVar(&opts.RunID, "run-id", "", "Identifier added to every log line of a roundtrip run. Defaults to a random identifier.")
+	pflag.StringVar(&opts.RoundtripDestination, "roundtrip-destination", "", "Overwrite to control where logs are read back from in roundtrip runs. Allowed values: loki, elasticsearch. Defaults to the destination.")
+	pflag.StringVar(&opts.RoundtripURL, "roundtrip-url", "", "URL of LogCLI or Elasticsearch client to read logs back from in roundtrip runs. Defaults to the URL.")
+	pflag.StringVar(&opts.RoundtripInterval, "roundtrip-interval", "10s", "Duration between two queries for the logs of a roundtrip run. Latencies are measured when a log is read back and have the resolution of the interval.")
+	pflag.StringVar(&opts.RoundtripLookback, "roundtrip-lookback", "5m", "Duration a log has to become queryable before it is reported missing in roundtrip runs.")
 
 	pflag.Parse()
 }
@@ -64,65 +139,280 @@ func main() {
 	switch opts.Command {
 	case "generate":
 		registry := prometheus.NewRegistry()
-		generatorOpts := generator.Options{
-			Client:               generator.ClientType(opts.Destination),
-			ClientURL:            opts.ClientURL,
-			FileName:             opts.OutputFile,
-			Tenant:               opts.Tenant,
-			DisableSecurityCheck: opts.DisableSecurityCheck,
-			LogsPerSecond:        opts.LogsPerSecond,
-			LogType:              opts.LogType,
-			LogFormat:            opts.LogFormat,
-			LabelType:            opts.LabelType,
-			SyntheticPayloadSize: opts.SyntheticPayloadSize,
-			UseRandomHostname:    opts.UseRandomHostname,
-		}
-		logGenerator, err := generator.NewLogGenerator(generatorOpts, registry)
+		logGenerator, err := generator.NewLogGenerator(generatorOptions(), registry)
 		if err != nil {
 			panic(err)
 		}
-		components := []internal.Component{
+		err = runComponents(
 			logGenerator,
 			web.NewServer(web.ServerConfig{
 				ListenAddress: ":8081",
 			}, log.StandardLogger(), registry),
+		)
+		if err != nil {
+			os.Exit(1)
+		}
+	case "query":
+		queries, err := querySet()
+		if err != nil {
+			panic(err)
+		}
+		querierOpts := querierOptions()
+		querierOpts.Queries = queries
+		if querierOpts.Hostnames, err = queryHostnames(); err != nil {
+			panic(err)
 		}
 
-		wg := &sync.WaitGroup{}
-		errCh := make(chan error, 1)
-		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-		defer cancel()
-
-		go func() {
-			for err := range errCh {
-				log.Errorf("Fatal error: %v", err)
-				cancel()
-			}
-		}()
+		registry := prometheus.NewRegistry()
+		logQuerier, err := querier.NewLogQuerier(querierOpts, registry)
+		if err != nil {
+			panic(err)
+		}
+		err = runComponents(
+			logQuerier,
+			web.NewServer(web.ServerConfig{
+				ListenAddress: ":8081",
+			}, log.StandardLogger(), registry),
+		)
+		if err != nil {
+			os.Exit(1)
+		}
+	case "roundtrip":
+		if opts.RunID == "" {
+			opts.RunID = roundtrip.NewRunID()
+		}
+		querierOpts := querierOptions()
+		if opts.RoundtripDestination != "" {
+			querierOpts.Client = querier.ClientType(opts.RoundtripDestination)
+		}
+		if opts.RoundtripURL != "" {
+			querierOpts.ClientURL = opts.RoundtripURL
+		}
+		registry := prometheus.NewRegistry()
+		logQuerier, err := querier.NewLogQuerier(querierOpts, registry)
+		if err != nil {
+			panic(err)
+		}
 
-		for _, c := range components {
-			c.Start(ctx, wg, errCh)
+		verifier, err := roundtrip.NewVerifier(roundtrip.Options{
+			RunID:     opts.RunID,
+			Client:    querierOpts.Client,
+			LogFormat: generator.Format(opts.LogFormat),
+			Selector:  opts.Query,
+			Interval:  opts.RoundtripInterval,
+			Lookback:  opts.RoundtripLookback,
+		}, logQuerier, registry)
+		if err != nil {
+			panic(err)
 		}
 
-		log.Debug("All components running.")
-		wg.Wait()
-		close(errCh)
-		log.Debug("All components stopped.")
-	case "query":
-		querierOpts := querier.Options{
-			Client:               querier.ClientType(opts.Destination),
-			ClientURL:            opts.ClientURL,
-			Tenant:               opts.Tenant,
-			DisableSecurityCheck: opts.DisableSecurityCheck,
-			QueriesPerMinute:     opts.QueriesPerMinute,
-			QueryRange:           opts.QueryRange,
-		}
-		logQuerier, err := querier.NewLogQuerier(querierOpts)
+		generatorOpts := generatorOptions()
+		generatorOpts.RunID = opts.RunID
+		generatorOpts.Observer = verifier
+		logGenerator, err := generator.NewLogGenerator(generatorOpts, registry)
 		if err != nil {
 			panic(err)
 		}
-		logQuerier.QueryLogs(opts.Query)
+		err = runComponents(
+			logGenerator,
+			verifier,
+			web.NewServer(web.ServerConfig{
+				ListenAddress: ":8081",
+			}, log.StandardLogger(), registry),
+		)
+		if err != nil {
+			os.Exit(1)
+		}
 	default:
 		panic(fmt.Errorf("unknown command :%s", opts.Command))
 	}
 }
+
+func generatorOptions() generator.Options {
+	return generator.Options{
+		Client:                       generator.ClientType(opts.Destination),
+		ClientURL:                    opts.ClientURL,
+		FileName:                     opts.OutputFile,
+		PodsDirectory:                opts.PodsDirectory,
+		PodsNamespaces:               opts.PodsNamespaces,
+		PodsPerNamespace:             opts.PodsPerNamespace,
+		PodsContainers:               opts.PodsContainers,
+		PodsChurnInterval:            opts.PodsChurnInterval,
+		PodsRestartInterval:          opts.PodsRestartInterval,
+		Tenant:                       opts.Tenant,
+		DisableSecurityCheck:         opts.DisableSecurityCheck,
+		LogsPerSecond:                opts.LogsPerSecond,
+		PacingJitter:                 opts.PacingJitter,
+		Workers:                      opts.Workers,
+		LoadProfile:                  opts.LoadProfile,
+		LoadProfileFile:              opts.LoadProfileFile,
+		LogType:                      opts.LogType,
+		LogFormat:                    opts.LogFormat,
+		LabelType:                    opts.LabelType,
+		SyntheticPayloadSize:         opts.SyntheticPayloadSize,
+		MultilineFrameDepth:          opts.MultilineFrameDepth,
+		CRIOPartialSize:              opts.CRIOPartialSize,
+		UseRandomHostname:            opts.UseRandomHostname,
+		BatchSize:                    opts.BatchSize,
+		BatchWait:                    opts.BatchWait,
+		MaxRetries:                   opts.MaxRetries,
+		MinBackoff:                   opts.MinBackoff,
+		MaxBackoff:                   opts.MaxBackoff,
+		Compression:                  opts.Compression,
+		LokiEncoding:                 opts.LokiEncoding,
+		LokiStructuredMetadata:       opts.LokiMetadata,
+		OTLPProtocol:                 opts.OTLPProtocol,
+		ForwardMode:                  opts.ForwardMode,
+		ForwardRequireAck:            opts.ForwardRequireAck,
+		ForwardAckTimeout:            opts.ForwardAckTimeout,
+		ForwardSharedKey:             opts.ForwardSharedKey,
+		KafkaTopic:                   opts.KafkaTopic,
+		KafkaPartitioner:             opts.KafkaPartitioner,
+		KafkaAcks:                    opts.KafkaAcks,
+		SplunkToken:                  opts.SplunkToken,
+		SplunkEndpoint:               opts.SplunkEndpoint,
+		SplunkIndex:                  opts.SplunkIndex,
+		SplunkSourceType:             opts.SplunkSourceType,
+		SplunkSource:                 opts.SplunkSource,
+		SplunkChannel:                opts.SplunkChannel,
+		SplunkAck:                    opts.SplunkAck,
+		SplunkAckTimeout:             opts.SplunkAckTimeout,
+		HTTPMethod:                   opts.HTTPMethod,
+		HTTPHeaders:                  opts.HTTPHeaders,
+		HTTPEncoding:                 opts.HTTPEncoding,
+		HTTPRetryStatusCodes:         opts.HTTPRetryStatusCodes,
+		SyslogFormat:                 opts.SyslogFormat,
+		SyslogFraming:                opts.SyslogFraming,
+		SyslogFacility:               opts.SyslogFacility,
+		TLSCAFile:                    opts.TLSCAFile,
+		TLSCertFile:                  opts.TLSCertFile,
+		TLSKeyFile:                   opts.TLSKeyFile,
+		ElasticsearchBackend:         opts.ESBackend,
+		ElasticsearchUsername:        opts.ESUsername,
+		ElasticsearchPassword:        opts.ESPassword,
+		ElasticsearchAPIKey:          opts.ESAPIKey,
+		ElasticsearchIndex:           opts.ESIndex,
+		ElasticsearchIndexStrategy:   opts.ESIndexStrategy,
+		ElasticsearchRecreateIndex:   opts.ESRecreateIndex,
+		ElasticsearchTemplateFile:    opts.ESTemplateFile,
+		ElasticsearchMappingsFile:    opts.ESMappingsFile,
+		ElasticsearchMaxFailureRatio: opts.ESMaxFailureRatio,
+		MaxLines:                     opts.MaxLines,
+		MaxBytes:                     opts.MaxBytes,
+	}
+}
+
+func querierOptions() querier.Options {
+	return querier.Options{
+		Client:                     querier.ClientType(opts.Destination),
+		ClientURL:                  opts.ClientURL,
+		Tenant:                     opts.Tenant,
+		DisableSecurityCheck:       opts.DisableSecurityCheck,
+		QueriesPerMinute:           opts.QueriesPerMinute,
+		Workers:                    opts.QueryWorkers,
+		Limit:                      opts.QueryLimit,
+		Direction:                  opts.QueryDirection,
+		LogFormat:                  opts.LogFormat,
+		ValidateResults:            opts.QueryValidate,
+		FailOnInvalidResults:       opts.QueryFailOnInvalid,
+		QueryRange:                 opts.QueryRange,
+		ElasticsearchBackend:       opts.ESBackend,
+		ElasticsearchUsername:      opts.ESUsername,
+		ElasticsearchPassword:      opts.ESPassword,
+		ElasticsearchAPIKey:        opts.ESAPIKey,
+		ElasticsearchIndex:         opts.ESIndex,
+		ElasticsearchIndexStrategy: opts.ESIndexStrategy,
+		TLSCAFile:                  opts.TLSCAFile,
+		TLSCertFile:                opts.TLSCertFile,
+		TLSKeyFile:                 opts.TLSKeyFile,
+	}
+}
+
+// queryHostnames returns the hostnames of the query templates, those the workers write
+// logs with unless given by flag
+func queryHostnames() ([]string, error) {
+	if len(opts.QueryHostnames) > 0 {
+		return opts.QueryHostnames, nil
+	}
+	return generator.Hostnames(opts.Workers)
+}
+
+// querySet returns the queries of the query file, or the single query given by flag
+func querySet() (*querier.QuerySet, error) {
+	query := querier.Query{Name: "query", Query: opts.Query, Kind: querier.QueryKind(opts.QueryKind)}
+	if query.Kind == querier.LabelValuesQueryKind {
+		// The label to list the values of is given as the query
+		query.Label, query.Query = opts.Query, ""
+	}
+	queries := []querier.Query{query}
+	if opts.QueryFile != "" {
+		var err error
+		if queries, err = querier.LoadQueries(opts.QueryFile); err != nil {
+			return nil, err
+		}
+	}
+	return querier.NewQuerySet(queries, querier.QueryOrder(opts.QueryOrder))
+}
+
+// runContext returns a context which is done on SIGTERM or once the run duration passed
+func runContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if opts.Duration == "" {
+		return ctx, cancel
+	}
+
+	duration, err := time.ParseDuration(opts.Duration)
+	if err != nil {
+		cancel()
+		panic(fmt.Errorf("invalid duration %q: %s", opts.Duration, err))
+	}
+	ctx, cancelTimeout := context.WithTimeout(ctx, duration)
+	return ctx, func() {
+		cancelTimeout()
+		cancel()
+	}
+}
+
+// runComponents runs the components until they are done and returns the first fatal
+// error reported by a component
+func runComponents(components ...internal.Component) error {
+	wg := &sync.WaitGroup{}
+	errCh := make(chan error, 1)
+	ctx, cancel := runContext()
+	defer cancel()
+
+	var fatal error
+	errDone := make(chan struct{})
+	go func() {
+		defer close(errDone)
+		for err := range errCh {
+			log.Errorf("Fatal error: %v", err)
+			if fatal == nil {
+				fatal = err
+			}
+			cancel()
+		}
+	}()
+
+	for _, c := range components {
+		c.Start(ctx, wg, errCh)
+
+		if b, ok := c.(internal.Bounded); ok {
+			go func() {
+				select {
+				case <-b.Done():
+					log.Debug("Component finished, shutting down...")
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+		}
+	}
+
+	log.Debug("All components running.")
+	wg.Wait()
+	close(errCh)
+	<-errDone
+	log.Debug("All components stopped.")
+	return fatal
+}